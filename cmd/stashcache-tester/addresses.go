@@ -0,0 +1,109 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// Values for the addresses setting
+const (
+	// AddressesDualStack tests one IPv4 and one IPv6 address of dnsname
+	AddressesDualStack = "dualstack"
+	// AddressesAll tests every address dnsname resolves to, for caches
+	// behind round-robin DNS
+	AddressesAll = "all"
+)
+
+// addressBackends are the backends that can be pointed at a single address
+var addressBackends = map[string]bool{"xrdcp": true, "native": true, "http": true, "https": true, "curl": true}
+
+// host returns the address to connect to for the test set's cache, an IP
+// literal when testing a single address of dnsname
+func (ts TestSet) host() string {
+	host := ts.address
+	if host == "" {
+		host = ts.hostname()
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// expandAddresses returns the test set once for each address it should be
+// run against.  If dnsname can't be resolved the test set is run against the
+// name as usual so the failure is reported by the transfer.
+func expandAddresses(ts TestSet) []TestSet {
+	if ts.Addresses == "" {
+		return []TestSet{ts}
+	}
+	ips, err := net.LookupIP(ts.hostname())
+	if err != nil || len(ips) == 0 {
+		infof("Can't resolve %s, testing it by name: %v\n", ts.DNSName, err)
+		return []TestSet{ts}
+	}
+	var expanded []TestSet
+	seen := make(map[int]bool)
+	seenIP := make(map[string]bool)
+	for _, ip := range ips {
+		version := 6
+		if ip.To4() != nil {
+			version = 4
+		}
+		if seenIP[ip.String()] || (seen[version] && ts.Addresses == AddressesDualStack) {
+			continue
+		}
+		seen[version] = true
+		seenIP[ip.String()] = true
+		variant := ts
+		variant.address = ip.String()
+		variant.ipVersion = version
+		expanded = append(expanded, variant)
+	}
+	for _, version := range []int{4, 6} {
+		if !seen[version] && ts.Addresses == AddressesDualStack {
+			infof("%s has no IPv%d address\n", ts.DNSName, version)
+		}
+	}
+	return expanded
+}
+
+type dialAddressKey struct{}
+
+// withDialAddress makes HTTP requests using ctx connect to address instead of
+// the address the url's host resolves to, keeping the host name for the Host
+// header and TLS
+func withDialAddress(ctx context.Context, address string) context.Context {
+	if address == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, dialAddressKey{}, address)
+}
+
+// dialContext is the HTTP transport's dialer, honouring withDialAddress
+func dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if address, ok := ctx.Value(dialAddressKey{}).(string); ok {
+		if _, port, err := net.SplitHostPort(addr); err == nil {
+			addr = net.JoinHostPort(address, port)
+		}
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,44 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "fmt"
+
+// fail records a failure in a test set.  Test sets carry on with their
+// other files after one fails so every file's state is reported, the first
+// failure is kept as the test set's error.
+func (r *TestResult) fail(err error) {
+	r.success = false
+	if r.result == nil {
+		r.result = err
+	}
+}
+
+// aggregate returns the result with its error saying how many files failed
+// when it's more than one
+func (r TestResult) aggregate() TestResult {
+	failed := 0
+	for _, f := range r.files {
+		if f.ErrorClass != "" {
+			failed++
+		}
+	}
+	if failed > 1 {
+		r.result = classify(errorClass(r.result), fmt.Errorf("%d of %d files failed, the first: %s", failed, len(r.files), r.result))
+	}
+	return r
+}
@@ -0,0 +1,109 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+// timedAttempts is how many attempts the payload has start and end slots
+// for, later attempts only count towards tries
+const timedAttempts = 3
+
+// maxAttempts limits attempts so a dead cache can't hold up a run for hours
+const maxAttempts = 10
+
+// maxBackoff caps the wait between attempts
+const maxBackoff = 5 * time.Minute
+
+// transientClasses are the failures worth trying again, a missing file or
+// broken config won't fix itself
+var transientClasses = map[string]bool{
+	ErrorClassTransfer:  true,
+	ErrorClassTimeout:   true,
+	ErrorClassTruncated: true,
+	ErrorClassStalled:   true,
+}
+
+// backoff returns how long to wait before the given retry, doubling the
+// test set's backoff each time
+func backoff(ts TestSet, retry int) time.Duration {
+	wait := time.Duration(ts.Backoff)
+	for i := 1; i < retry && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+// downloadAttempts downloads a test file, trying again up to the test set's
+// attempts while it fails with transient errors.  The start and end of the
+// first three attempts go in the payload's Start1-3 and End1-3 and the exit
+// codes of the first two in XRDExit1 and XRDExit2, as stashcp reports them,
+// with the errors of earlier attempts in AttemptErrors.  The rest of the
+// payload is the last attempt's.  The attempts aren't reported on their
+// own, the caller reports the merged payload once whether it succeeded or
+// not.
+func downloadAttempts(ctx context.Context, ts TestSet, remotePath string, filename string, timeout time.Duration) (string, ESPayload, int, error) {
+	ts.interimCollector, ts.Collector = ts.Collector, nil
+	var starts, ends [timedAttempts]int64
+	var exits [timedAttempts]string
+	var attemptErrors []string
+	var uri string
+	var payload ESPayload
+	var fallbacks int
+	var err error
+	tries := 0
+	for {
+		ts.attempt = tries + 1
+		ts.attemptErrors = attemptErrors
+		uri, payload, fallbacks, err = downloadTestPath(ctx, ts, remotePath, filename, timeout)
+		if tries < timedAttempts {
+			starts[tries], ends[tries], exits[tries] = payload.Start1, payload.End1, payload.XRDExit1
+		}
+		tries++
+		if err == nil || tries >= ts.Attempts || !transientClasses[errorClass(err)] {
+			break
+		}
+		attemptErrors = append(attemptErrors, strings.TrimSpace(err.Error()))
+		wait := backoff(ts, tries)
+		infof("Trying %s again in %s, attempt %d of %d\n", remotePath, wait, tries+1, ts.Attempts)
+		if sleepContext(ctx, wait) != nil {
+			break
+		}
+		// xrdcp won't overwrite a partial download
+		os.Remove(filename)
+	}
+	payload.Start1, payload.Start2, payload.Start3 = starts[0], starts[1], starts[2]
+	payload.End1, payload.End2, payload.End3 = ends[0], ends[1], ends[2]
+	payload.XRDExit1, payload.XRDExit2 = exits[0], exits[1]
+	payload.Tries = tries
+	return uri, payload, fallbacks, err
+}
+
+// tries is the number of attempts so far for the payload of a download
+func (ts TestSet) tries() int {
+	if ts.attempt == 0 {
+		return 1
+	}
+	return ts.attempt
+}
@@ -0,0 +1,122 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backend downloads test files from a cache using one protocol or client
+type Backend interface {
+	// URL returns the url of a remote path on the test set's cache
+	URL(ts TestSet, remotePath string) string
+	// Download fetches uri into filename, usually a path in the test set's
+	// working directory, failures are reported to the collectors before
+	// returning
+	Download(ctx context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error)
+}
+
+// DefaultBackend is used by test sets that don't set backend
+const DefaultBackend = "xrdcp"
+
+var backends = make(map[string]Backend)
+
+// unavailableBackends explains why backends left out of this build can't
+// be used
+var unavailableBackends = make(map[string]string)
+
+// registerBackend makes a backend available to the backend config setting,
+// backends register themselves from init functions
+func registerBackend(name string, backend Backend) {
+	backends[name] = backend
+}
+
+// backendNames lists the available backends for help and error messages
+func backendNames() string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// lookupBackend returns the test set's backend
+func lookupBackend(ts TestSet) (Backend, error) {
+	backend, ok := backends[ts.Backend]
+	if reason, unavailable := unavailableBackends[ts.Backend]; !ok && unavailable {
+		return nil, fmt.Errorf("backend %q isn't available: %s", ts.Backend, reason)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q, available backends are %s", ts.Backend, backendNames())
+	}
+	return backend, nil
+}
+
+// fallbackBackends are the backends that download from dnsname, so can
+// stand in for each other in a fallback chain
+var fallbackBackends = map[string]bool{"xrdcp": true, "native": true, "http": true, "https": true, "curl": true, "davix": true}
+
+// downloadWithFallback downloads remotePath with the test set's backend,
+// trying each of its fallback backends in turn until one succeeds like
+// clients that support several protocols do.  It returns the uri and payload
+// of the last attempt and how many fallbacks were needed.
+func downloadWithFallback(ctx context.Context, ts TestSet, remotePath string, filename string, timeout time.Duration) (string, ESPayload, int, error) {
+	var uri string
+	var payload ESPayload
+	var err error
+	for i, name := range append([]string{ts.Backend}, ts.Fallback...) {
+		attemptTS := ts
+		attemptTS.Backend = name
+		// payloads reported during the download, such as Hung, carry it
+		attemptTS.fallbacks = i
+		backend, lookupErr := lookupBackend(attemptTS)
+		if lookupErr != nil {
+			return uri, payload, i, classify(ErrorClassSetup, lookupErr)
+		}
+		if i > 0 {
+			infof("Falling back to the %s backend for %s\n", name, remotePath)
+		}
+		uri = backend.URL(attemptTS, remotePath)
+		payload, err = backend.Download(ctx, uri, filename, attemptTS, timeout)
+		// backends that fail early may not have filled in the payload
+		payload.Fallbacks = i
+		if err == nil {
+			return uri, payload, i, nil
+		}
+	}
+	return uri, payload, len(ts.Fallback), err
+}
+
+// xrdcpBackend runs the xrootd client's xrdcp
+type xrdcpBackend struct{}
+
+func init() {
+	registerBackend("xrdcp", xrdcpBackend{})
+}
+
+func (xrdcpBackend) URL(ts TestSet, remotePath string) string {
+	return "root://" + ts.endpoint("root") + "/" + remotePath
+}
+
+func (xrdcpBackend) Download(ctx context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	return DownloadXRDFile(ctx, uri, filename, ts, timeout)
+}
@@ -0,0 +1,229 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// BenchStats summarises one measurement over a benchmark's transfers
+type BenchStats struct {
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min"`
+	Median  float64 `json:"median"`
+	P95     float64 `json:"p95"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+// BenchPayload is the aggregate payload reported by the bench command
+type BenchPayload struct {
+	TestType        string      `json:"test_type"`
+	Cache           string      `json:"cache"`
+	Host            string      `json:"host"`
+	FileName        string      `json:"filename"`
+	URL             string      `json:"url"`
+	Backend         string      `json:"backend"`
+	Transfers       int         `json:"transfers"`
+	Failures        int         `json:"failures"`
+	DownloadSize    int64       `json:"download_size"`
+	Duration        float64     `json:"duration"`
+	Throughput      BenchStats  `json:"throughput"`
+	Latency         *BenchStats `json:"latency,omitempty"`
+	TimeStamp       int64       `json:"timestamp"`
+	XRDcpVersion    string      `json:"xrdcp_version"`
+	TesterCommit    string      `json:"tester_commit,omitempty"`
+	TesterBuildDate string      `json:"tester_build_date,omitempty"`
+}
+
+// benchCommand downloads one file from one cache over and over and reports
+// percentiles of the throughput and time to first byte, for commissioning
+// and tuning caches
+func benchCommand(args []string) int {
+	flags := newFlagSet("bench", "[options] <cache> <path>")
+	backend := flags.String("backend", DefaultBackend, "backend to download with")
+	count := flags.Int("count", 10, "number of transfers")
+	duration := flags.Duration("duration", 0, "keep transferring for this long instead of --count transfers")
+	timeout := flags.Duration("timeout", time.Duration(builtinDefaults.Timeout), "timeout for each transfer")
+	httpPort := flags.Int("http-port", defaultPorts["http"], "HTTP port")
+	httpsPort := flags.Int("https-port", defaultPorts["https"], "HTTPS port")
+	scratchDir := flags.String("scratch-dir", builtinDefaults.ScratchDir, "directory to download the file into")
+	var collectors stringList
+	flags.Var(&collectors, "collector",
+		"url of an ES collector to report the results to (repeatable, default: $"+CollectorEnvVar+", then "+ESCollector+")")
+	noReport := flags.Bool("no-report", false, "don't report the results to ES")
+	jsonOutput := flags.Bool("json", false, "print the results as json")
+	verbose := flags.Bool("verbose", false, "also print every transfer")
+	flags.Parse(args)
+	if flags.NArg() != 2 || *count < 1 {
+		flags.Usage()
+		return ExitConfigError
+	}
+	if *verbose {
+		verbosity = verboseOutput
+	}
+
+	ts := builtinDefaults
+	ts.SiteName = "bench"
+	ts.TestSetName = "bench"
+	ts.DNSName = flags.Arg(0)
+	ts.Backend = *backend
+	ts.HTTPPort, ts.HTTPSPort = Port(*httpPort), Port(*httpsPort)
+	ts.Timeout = Duration(*timeout)
+	// only the aggregate is reported
+	ts.Collector = nil
+	if ts.Backend == "xrdcp" {
+		ts.Timings = true
+	}
+	if _, err := lookupBackend(ts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	remotePath := flags.Arg(1)
+
+	workDir, err := ioutil.TempDir(*scratchDir, "stashcache-tester-bench-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't create directory in %s: %s\n", *scratchDir, err)
+		return ExitConfigError
+	}
+	defer os.RemoveAll(workDir)
+	ts.workDir = workDir
+	filename := ts.localPath(remotePath)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	bench := BenchPayload{
+		TestType:        "bench",
+		Cache:           ts.DNSName,
+		Host:            ts.DNSName,
+		FileName:        path.Base(remotePath),
+		Backend:         ts.Backend,
+		XRDcpVersion:    clientVersion("stashcache-tester-bench"),
+		TesterCommit:    commit,
+		TesterBuildDate: buildDate,
+	}
+	var throughputs, latencies []float64
+	start := time.Now()
+	for i := 0; ; i++ {
+		if *duration > 0 && time.Since(start) >= *duration || *duration == 0 && i >= *count || ctx.Err() != nil {
+			break
+		}
+		uri, payload, _, err := downloadTestPath(ctx, ts, remotePath, filename, *timeout)
+		os.Remove(filename)
+		bench.URL = uri
+		bench.Transfers++
+		if err != nil {
+			bench.Failures++
+			continue
+		}
+		bench.DownloadSize = payload.DownloadSize
+		throughputs = append(throughputs, throughput(payload))
+		// xrdcp only logs when the open returned
+		latency := payload.TTFB
+		if latency == 0 {
+			latency = payload.OpenTime
+		}
+		if latency > 0 {
+			latencies = append(latencies, latency)
+		}
+		debugf("Transfer %d: %s in %.0fms, %.2f MB/s\n", i+1, ByteSize(payload.DownloadSize), payload.DownloadTime, throughput(payload))
+	}
+	bench.Duration = time.Since(start).Seconds() * 1000
+	bench.Throughput = benchStats(throughputs)
+	if len(latencies) > 0 {
+		latency := benchStats(latencies)
+		bench.Latency = &latency
+	}
+	bench.TimeStamp = nowMillis()
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(bench)
+	} else {
+		printBench(bench)
+	}
+	exitCode := ExitSuccess
+	if bench.Failures > 0 {
+		exitCode = ExitTestFailure
+	}
+	if !*noReport {
+		destinations := collectorOverride(collectors)
+		if len(destinations) == 0 {
+			destinations = []string{ESCollector}
+		}
+		// postPayload has printed why
+		if err := reportPayload(ctx, bench, destinations); err != nil && exitCode == ExitSuccess {
+			exitCode = ExitReportFailure
+		}
+	}
+	return exitCode
+}
+
+// benchStats returns the minimum, nearest rank percentiles and maximum of
+// values
+func benchStats(values []float64) BenchStats {
+	if len(values) == 0 {
+		return BenchStats{}
+	}
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+		if rank < 1 {
+			rank = 1
+		}
+		return sorted[rank-1]
+	}
+	return BenchStats{
+		Samples: len(sorted),
+		Min:     sorted[0],
+		Median:  percentile(50),
+		P95:     percentile(95),
+		P99:     percentile(99),
+		Max:     sorted[len(sorted)-1],
+	}
+}
+
+func printBench(bench BenchPayload) {
+	fmt.Printf("%s with %s: %d transfers of %s, %d failed, in %s\n", bench.URL, bench.Backend, bench.Transfers,
+		ByteSize(bench.DownloadSize), bench.Failures, time.Duration(bench.Duration*float64(time.Millisecond)).Round(time.Millisecond))
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "\tMIN\tMEDIAN\tP95\tP99\tMAX\t")
+	printRow := func(name string, s BenchStats) {
+		fmt.Fprintf(table, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", name, s.Min, s.Median, s.P95, s.P99, s.Max)
+	}
+	if bench.Throughput.Samples > 0 {
+		printRow("MB/s", bench.Throughput)
+	}
+	if bench.Latency != nil {
+		name := "first byte (ms)"
+		if bench.Backend == "xrdcp" {
+			name = "open (ms)"
+		}
+		printRow(name, *bench.Latency)
+	}
+	table.Flush()
+}
@@ -0,0 +1,133 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the certificate test type checks the certificate of a cache's HTTPS port:
+// that it chains to a trusted CA, matches dnsname and isn't about to expire
+func init() {
+	registerTestType("certificate", runCertificateTest)
+}
+
+// CertificateCheck records the certificate a cache presented
+type CertificateCheck struct {
+	TLSVersion  string    `json:"tls_version"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotAfter    time.Time `json:"not_after"`
+	DaysLeft    int       `json:"days_left"`
+	Verified    bool      `json:"verified"`
+	VerifyError string    `json:"verify_error,omitempty"`
+}
+
+func runCertificateTest(ctx context.Context, ts TestSet) TestResult {
+	addr := net.JoinHostPort(strings.Trim(ts.host(), "[]"), strconv.Itoa(ts.port("https")))
+	uri := "https://" + ts.endpoint("https")
+	result := TestResult{files: []FileSummary{{Path: uri, URL: uri, Status: StatusNotRun}}}
+	payload := newFilePayload("stashcache-tester-certificate", ts, "")
+	payload.TestType = ts.Type
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+	check, err := checkCertificate(ts.hostname(), addr, time.Duration(ts.Timeout))
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+	result.files[0].Duration = end.Sub(start)
+	if err == nil {
+		payload.Certificate = &check
+		if !check.Verified {
+			err = classify(ErrorClassCertificate, fmt.Errorf("certificate isn't valid: %s", check.VerifyError))
+		}
+	}
+	if err != nil {
+		fmt.Printf("Certificate check of %s failed: %s\n", uri, err)
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		ReportTest(ctx, payload, ts.Collector)
+		result.files[0].Status = payload.Status
+		result.files[0].setError(err)
+		result.result = classify(errorClass(err), fmt.Errorf("certificate check of %s failed: %s", uri, err))
+		return result
+	}
+	payload.Status = "Success"
+	if check.DaysLeft < ts.CertWarnDays {
+		infof("The certificate of %s expires in %d days, on %s\n", uri, check.DaysLeft, check.NotAfter.Format("2006-01-02"))
+		payload.Status = StatusDegraded
+		result.degraded = true
+	}
+	debugf("The certificate of %s for %s expires in %d days\n", uri, check.Subject, check.DaysLeft)
+	ReportTest(ctx, payload, ts.Collector)
+	result.files[0].Status = payload.Status
+	result.success = true
+	return result
+}
+
+// checkCertificate does a TLS handshake with addr and checks the
+// certificate it presents for host.  The handshake doesn't verify it so
+// untrusted certificates are still recorded.
+func checkCertificate(host string, addr string, timeout time.Duration) (CertificateCheck, error) {
+	var check CertificateCheck
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host, InsecureSkipVerify: true})
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return check, classify(ErrorClassTimeout, fmt.Errorf("TLS handshake with %s timed out", addr))
+		}
+		return check, classify(ErrorClassTransfer, fmt.Errorf("TLS handshake with %s failed: %s", addr, err))
+	}
+	defer conn.Close()
+	state := conn.ConnectionState()
+	check.TLSVersion = tlsVersionName(state.Version)
+	if len(state.PeerCertificates) == 0 {
+		check.VerifyError = "no certificate"
+		return check, nil
+	}
+	cert := state.PeerCertificates[0]
+	check.Subject = cert.Subject.String()
+	check.Issuer = cert.Issuer.String()
+	check.NotAfter = cert.NotAfter
+	check.DaysLeft = int(time.Until(cert.NotAfter).Hours() / 24)
+	if err := verifyCertificate(host, state.PeerCertificates); err != nil {
+		check.VerifyError = err.Error()
+		return check, nil
+	}
+	check.Verified = true
+	return check, nil
+}
+
+// verifyCertificate checks that a server's certificate chain is trusted by
+// the grid and system CAs and is for host
+func verifyCertificate(host string, chain []*x509.Certificate) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{Roots: gridCertPool(), DNSName: host, Intermediates: intermediates})
+	return err
+}
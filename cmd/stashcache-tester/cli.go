@@ -0,0 +1,281 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// interruptContext returns the root context of a command, cancelled by SIGINT
+// or SIGTERM so every transfer and report in flight stops
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// command is a stashcache-tester subcommand, run returns the exit code
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "run the configured tests and report results (default)", runCommand},
+		{"validate", "check a config for problems", validateCommand},
+		{"list", "list the sites and test sets in a config", listCommand},
+		{"init", "write a starter config", initCommand},
+		{"report", "send saved payloads to the ES collector", reportCommand},
+		{"probe", "check which services a cache exposes", probeCommand},
+		{"bench", "download a file repeatedly and report throughput percentiles", benchCommand},
+		{"fixtures", "create fixture files and their hash file on an origin", fixturesCommand},
+		{"version", "print version information", versionCommand},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the options of a command.\n", os.Args[0])
+}
+
+// newFlagSet returns a flag set for a subcommand with a usage message
+// showing the expected arguments
+func newFlagSet(name string, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s %s\n", os.Args[0], name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// addConfigFlag adds --config and --set to a command that loads a config
+func addConfigFlag(flags *flag.FlagSet) *string {
+	flags.Var(&configSets, "set",
+		"override a config value, e.g. defaults.timeout=20m or testsets[FILE_SIZE_TEST].dnsname=host (repeatable)")
+	return flags.String("config", "",
+		"path or https:// url of config file, - reads it from stdin (default: $"+ConfigEnvVar+", then "+strings.Join(defaultConfigLocations, ", ")+")")
+}
+
+// listCommand prints a table of the test sets a config would run
+func listCommand(args []string) int {
+	flags := newFlagSet("list", "[options] [config]")
+	configFlag := addConfigFlag(flags)
+	var profiles stringList
+	flags.Var(&profiles, "profile", "only list the sites in this profile from the config (repeatable)")
+	flags.Parse(args)
+	if flags.NArg() > 0 {
+		*configFlag = flags.Arg(0)
+	}
+
+	configLocation, err := findConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	testSets, err := selectProfiles(configLocation, profiles)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "SITE\tTEST SET\tCACHE\tFILES\tEXPECTED BYTES")
+	totalFiles := 0
+	var totalBytes ByteSize
+	allSized := true
+	for _, ts := range testSets {
+		bytes, sized := expectedBytes(ts)
+		allSized = allSized && sized
+		totalFiles += len(ts.TestFiles)
+		totalBytes += bytes
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%s\n", ts.SiteName, ts.TestSetName, ts.DNSName,
+			len(ts.TestFiles), formatExpectedBytes(bytes, sized))
+	}
+	fmt.Fprintf(table, "TOTAL\t%d test sets\t\t%d\t%s\n", len(testSets), totalFiles,
+		formatExpectedBytes(totalBytes, allSized))
+	table.Flush()
+	return 0
+}
+
+// expectedBytes sums the sizes given for a test set's files, sized is false
+// if any file has no size in the config
+func expectedBytes(ts TestSet) (total ByteSize, sized bool) {
+	sized = true
+	for _, testFile := range ts.TestFiles {
+		if testFile.Size == 0 {
+			sized = false
+		}
+		total += testFile.Size
+	}
+	return total, sized
+}
+
+func formatExpectedBytes(bytes ByteSize, sized bool) string {
+	if sized {
+		return fmt.Sprintf("%s (%d)", bytes, int64(bytes))
+	}
+	if bytes == 0 {
+		return "unknown"
+	}
+	return fmt.Sprintf(">= %s (%d)", bytes, int64(bytes))
+}
+
+// reportCommand sends payloads saved as json, either a list or one payload
+// per line, to the ES collector, or with flush the spooled payloads
+func reportCommand(args []string) int {
+	if len(args) > 0 && args[0] == "flush" {
+		return reportFlushCommand(args[1:])
+	}
+	flags := newFlagSet("report", "[options] <payload file>...")
+	var collectors stringList
+	flags.Var(&collectors, "collector",
+		"url of an ES collector to report to (repeatable, default: $"+CollectorEnvVar+", then "+ESCollector+")")
+	flags.StringVar(&spoolDir, "spool-dir", os.Getenv(SpoolDirEnvVar), "keep payloads the collectors couldn't take in this directory (default: $"+SpoolDirEnvVar+")")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return ExitConfigError
+	}
+
+	destinations := collectorOverride(collectors)
+	if len(destinations) == 0 {
+		destinations = []string{ESCollector}
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	failed := 0
+	for _, name := range flags.Args() {
+		payloads, err := readPayloads(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitConfigError
+		}
+		for _, payload := range payloads {
+			if ReportTest(ctx, payload, destinations) != nil {
+				failed++
+			}
+		}
+		fmt.Printf("%s: sent %d payloads\n", name, len(payloads))
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d payloads couldn't be reported\n", failed)
+		return ExitReportFailure
+	}
+	return ExitSuccess
+}
+
+// reportFlushCommand sends the payloads in the spool directory to the
+// collectors they were meant for
+func reportFlushCommand(args []string) int {
+	flags := newFlagSet("report flush", "[options]")
+	dir := flags.String("spool-dir", os.Getenv(SpoolDirEnvVar), "the spool directory (default: $"+SpoolDirEnvVar+")")
+	flags.Parse(args)
+	if *dir == "" || flags.NArg() > 0 {
+		flags.Usage()
+		return ExitConfigError
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	sent, left, err := flushSpool(ctx, *dir)
+	fmt.Printf("Sent %d spooled payloads\n", sent)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitReportFailure
+	}
+	if left > 0 {
+		fmt.Fprintf(os.Stderr, "%d spool files couldn't be sent\n", left)
+		return ExitReportFailure
+	}
+	return ExitSuccess
+}
+
+func readPayloads(name string) ([]ESPayload, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't open payload file %s: %s", name, err)
+	}
+	defer f.Close()
+
+	var payloads []ESPayload
+	decoder := json.NewDecoder(f)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't decode payloads from %s: %s", name, err)
+		}
+		var batch []ESPayload
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			err = json.Unmarshal(raw, &batch)
+		} else {
+			batch = make([]ESPayload, 1)
+			err = json.Unmarshal(raw, &batch[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't decode payloads from %s: %s", name, err)
+		}
+		payloads = append(payloads, batch...)
+	}
+	return payloads, nil
+}
+
+func versionCommand(args []string) int {
+	flags := newFlagSet("version", "")
+	flags.Parse(args)
+	fmt.Println(versionString())
+	return 0
+}
+
+func main() {
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 {
+		switch {
+		case args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help":
+			usage()
+			return
+		case args[0] == "-version" || args[0] == "--version":
+			fmt.Println(versionString())
+			return
+		case !strings.HasPrefix(args[0], "-"):
+			name, args = args[0], args[1:]
+		}
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(args))
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
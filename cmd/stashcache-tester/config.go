@@ -0,0 +1,537 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigEnvVar names the environment variable consulted for the config
+// location when --config isn't given
+const ConfigEnvVar = "STASHCACHE_TESTER_CONFIG"
+
+// StdinConfig as the config location reads the config from stdin
+const StdinConfig = "-"
+
+// defaultConfigLocations are searched in order when neither --config nor
+// ConfigEnvVar is set
+var defaultConfigLocations = []string{
+	"siteconfig.json",
+	"siteconfig.yaml",
+	"/etc/stashcache-tester/siteconfig.json",
+	"/etc/stashcache-tester/siteconfig.yaml",
+	"/etc/stashcache-tester/conf.d",
+}
+
+// findConfig returns the config location to use, preferring an explicit
+// path, then ConfigEnvVar, then the first existing default location
+func findConfig(explicit string) (string, error) {
+	if isRemoteConfig(explicit) || explicit == StdinConfig {
+		return explicit, nil
+	}
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", fmt.Errorf("can't find config file %s: %s", explicit, err)
+		}
+		return explicit, nil
+	}
+	if envLocation := os.Getenv(ConfigEnvVar); envLocation != "" {
+		if isRemoteConfig(envLocation) {
+			return envLocation, nil
+		}
+		if _, err := os.Stat(envLocation); err != nil {
+			return "", fmt.Errorf("can't find config file %s (from $%s): %s", envLocation, ConfigEnvVar, err)
+		}
+		return envLocation, nil
+	}
+	for _, location := range defaultConfigLocations {
+		if _, err := os.Stat(location); err == nil {
+			return location, nil
+		}
+	}
+	return "", fmt.Errorf("can't find a config file, use --config or set $%s; searched: %s",
+		ConfigEnvVar, strings.Join(defaultConfigLocations, ", "))
+}
+
+// readConfig returns the contents of a local config file, https:// url or
+// stdin
+func readConfig(configLocation string) ([]byte, error) {
+	if isRemoteConfig(configLocation) {
+		return fetchRemoteConfig(configLocation)
+	}
+	if configLocation == StdinConfig {
+		fileContents, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("can't read config from stdin: %s", err)
+		}
+		return fileContents, nil
+	}
+	fileContents, err := ioutil.ReadFile(configLocation)
+	if err != nil {
+		return nil, fmt.Errorf("can't read config file %s: %s", configLocation, err)
+	}
+	return fileContents, nil
+}
+
+// Config is the top level of a config file.  A config may also be a bare
+// list of test sets, which is treated as a Config with only TestSets set
+type Config struct {
+	Defaults  TestSet             `json:"defaults"`
+	TestSets  []TestSet           `json:"testsets"`
+	Profiles  map[string][]string `json:"profiles"`
+	Reporters []Reporter          `json:"reporters"`
+}
+
+// builtinDefaults fill in settings not given by a test set or the config's
+// defaults block
+var builtinDefaults = TestSet{
+	Timeout:         Duration(600 * time.Second),
+	Backoff:         Duration(5 * time.Second),
+	TimeoutOverhead: Duration(30 * time.Second),
+	HashAlgorithm:   "sha256",
+	HashFormat:      "coreutils",
+	Collector:       CollectorList{ESCollector},
+	ScratchDir:      os.TempDir(),
+	Backend:         DefaultBackend,
+	Director:        DefaultDirector,
+	Type:            DefaultTestType,
+	UploadSize:      1 << 20,
+	CertWarnDays:    14,
+}
+
+// Duration is a time.Duration that's given in the config as a number of
+// seconds or a string such as "90s" or "10m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", v)
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("duration must be a number of seconds or a string like \"90s\"")
+	}
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// applyDefaults fills in every unset field of ts from defaults, maps such as
+// xrdenv are merged with the test set's entries taking precedence
+func applyDefaults(ts *TestSet, defaults TestSet) {
+	target := reflect.ValueOf(ts).Elem()
+	source := reflect.ValueOf(defaults)
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Field(i)
+		defaultValue := source.Field(i)
+		if !field.CanSet() || isZero(defaultValue) {
+			continue
+		}
+		if field.Kind() == reflect.Map {
+			merged := reflect.MakeMap(field.Type())
+			for _, m := range []reflect.Value{defaultValue, field} {
+				for _, key := range m.MapKeys() {
+					merged.SetMapIndex(key, m.MapIndex(key))
+				}
+			}
+			field.Set(merged)
+		} else if isZero(field) {
+			field.Set(defaultValue)
+		}
+	}
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+// TestFile is a remote file to download, given in the config either as a
+// path or an object with per-file settings
+type TestFile struct {
+	Path     string   `json:"path"`
+	Timeout  Duration `json:"timeout,omitempty"`
+	Size     ByteSize `json:"size,omitempty"`
+	SHA256   string   `json:"sha256,omitempty"`
+	Checksum string   `json:"checksum,omitempty"`
+	Fixture  string   `json:"fixture,omitempty"`
+	Tier     string   `json:"tier,omitempty"`
+}
+
+func (f *TestFile) UnmarshalJSON(data []byte) error {
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*f = TestFile{Path: path}
+		return nil
+	}
+	// a distinct type so json doesn't recurse back into this method
+	type rawTestFile TestFile
+	var raw rawTestFile
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("test file must be a path or an object with a path: %s", err)
+	}
+	*f = TestFile(raw)
+	return nil
+}
+
+// timeout returns the file's own timeout, or if it has none and its size is
+// known and the test set has a minbandwidth, the time to transfer it at that
+// speed plus timeoutoverhead.  Otherwise it's the test set's timeout.
+func (f TestFile) timeout(ts TestSet) time.Duration {
+	if f.Timeout > 0 {
+		return time.Duration(f.Timeout)
+	}
+	if ts.MinBandwidth > 0 && f.Size > 0 {
+		transfer := float64(f.Size) / bytesPerMB / ts.MinBandwidth * float64(time.Second)
+		return time.Duration(transfer) + time.Duration(ts.TimeoutOverhead)
+	}
+	return time.Duration(ts.Timeout)
+}
+
+// ByteSize is a size in bytes, given in the config as a number or a string
+// with a K, M, G or T suffix (powers of 1024) such as "100M"
+type ByteSize int64
+
+var byteSizeUnits = []string{"B", "K", "M", "G", "T"}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	switch v := raw.(type) {
+	case float64:
+		*b = ByteSize(v)
+		return nil
+	case string:
+		parsed, err := parseByteSize(v)
+		if err != nil {
+			return err
+		}
+		*b = parsed
+		return nil
+	}
+	return fmt.Errorf("size must be a number of bytes or a string like \"100M\"")
+}
+
+func parseByteSize(value string) (ByteSize, error) {
+	text := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B"), "I")
+	multiplier := int64(1)
+	for i, unit := range byteSizeUnits {
+		if i > 0 && strings.HasSuffix(text, unit) {
+			text = strings.TrimSuffix(text, unit)
+			multiplier = int64(1) << (10 * uint(i))
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return ByteSize(number * float64(multiplier)), nil
+}
+
+// Set parses a command line size
+func (b *ByteSize) Set(value string) error {
+	parsed, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = parsed
+	return nil
+}
+
+func (b ByteSize) String() string {
+	value := float64(b)
+	unit := 0
+	for value >= 1024 && unit < len(byteSizeUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", int64(b))
+	}
+	return fmt.Sprintf("%.1f%s", value, byteSizeUnits[unit])
+}
+
+// CollectorList holds ES collector urls, the config may give a single url
+// or a list
+type CollectorList []string
+
+func (c *CollectorList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*c = CollectorList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("collector must be a url or a list of urls")
+	}
+	*c = list
+	return nil
+}
+
+// XRDEnv holds XRD_* client settings passed to xrdcp.  Values may be given as
+// numbers or strings in the config
+type XRDEnv map[string]string
+
+func (e *XRDEnv) UnmarshalJSON(data []byte) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*e = make(XRDEnv)
+	for key, value := range raw {
+		if !strings.HasPrefix(key, "XRD_") {
+			return fmt.Errorf("xrdenv key %s doesn't start with XRD_", key)
+		}
+		switch v := value.(type) {
+		case string:
+			(*e)[key] = v
+		case float64, bool:
+			(*e)[key] = fmt.Sprint(v)
+		default:
+			return fmt.Errorf("xrdenv value for %s must be a string or number", key)
+		}
+	}
+	return nil
+}
+
+// merge returns a copy of e with the values in override replacing its own
+func (e XRDEnv) merge(override XRDEnv) XRDEnv {
+	merged := make(XRDEnv, len(e)+len(override))
+	for key, value := range e {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
+
+// loadConfigFile reads a JSON or YAML config with environment variables
+// expanded and global settings applied to each test set.  If configLocation
+// is a directory, every config file in it is loaded and merged.
+func loadConfigFile(configLocation string) (*Config, error) {
+	if !isRemoteConfig(configLocation) {
+		if info, err := os.Stat(configLocation); err == nil && info.IsDir() {
+			return loadConfigDir(configLocation)
+		}
+	}
+	fileContents, err := readConfig(configLocation)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeConfig(configLocation, fileContents)
+	if err != nil {
+		return nil, err
+	}
+	for i := range config.TestSets {
+		applyDefaults(&config.TestSets[i], config.Defaults)
+		// reporters replace the built in collector, even when they're all
+		// disabled
+		useReporters := len(config.Reporters) > 0 && len(config.TestSets[i].Collector) == 0
+		applyDefaults(&config.TestSets[i], builtinDefaults)
+		if useReporters {
+			config.TestSets[i].Collector = reporterCollectors(config.Reporters)
+		}
+		// the tester changes directory while running
+		if scratchDir, err := filepath.Abs(config.TestSets[i].ScratchDir); err == nil {
+			config.TestSets[i].ScratchDir = scratchDir
+		}
+	}
+	return config, nil
+}
+
+// loadConfigDir loads the *.json, *.yaml and *.yml files in dir in lexical
+// order and concatenates their test sets.  The defaults and reporters in
+// each file only apply to the test sets in that file.
+func loadConfigDir(dir string) (*Config, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read config directory %s: %s", dir, err)
+	}
+	merged := &Config{}
+	found := false
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		found = true
+		config, err := loadConfigFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		merged.TestSets = append(merged.TestSets, config.TestSets...)
+		merged.Reporters = append(merged.Reporters, config.Reporters...)
+		// profiles defined in several files list the sites from all of them
+		for name, sites := range config.Profiles {
+			if merged.Profiles == nil {
+				merged.Profiles = make(map[string][]string)
+			}
+			merged.Profiles[name] = append(merged.Profiles[name], sites...)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("config directory %s doesn't contain any .json, .yaml or .yml files", dir)
+	}
+	return merged, nil
+}
+
+// groupBySite groups test sets by their SiteName
+func groupBySite(testSets []TestSet) map[string][]TestSet {
+	grouped := make(map[string][]TestSet)
+	for _, val := range testSets {
+		grouped[val.SiteName] = append(grouped[val.SiteName], val)
+	}
+	return grouped
+}
+
+// isYAMLConfig guesses the config format from the file extension, falling
+// back to the contents since JSON documents must start with [ or {
+func isYAMLConfig(name string, contents []byte) bool {
+	if isRemoteConfig(name) {
+		if u, err := url.Parse(name); err == nil {
+			name = u.Path
+		}
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return true
+	case ".json":
+		return false
+	}
+	trimmed := bytes.TrimSpace(contents)
+	return len(trimmed) > 0 && trimmed[0] != '[' && trimmed[0] != '{'
+}
+
+// decodeConfig parses config contents, YAML is converted to JSON first so
+// both formats share the same decoding.  ${VAR} references are expanded
+// before the values are checked, so they can be used for any field.
+// Unknown fields and values of the wrong type are rejected, listing every
+// problem found.
+func decodeConfig(name string, contents []byte) (*Config, error) {
+	format := "json"
+	if isYAMLConfig(name, contents) {
+		format = "yaml"
+		generic, err := parseYAML(contents)
+		if err != nil {
+			return nil, fmt.Errorf("can't decode yaml from config file %s: %s", name, err)
+		}
+		if contents, err = json.Marshal(generic); err != nil {
+			return nil, fmt.Errorf("can't convert yaml from config file %s: %s", name, err)
+		}
+	}
+	var generic interface{}
+	if err := json.Unmarshal(contents, &generic); err != nil {
+		if format == "json" {
+			err = jsonErrorWithLine(contents, err)
+		}
+		return nil, fmt.Errorf("can't decode %s from config file %s: %s", format, name, err)
+	}
+	if len(configSets) > 0 {
+		var err error
+		if generic, err = applyOverrides(generic, configSets); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if contents, err = json.Marshal(generic); err != nil {
+			return nil, fmt.Errorf("can't apply --set to config file %s: %s", name, err)
+		}
+		// line numbers would refer to the rewritten config
+		format = "overridden " + format
+	}
+	if configVarPattern.Match(contents) {
+		var err error
+		if generic, err = expandConfigVars(generic); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if contents, err = json.Marshal(generic); err != nil {
+			return nil, fmt.Errorf("can't expand variables in config file %s: %s", name, err)
+		}
+		format = "expanded " + format
+	}
+	if errs := checkConfigStructure(generic); len(errs) > 0 {
+		return nil, &ConfigErrors{name, errs}
+	}
+
+	config := &Config{}
+	var err error
+	if trimmed := bytes.TrimSpace(contents); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(contents, &config.TestSets)
+	} else {
+		err = json.Unmarshal(contents, config)
+	}
+	if err != nil {
+		if format == "json" {
+			err = jsonErrorWithLine(contents, err)
+		}
+		return nil, fmt.Errorf("can't decode %s from config file %s: %s", format, name, err)
+	}
+	return config, nil
+}
+
+// jsonErrorWithLine adds the line number to json syntax and type errors
+func jsonErrorWithLine(contents []byte, err error) error {
+	var offset int64
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		offset = e.Offset
+	case *json.UnmarshalTypeError:
+		offset = e.Offset
+	default:
+		return err
+	}
+	if offset > int64(len(contents)) {
+		offset = int64(len(contents))
+	}
+	line := bytes.Count(contents[:offset], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, err)
+}
@@ -0,0 +1,39 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// StatusDegraded is reported for downloads that succeeded but were slower
+// than their test set's minthroughput, and for test sets with any such
+// download.  Degraded test sets don't count as failures.
+const StatusDegraded = "Degraded"
+
+// bytesPerMB converts byte counts to the MB used by minthroughput
+const bytesPerMB = 1024 * 1024
+
+// throughput returns the payload's download speed in MB/s
+func throughput(payload ESPayload) float64 {
+	if payload.DownloadTime <= 0 {
+		return 0
+	}
+	return float64(payload.DownloadSize) / bytesPerMB / (payload.DownloadTime / 1000)
+}
+
+// isDegraded reports whether a successful download was slower than the
+// test set allows
+func isDegraded(ts TestSet, payload ESPayload) bool {
+	return ts.MinThroughput > 0 && payload.DownloadTime > 0 && throughput(payload) < ts.MinThroughput
+}
@@ -0,0 +1,334 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// esCollectorPrefix marks collector urls that are Elasticsearch clusters
+// written to directly instead of collector relays, such as
+// es+https://es.example.org:9200/stashcache-tests-%Y.%m
+const esCollectorPrefix = "es+"
+
+// ESAPIKeyEnvVar names the environment variable with the API key for
+// Elasticsearch collectors that give neither a user nor an apikeyfile
+const ESAPIKeyEnvVar = "STASHCACHE_TESTER_ES_APIKEY"
+
+// esMappingVersion is recorded in every document written to Elasticsearch
+// as mapping_version and replaces %v in index patterns.  It goes up when
+// payload fields change type so new documents can go to new indices.
+const esMappingVersion = 1
+
+// esCollector is an Elasticsearch cluster and the index pattern documents
+// are written to
+type esCollector struct {
+	base     string
+	index    string
+	username string
+	password string
+	apiKey   string
+}
+
+func isESCollector(collector string) bool {
+	return strings.HasPrefix(collector, esCollectorPrefix)
+}
+
+// parseESCollector parses an es+http:// or es+https:// collector url.  The
+// path is the index pattern, user info gives basic auth and the apikeyfile
+// and passwordfile parameters read secrets from files.
+func parseESCollector(collector string) (*esCollector, error) {
+	// the % in index patterns aren't url escapes, so the path and query are
+	// split off before parsing the rest
+	raw, rawQuery := strings.TrimPrefix(collector, esCollectorPrefix), ""
+	if i := strings.Index(raw, "?"); i >= 0 {
+		raw, rawQuery = raw[:i], raw[i+1:]
+	}
+	var index string
+	if i := strings.Index(raw, "://"); i >= 0 {
+		if j := strings.Index(raw[i+3:], "/"); j >= 0 {
+			raw, index = raw[:i+3+j], strings.Trim(raw[i+3+j:], "/")
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Elasticsearch collector: %s", err)
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Elasticsearch collector parameters: %s", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Elasticsearch collector must be es+http:// or es+https:// and a host")
+	}
+	if index == "" || strings.Contains(index, "/") {
+		return nil, fmt.Errorf("Elasticsearch collector must give the index pattern as its path")
+	}
+	c := &esCollector{base: u.Scheme + "://" + u.Host, index: index}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if passwordFile := query.Get("passwordfile"); passwordFile != "" {
+		password, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read Elasticsearch password: %s", err)
+		}
+		c.password = strings.TrimSpace(string(password))
+	}
+	if keyFile := query.Get("apikeyfile"); keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't read Elasticsearch API key: %s", err)
+		}
+		c.apiKey = strings.TrimSpace(string(key))
+	} else if c.username == "" {
+		c.apiKey = os.Getenv(ESAPIKeyEnvVar)
+	}
+	return c, nil
+}
+
+// esCollectors caches parsed Elasticsearch collectors so secret files are
+// only read once
+var esCollectors sync.Map
+
+func lookupESCollector(collector string) (*esCollector, error) {
+	if c, ok := esCollectors.Load(collector); ok {
+		return c.(*esCollector), nil
+	}
+	c, err := parseESCollector(collector)
+	if err != nil {
+		return nil, err
+	}
+	esCollectors.Store(collector, c)
+	return c, nil
+}
+
+// String gives the collector without its credentials, for messages
+func (c *esCollector) String() string {
+	return esCollectorPrefix + c.base + "/" + c.index
+}
+
+// indexName fills in the index pattern for a document from time t: %Y, %m,
+// %d and %H are its UTC year, month, day and hour and %v is the mapping
+// version
+func (c *esCollector) indexName(t time.Time) string {
+	t = t.UTC()
+	var name strings.Builder
+	for i := 0; i < len(c.index); i++ {
+		if c.index[i] != '%' || i+1 == len(c.index) {
+			name.WriteByte(c.index[i])
+			continue
+		}
+		i++
+		switch c.index[i] {
+		case 'Y':
+			name.WriteString(t.Format("2006"))
+		case 'm':
+			name.WriteString(t.Format("01"))
+		case 'd':
+			name.WriteString(t.Format("02"))
+		case 'H':
+			name.WriteString(t.Format("15"))
+		case 'v':
+			name.WriteString(strconv.Itoa(esMappingVersion))
+		default:
+			name.WriteByte('%')
+			name.WriteByte(c.index[i])
+		}
+	}
+	return name.String()
+}
+
+// esDocument turns a payload into the document written to Elasticsearch and
+// returns the time its index is chosen by, the payload's timestamp
+func esDocument(payload interface{}) (map[string]interface{}, time.Time, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(encoded, &doc); err != nil {
+		return nil, time.Time{}, err
+	}
+	doc["mapping_version"] = esMappingVersion
+	when := time.Now()
+	if ms, ok := doc["timestamp"].(float64); ok && ms > 0 {
+		when = time.Unix(0, int64(ms)*int64(time.Millisecond))
+	}
+	return doc, when, nil
+}
+
+func (c *esCollector) authorize(req *http.Request) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+}
+
+// write indexes a payload as a document, or a list of them with one bulk
+// request
+func (c *esCollector) write(ctx context.Context, payload interface{}) error {
+	if list, ok := payload.([]interface{}); ok {
+		return c.bulk(ctx, list)
+	}
+	doc, when, err := esDocument(payload)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(doc)
+	_, err = c.post(ctx, "/"+url.PathEscape(c.indexName(when))+"/_doc", "application/json", buf)
+	// a 400 is the document itself being refused, sending it again won't help
+	if statusErr, ok := err.(*esStatusError); ok && statusErr.status == http.StatusBadRequest {
+		return &esRejection{rejected: 1, total: 1, reason: statusErr.Error()}
+	}
+	return err
+}
+
+// esRejection is documents Elasticsearch refused to index.  retry holds the
+// payloads refused with a status worth retrying, 429 or 5xx; the others,
+// like mapping errors, would be refused again.
+type esRejection struct {
+	rejected, total int
+	reason          string
+	retry           []interface{}
+}
+
+func (e *esRejection) Error() string {
+	if e.total == 1 {
+		return "document rejected, " + e.reason
+	}
+	return fmt.Sprintf("%d of %d documents rejected, %s", e.rejected, e.total, e.reason)
+}
+
+// esRetryable is whether a document refused with status may be accepted
+// later
+func esRetryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// esStatusError is a request the cluster answered with a status other than
+// 2xx
+type esStatusError struct {
+	status  int
+	message string
+}
+
+func (e *esStatusError) Error() string {
+	return e.message
+}
+
+// esBulkResponse is the part of a _bulk response needed to find rejected
+// documents
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk indexes payloads with one _bulk request.  Elasticsearch can reject
+// some documents and accept the rest, which is an *esRejection holding the
+// rejected payloads that are worth sending again.
+func (c *esCollector) bulk(ctx context.Context, payloads []interface{}) error {
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	for _, payload := range payloads {
+		doc, when, err := esDocument(payload)
+		if err != nil {
+			return err
+		}
+		action := map[string]map[string]string{"index": {"_index": c.indexName(when)}}
+		encoder.Encode(action)
+		encoder.Encode(doc)
+	}
+	body, err := c.post(ctx, "/_bulk", "application/x-ndjson", buf)
+	if err != nil {
+		return err
+	}
+	var resp esBulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("can't parse bulk response: %s", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	rejection := &esRejection{total: len(payloads)}
+	// items are in the order the documents were sent
+	for i, item := range resp.Items {
+		for _, result := range item {
+			if result.Status/100 == 2 {
+				continue
+			}
+			if rejection.rejected++; rejection.reason == "" {
+				rejection.reason = result.Error.Type + ": " + result.Error.Reason
+			}
+			if esRetryable(result.Status) && i < len(payloads) {
+				rejection.retry = append(rejection.retry, payloads[i])
+			}
+		}
+	}
+	if rejection.rejected == 0 {
+		return nil
+	}
+	return rejection
+}
+
+// post sends a request to the cluster, returning the response body or an
+// error with the start of it if the status isn't 2xx
+func (c *esCollector) post(ctx context.Context, path string, contentType string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	c.authorize(req)
+	resp, err := reportHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		if len(respBody) > 512 {
+			respBody = respBody[:512]
+		}
+		return nil, &esStatusError{resp.StatusCode, fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))}
+	}
+	return respBody, nil
+}
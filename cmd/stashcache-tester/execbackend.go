@@ -0,0 +1,176 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxClientOutput and maxClientLines limit how much of a client's output is
+// kept in a payload
+const (
+	maxClientOutput = 4096
+	maxClientLines  = 20
+)
+
+// execDownload runs an external client that downloads uri to filename,
+// recording its timing and output like
+// DownloadXRDFile does for xrdcp.  The last few KB of the client's output are
+// kept in the payload, inspect (if not nil) can pick details out of all of
+// it before the payload is reported.
+func execDownload(parent context.Context, name string, args []string, env []string, inspect func(output string, payload *ESPayload),
+	uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	payload := newFilePayload("stashcache-tester-"+filepath.Base(name), ts, filename)
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = ts.tries()
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// keep the client's output next to the download for --keep-failed
+	if logFile, err := os.Create(filename + "." + filepath.Base(name) + ".log"); err == nil {
+		defer logFile.Close()
+		cmd.Stdout = io.MultiWriter(&out, logFile)
+		cmd.Stderr = cmd.Stdout
+	}
+	cmd.Env = append(os.Environ(), env...)
+	debugf("Running %s\n", commandLine(env, name, args...))
+
+	stalls := watchStalls(ts, fileProgress(filename), cancel)
+	hung := watchHung(parent, ts, uri, payload, fileProgress(filename))
+	err := cmd.Run()
+	stalls.stop()
+	hung.stop()
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+	payload.ClientOutput = clientOutputTail(out.String())
+	if inspect != nil {
+		inspect(out.String(), &payload)
+	}
+	if cmd.ProcessState != nil {
+		payload.XRDExit1 = fmt.Sprint(cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
+		payload.Status = "Failure"
+		fmt.Printf("Can't download %s\nError: %s\n", uri, err)
+		class := ErrorClassTransfer
+		if ctx.Err() == context.DeadlineExceeded {
+			class = ErrorClassTimeout
+		} else if parent.Err() != nil {
+			class = ErrorClassCancelled
+		}
+		err = classify(class, fmt.Errorf("Can't download %s\nError: %s\n", uri, err))
+		err = stalls.check(err, uri, &payload)
+		payload.FailureCategory = failureCategory(err, out.String())
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
+	}
+
+	fileInfo, err := os.Stat(filename)
+	if err != nil {
+		payload.Status = "Failure"
+		ReportTest(parent, payload, ts.Collector)
+		return payload, fmt.Errorf("Can't state file %s\nError: %s\n", filename, err)
+	}
+	payload.Status = "Success"
+	payload.DownloadSize = fileInfo.Size()
+	payload.FileSize = fileInfo.Size()
+	debugf("Downloaded %s (%s) in %s\n", uri, ByteSize(payload.DownloadSize), end.Sub(start).Round(time.Millisecond))
+	return payload, nil
+}
+
+// tailString returns at most the last max bytes of s
+func tailString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return "..." + s[len(s)-max:]
+}
+
+// secretPattern finds tokens clients may log in urls and headers
+var secretPattern = regexp.MustCompile(`(?i)((?:authz|access_token|token)=|bearer\s+)[^\s&"']+`)
+
+// controlPattern finds terminal escapes and other control characters that
+// would garble the output in Kibana
+var controlPattern = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]|[\x00-\x08\x0b-\x1f\x7f]`)
+
+// clientOutputTail returns the last lines of a client's output for a
+// payload, with tokens redacted and control characters removed
+func clientOutputTail(output string) string {
+	output = controlPattern.ReplaceAllString(output, "")
+	output = secretPattern.ReplaceAllString(output, "${1}REDACTED")
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > maxClientLines {
+		lines = lines[len(lines)-maxClientLines:]
+	}
+	return tailString(strings.Join(lines, "\n"), maxClientOutput)
+}
+
+// cacheURLPattern finds the cache urls federation clients log in debug mode
+// when they try a cache, e.g. "Attempting to download from https://...:8443"
+var cacheURLPattern = regexp.MustCompile(`(?i)(?:download(?:ing)? from|cache(?:s)?[:=]?|trying)\s*"?((?:https?|root|davs?)://[^\s"',\]]+)`)
+
+// recordServedBy records the last cache a client reported trying, which is
+// the one that served the file if the download succeeded
+func recordServedBy(output string, payload *ESPayload) {
+	matches := cacheURLPattern.FindAllStringSubmatch(output, -1)
+	if len(matches) > 0 {
+		payload.ServedBy = matches[len(matches)-1][1]
+	}
+}
+
+// StashcpEnvVar overrides the stashcp command, e.g. to test an osdf-client
+// build that isn't first in PATH
+const StashcpEnvVar = "STASHCACHE_TESTER_STASHCP"
+
+// stashcpBackend runs stashcp, which picks a cache itself using GeoIP and
+// falls back to others like user jobs do, so dnsname is only used for
+// reporting
+type stashcpBackend struct{}
+
+func init() {
+	registerBackend("stashcp", stashcpBackend{})
+}
+
+func (stashcpBackend) URL(ts TestSet, remotePath string) string {
+	return remotePath
+}
+
+func (stashcpBackend) Download(ctx context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	command := os.Getenv(StashcpEnvVar)
+	if command == "" {
+		command = "stashcp"
+	}
+	return execDownload(ctx, command, []string{"-d", uri, filename}, nil, recordServedBy,
+		uri, filename, ts, timeout)
+}
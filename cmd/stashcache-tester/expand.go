@@ -0,0 +1,140 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configVarPattern matches ${VAR} and ${VAR:-default}, a bare $VAR is left
+// alone since $ can legitimately appear in paths
+var configVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandString replaces ${VAR} references with values from the environment,
+// recording the names of unset variables that have no default
+func expandString(value string, missing map[string]bool) string {
+	return configVarPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		groups := configVarPattern.FindStringSubmatch(ref)
+		if envValue, ok := os.LookupEnv(groups[1]); ok {
+			return envValue
+		}
+		if groups[2] != "" {
+			return groups[3]
+		}
+		missing[groups[1]] = true
+		return ref
+	})
+}
+
+// expandConfigVars expands ${VAR} references in every string in a
+// generically decoded config before it's decoded into the Config types, so
+// durations, sizes, ports, numbers and booleans can come from the
+// environment too.  Values for numeric and boolean fields are converted from
+// the expanded strings.
+func expandConfigVars(generic interface{}) (interface{}, error) {
+	missing := make(map[string]bool)
+	configType := reflect.TypeOf(Config{})
+	if _, isList := generic.([]interface{}); isList {
+		configType = reflect.TypeOf([]TestSet{})
+	}
+	generic = expandValue(generic, configType, missing)
+	if len(missing) > 0 {
+		var names []string
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("config references unset environment variables: %s", strings.Join(names, ", "))
+	}
+	return generic, nil
+}
+
+// expandValue expands the strings in value, which is decoded into t, a nil
+// t for fields that aren't known, which checkConfigStructure reports
+func expandValue(value interface{}, t reflect.Type, missing map[string]bool) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var fields map[string]reflect.StructField
+		if t != nil && t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for key, elem := range v {
+			var elemType reflect.Type
+			if field, ok := fields[key]; ok {
+				elemType = field.Type
+			} else if t != nil && t.Kind() == reflect.Map {
+				elemType = t.Elem()
+			}
+			v[key] = expandValue(elem, elemType, missing)
+		}
+	case []interface{}:
+		var elemType reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elemType = t.Elem()
+		}
+		for i, elem := range v {
+			v[i] = expandValue(elem, elemType, missing)
+		}
+	case string:
+		if !configVarPattern.MatchString(v) {
+			return v
+		}
+		return convertExpanded(expandString(v, missing), t)
+	}
+	return value
+}
+
+// convertExpanded turns an expanded string into the json value a field of
+// type t expects, leaving it as it is if it doesn't convert so the error
+// names the string
+func convertExpanded(value string, t reflect.Type) interface{} {
+	if t == nil {
+		return value
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		// types with their own decoding, like durations, mostly take strings
+		raw, _ := json.Marshal(value)
+		if reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(raw) == nil {
+			return value
+		}
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+		return value
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
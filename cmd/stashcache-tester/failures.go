@@ -0,0 +1,75 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import "regexp"
+
+// Failure categories say why a transfer failed in the terms an operator
+// triages it, they're finer grained than error classes for transfers and
+// come from the client's output as well as the error
+const (
+	FailureDNS               = "dns"
+	FailureConnectionRefused = "connection-refused"
+	FailureAuthDenied        = "auth-denied"
+	FailureTimeout           = "timeout"
+	FailureNoSuchFile        = "no-such-file"
+	FailureChecksum          = "checksum"
+	FailureOther             = "other"
+)
+
+// failurePatterns match the messages of xrdcp, the http client and the
+// federation clients for each category, in the order they're tried
+var failurePatterns = []struct {
+	category string
+	pattern  *regexp.Regexp
+}{
+	{FailureDNS, regexp.MustCompile(`(?i)no such host|unable to resolve|could(?:n't| not) resolve|name or service not known|invalid address|temporary failure in name resolution`)},
+	{FailureConnectionRefused, regexp.MustCompile(`(?i)connection refused`)},
+	{FailureAuthDenied, regexp.MustCompile(`(?i)auth(?:entication)? failed|permission denied|not authori[sz]ed|unauthori[sz]ed|forbidden|\[30(?:10|30)\]|\b40[13]\b`)},
+	{FailureTimeout, regexp.MustCompile(`(?i)timed? ?out|operation expired|deadline exceeded`)},
+	{FailureNoSuchFile, regexp.MustCompile(`(?i)no such file|not found|\[3011\]|\b404\b`)},
+	{FailureChecksum, regexp.MustCompile(`(?i)checksum`)},
+}
+
+// failureCategory categorises a failed transfer from its error and the
+// client's output
+func failureCategory(err error, output string) string {
+	switch errorClass(err) {
+	case ErrorClassTimeout, ErrorClassStalled:
+		return FailureTimeout
+	case ErrorClassChecksum, ErrorClassDisagree:
+		return FailureChecksum
+	}
+	text := err.Error() + "\n" + output
+	for _, p := range failurePatterns {
+		if p.pattern.MatchString(text) {
+			return p.category
+		}
+	}
+	return FailureOther
+}
+
+// firstFailureCategory returns the category of the first failed file, for
+// the test set's payload
+func firstFailureCategory(files []FileSummary) string {
+	for _, f := range files {
+		if f.FailureCategory != "" {
+			return f.FailureCategory
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// fileCollectorPrefix marks collectors that are local files payloads are
+// appended to, one json payload per line as read by the report command
+const fileCollectorPrefix = "file://"
+
+// fileCollectorLock keeps payloads written at the same time from
+// interleaving
+var fileCollectorLock sync.Mutex
+
+func isFileCollector(collector string) bool {
+	return strings.HasPrefix(collector, fileCollectorPrefix)
+}
+
+// fileCollectorPath returns the file a file:// collector writes to
+func fileCollectorPath(collector string) (string, error) {
+	u, err := url.Parse(collector)
+	if err != nil || u.Path == "" || (u.Host != "" && u.Host != "localhost") {
+		return "", fmt.Errorf("file collector must be file:// and an absolute path, such as file:///var/log/payloads.jsonl")
+	}
+	return u.Path, nil
+}
+
+// appendPayloads writes a payload, or each of a list of them, as lines of
+// the collector's file
+func appendPayloads(collector string, payload interface{}) error {
+	path, err := fileCollectorPath(collector)
+	if err != nil {
+		return err
+	}
+	list, ok := payload.([]interface{})
+	if !ok {
+		list = []interface{}{payload}
+	}
+	fileCollectorLock.Lock()
+	defer fileCollectorLock.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	for _, p := range list {
+		if err = encoder.Encode(p); err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
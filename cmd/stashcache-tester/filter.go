@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// stringList is a flag.Value collecting every use of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// nameFilter matches names against a list of patterns.  Patterns prefixed
+// with "re:" are regular expressions (anchored to the whole name), anything
+// else is a glob.  An empty filter matches everything.
+type nameFilter struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func newNameFilter(patterns []string) (*nameFilter, error) {
+	filter := &nameFilter{}
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "re:") {
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, "re:") + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %s", pattern, err)
+			}
+			filter.regexps = append(filter.regexps, re)
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %s", pattern, err)
+		}
+		filter.globs = append(filter.globs, pattern)
+	}
+	return filter, nil
+}
+
+func (f *nameFilter) empty() bool {
+	return len(f.globs) == 0 && len(f.regexps) == 0
+}
+
+func (f *nameFilter) match(name string) bool {
+	if f.empty() {
+		return true
+	}
+	for _, glob := range f.globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	for _, re := range f.regexps {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTestSets returns the test sets whose site matches siteFilter and
+// whose name matches testSetFilter
+func filterTestSets(testSets []TestSet, siteFilter *nameFilter, testSetFilter *nameFilter) []TestSet {
+	var filtered []TestSet
+	for _, ts := range testSets {
+		if siteFilter.match(ts.SiteName) && testSetFilter.match(ts.TestSetName) {
+			filtered = append(filtered, ts)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,147 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fixture is the content of a test file generated from a seed, every 8 byte
+// word is a hash of the seed and the word's offset so any range of it can be
+// checked without a reference copy or hash file.  Fixtures are read like a
+// file with ReadAt.
+type fixture struct {
+	seed uint64
+	size int64
+}
+
+func newFixture(seed string, size int64) fixture {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return fixture{seed: h.Sum64(), size: size}
+}
+
+// word returns the i'th 8 bytes of the fixture, from splitmix64
+func (f fixture) word(i uint64) uint64 {
+	z := f.seed + (i+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// ReadAt fills p with the fixture's content from off
+func (f fixture) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > f.size-off {
+		n = int(f.size - off)
+	}
+	var word [8]byte
+	for i := 0; i < n; {
+		pos := off + int64(i)
+		binary.LittleEndian.PutUint64(word[:], f.word(uint64(pos/8)))
+		i += copy(p[i:n], word[pos%8:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fixtureWriter compares what's written to it with a fixture, so downloads
+// can be checked as they stream.  Writes never fail, the first difference is
+// kept for check.
+type fixtureWriter struct {
+	fixture  fixture
+	offset   int64
+	mismatch int64
+	buf      []byte
+}
+
+func newFixtureWriter(testFile TestFile) *fixtureWriter {
+	return &fixtureWriter{fixture: newFixture(testFile.Fixture, int64(testFile.Size)), mismatch: -1}
+}
+
+func (w *fixtureWriter) Write(p []byte) (int, error) {
+	if w.mismatch < 0 {
+		if cap(w.buf) < len(p) {
+			w.buf = make([]byte, len(p))
+		}
+		want := w.buf[:len(p)]
+		n, _ := w.fixture.ReadAt(want, w.offset)
+		if i := firstDifference(p, want[:n]); i < int64(len(p)) {
+			w.mismatch = w.offset + i
+		}
+	}
+	w.offset += int64(len(p))
+	return len(p), nil
+}
+
+// check returns an error if what was written isn't the whole fixture
+func (w *fixtureWriter) check(name string, seed string) error {
+	if w.mismatch >= 0 && w.mismatch < w.fixture.size {
+		return classify(ErrorClassChecksum, fmt.Errorf("%s doesn't match fixture %q from byte %d", name, seed, w.mismatch))
+	}
+	if w.offset != w.fixture.size {
+		return sizeError(name, w.offset, w.fixture.size)
+	}
+	return nil
+}
+
+// verifyFixture checks a downloaded file is the fixture its test file
+// names, byte for byte
+func verifyFixture(testFile TestFile, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return classify(ErrorClassSetup, fmt.Errorf("can't open %s: %s", filename, err))
+	}
+	defer f.Close()
+	w := newFixtureWriter(testFile)
+	if _, err := io.Copy(w, f); err != nil {
+		return classify(ErrorClassSetup, fmt.Errorf("can't read %s: %s", filename, err))
+	}
+	return w.check(filepath.Base(filename), testFile.Fixture)
+}
+
+// checkFixture checks a download is the fixture its test file names, using
+// the comparison made as it arrived if the backend made one
+func checkFixture(testFile TestFile, filename string, payload ESPayload) error {
+	if payload.fixture != nil {
+		return payload.fixture.check(filepath.Base(filename), testFile.Fixture)
+	}
+	return verifyFixture(testFile, filename)
+}
+
+// firstDifference returns the index of the first byte that differs between a
+// and b, or the length of the shorter one
+func firstDifference(a []byte, b []byte) int64 {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return int64(i)
+}
@@ -0,0 +1,178 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultFixtureSizes are created by fixtures create unless --size is given,
+// matching the FILE_SIZE_TEST data set
+var defaultFixtureSizes = stringList{"1M", "100M", "1G"}
+
+// fixturesCommand runs the fixtures subcommands
+func fixturesCommand(args []string) int {
+	if len(args) == 0 || args[0] != "create" {
+		fmt.Fprintf(os.Stderr, "Usage: %s fixtures create [options] <origin> <directory>\n", os.Args[0])
+		return ExitConfigError
+	}
+	return fixturesCreateCommand(args[1:])
+}
+
+// fixturesCreateCommand generates fixture files of the given sizes and a
+// hash file for them, uploads them to a directory on a writable origin and
+// prints the test set to add to the config
+func fixturesCreateCommand(args []string) int {
+	flags := newFlagSet("fixtures create", "[options] <origin> <directory>")
+	var sizes stringList
+	flags.Var(&sizes, "size", "size of a file to create, e.g. 100M (repeatable, default: "+defaultFixtureSizes.String()+")")
+	prefix := flags.String("prefix", "test.", "file names are the prefix followed by the size")
+	hashFile := flags.String("hashfile", "hashes", "name of the hash file written to the directory")
+	algorithm := flags.String("hash-algorithm", builtinDefaults.HashAlgorithm, "algorithm of the hash file")
+	format := flags.String("hash-format", builtinDefaults.HashFormat, "format of the hash file, coreutils or bsd")
+	timeout := flags.Duration("timeout", time.Duration(builtinDefaults.Timeout), "timeout for each upload")
+	scratchDir := flags.String("scratch-dir", builtinDefaults.ScratchDir, "directory to write the files in before uploading")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return ExitConfigError
+	}
+	if len(sizes) == 0 {
+		sizes = defaultFixtureSizes
+	}
+	origin, dir := flags.Arg(0), path.Clean(flags.Arg(1))
+	if msg := checkEndpoint(origin); msg != "" {
+		fmt.Fprintf(os.Stderr, "origin %s: %s\n", origin, msg)
+		return ExitConfigError
+	}
+	if msg := checkRemotePath(dir); msg != "" {
+		fmt.Fprintf(os.Stderr, "directory %s: %s\n", dir, msg)
+		return ExitConfigError
+	}
+	newHash, ok := hashFunctions[*algorithm]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown hash algorithm %s\n", *algorithm)
+		return ExitConfigError
+	}
+	if _, ok := hashFormats[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "unknown hash format %s\n", *format)
+		return ExitConfigError
+	}
+	var files []TestFile
+	for _, size := range sizes {
+		parsed, err := parseByteSize(size)
+		if err != nil || parsed == 0 {
+			fmt.Fprintf(os.Stderr, "invalid size %q\n", size)
+			return ExitConfigError
+		}
+		remotePath := path.Join(dir, *prefix+strings.ToUpper(strings.TrimSpace(size)))
+		files = append(files, TestFile{Path: remotePath, Size: parsed, Fixture: remotePath})
+	}
+
+	workDir, err := ioutil.TempDir(*scratchDir, "stashcache-tester-fixtures-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't create directory in %s: %s\n", *scratchDir, err)
+		return ExitConfigError
+	}
+	defer os.RemoveAll(workDir)
+	ctx, stop := interruptContext()
+	defer stop()
+
+	ts := builtinDefaults
+	ts.Timeout = Duration(*timeout)
+	var manifest strings.Builder
+	for _, testFile := range files {
+		local := filepath.Join(workDir, path.Base(testFile.Path))
+		sum, err := writeFixture(local, testFile, newHash())
+		if err == nil {
+			err = uploadFile(ctx, ts, local, "root://"+origin+"/"+testFile.Path)
+		}
+		os.Remove(local)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't create %s: %s\n", testFile.Path, err)
+			return ExitTestFailure
+		}
+		fmt.Printf("Uploaded %s (%s)\n", testFile.Path, testFile.Size)
+		manifest.WriteString(hashLine(*format, *algorithm, path.Base(testFile.Path), sum))
+	}
+	hashPath := path.Join(dir, *hashFile)
+	local := filepath.Join(workDir, *hashFile)
+	err = ioutil.WriteFile(local, []byte(manifest.String()), 0644)
+	if err == nil {
+		err = uploadFile(ctx, ts, local, "root://"+origin+"/"+hashPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't create %s: %s\n", hashPath, err)
+		return ExitTestFailure
+	}
+	fmt.Printf("Uploaded %s\n\n", hashPath)
+	printFixtureTestSet(hashPath, *algorithm, *format, files)
+	return ExitSuccess
+}
+
+// writeFixture writes a test file's fixture to name, returning its hash
+func writeFixture(name string, testFile TestFile, h hash.Hash) (string, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("can't create %s: %s", name, err)
+	}
+	defer f.Close()
+	content := io.NewSectionReader(newFixture(testFile.Fixture, int64(testFile.Size)), 0, int64(testFile.Size))
+	if _, err := io.Copy(io.MultiWriter(f, h), content); err != nil {
+		return "", fmt.Errorf("can't write %s: %s", name, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), f.Close()
+}
+
+// hashLine formats one entry of a hash file the way parseHashFile reads it
+func hashLine(format string, algorithm string, name string, sum string) string {
+	if format == "bsd" {
+		return fmt.Sprintf("%s (%s) = %s\n", strings.ToUpper(algorithm), name, sum)
+	}
+	return fmt.Sprintf("%s  %s\n", sum, name)
+}
+
+// printFixtureTestSet prints a test set for created fixtures to paste into
+// the config
+func printFixtureTestSet(hashPath string, algorithm string, format string, files []TestFile) {
+	fmt.Println("Add a test set like this to the config:")
+	fmt.Println()
+	fmt.Println("  - dnsname: <cache>")
+	fmt.Println("    sitename: <site>")
+	fmt.Println("    testsetname: FIXTURE_TEST")
+	fmt.Printf("    hashfile: %s\n", hashPath)
+	if algorithm != builtinDefaults.HashAlgorithm {
+		fmt.Printf("    hashalgorithm: %s\n", algorithm)
+	}
+	if format != builtinDefaults.HashFormat {
+		fmt.Printf("    hashformat: %s\n", format)
+	}
+	fmt.Println("    testfiles:")
+	for _, testFile := range files {
+		fmt.Printf("      - path: %s\n", testFile.Path)
+		fmt.Printf("        size: %d\n", int64(testFile.Size))
+		fmt.Printf("        fixture: %s\n", testFile.Fixture)
+	}
+}
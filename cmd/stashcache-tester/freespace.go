@@ -0,0 +1,54 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// StatusSkippedNoSpace is reported for test sets that weren't run because
+// the scratch directory doesn't have room for their files
+const StatusSkippedNoSpace = "Skipped-NoSpace"
+
+// freeSpace returns the bytes available to unprivileged users in the
+// filesystem holding dir
+func freeSpace(dir string) (ByteSize, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, fmt.Errorf("can't get free space for %s: %s", dir, err)
+	}
+	return ByteSize(stat.Bavail) * ByteSize(stat.Bsize), nil
+}
+
+// checkScratchSpace returns an error if the test set's scratch directory
+// can't hold the files it expects to download.  Only files with a size in
+// the config are counted so the check is a lower bound.
+func checkScratchSpace(ts TestSet) error {
+	needed, _ := expectedBytes(ts)
+	if needed == 0 || ts.Discard {
+		return nil
+	}
+	free, err := freeSpace(ts.ScratchDir)
+	if err != nil {
+		return err
+	}
+	if free < needed {
+		return fmt.Errorf("%s has %s free but %s/%s needs %s", ts.ScratchDir, free, ts.SiteName, ts.TestSetName, needed)
+	}
+	return nil
+}
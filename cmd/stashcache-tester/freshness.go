@@ -0,0 +1,143 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// the freshness test type overwrites a timestamped file at the origin and
+// reads it through the cache at dnsname until the new version is served,
+// to catch caches that keep serving stale data after the origin changes
+func init() {
+	registerTestType("freshness", runFreshnessTest)
+}
+
+// defaultFreshnessTTL is how long freshness test sets allow a cache to serve
+// the old version when freshnessttl isn't given
+const defaultFreshnessTTL = 10 * time.Minute
+
+// minFreshnessPoll is the shortest wait between reads of the updated file
+const minFreshnessPoll = time.Second
+
+func runFreshnessTest(ctx context.Context, ts TestSet) TestResult {
+	// the same file is updated every run, so the cache has an old version
+	// of it to revalidate
+	name := "stashcache-tester-freshness." + ts.DNSName
+	remotePath := path.Join(ts.UploadDir, name)
+	result := TestResult{files: []FileSummary{{Path: remotePath, Status: StatusNotRun}}}
+	fail := func(err error) TestResult {
+		result.files[0].Status = "Failure"
+		result.files[0].setError(err)
+		result.result = classify(errorClass(err), fmt.Errorf("freshness test of %s failed: %s", remotePath, err))
+		return result
+	}
+	ttl := time.Duration(ts.FreshnessTTL)
+	if ttl == 0 {
+		ttl = defaultFreshnessTTL
+	}
+
+	stamp := time.Now().UTC().Format(time.RFC3339Nano) + " " + ts.DNSName
+	local := ts.localPath(name)
+	if err := ioutil.WriteFile(local, []byte(stamp+"\n"), 0644); err != nil {
+		return fail(classify(ErrorClassSetup, fmt.Errorf("can't write %s: %s", local, err)))
+	}
+	dst := "root://" + ts.Origin + "/" + remotePath
+	upload := newFilePayload("stashcache-tester-freshness", ts, local)
+	upload.TestType = ts.Type
+	upload.Destination = dst
+	start := time.Now()
+	err := uploadFile(ctx, ts, local, dst)
+	updated := time.Now()
+	upload.UploadStart = unixMillis(start)
+	upload.UploadEnd = unixMillis(updated)
+	upload.UploadTime = updated.Sub(start).Seconds() * 1000
+	if err != nil {
+		upload.Status = "Failure"
+		upload.Error = err.Error()
+		upload.TimeStamp = nowMillis()
+		fmt.Printf("Can't update %s: %s\n", dst, err)
+		ReportTest(ctx, upload, ts.Collector)
+		return fail(classify(ErrorClassUpload, err))
+	}
+
+	backend, err := lookupBackend(ts)
+	if err != nil {
+		return fail(classify(ErrorClassSetup, err))
+	}
+	uri := backend.URL(ts, remotePath)
+	result.files[0].URL = uri
+	// only the read that decides the result is reported
+	pollTS := ts
+	pollTS.Collector = nil
+	poll := ttl / 10
+	if poll < minFreshnessPoll {
+		poll = minFreshnessPoll
+	}
+	var payload ESPayload
+	var served string
+	for reads := 1; ; reads++ {
+		os.Remove(local)
+		payload, err = backend.Download(ctx, uri, local, pollTS, time.Duration(ts.Timeout))
+		if err != nil {
+			return fail(err)
+		}
+		content, err := ioutil.ReadFile(local)
+		if err != nil {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't read %s: %s", local, err)))
+		}
+		served = strings.TrimSpace(string(content))
+		if served == stamp {
+			debugf("%s served the update after %s and %d reads\n", ts.DNSName, time.Since(updated).Round(time.Millisecond), reads)
+			break
+		}
+		debugf("%s is still serving %q\n", uri, served)
+		if time.Since(updated)+poll > ttl {
+			break
+		}
+		if err := sleepContext(ctx, poll); err != nil {
+			return fail(classify(ErrorClassCancelled, fmt.Errorf("stopped waiting for %s: %s", uri, err)))
+		}
+	}
+	payload.Staleness = time.Since(updated).Seconds() * 1000
+	payload.TestType = ts.Type
+	payload.Destination = dst
+	payload.UploadStart = upload.UploadStart
+	payload.UploadEnd = upload.UploadEnd
+	payload.UploadTime = upload.UploadTime
+	result.files[0].Bytes = payload.DownloadSize
+	result.files[0].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+	if served != stamp {
+		err := classify(ErrorClassStale, fmt.Errorf("still served %q %s after it was updated to %q",
+			served, time.Since(updated).Round(time.Second), stamp))
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		fmt.Printf("Stale data from %s: %s\n", uri, err)
+		ReportTest(ctx, payload, ts.Collector)
+		return fail(err)
+	}
+	ReportTest(ctx, payload, ts.Collector)
+	result.files[0].Status = payload.Status
+	result.success = true
+	return result
+}
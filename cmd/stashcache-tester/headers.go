@@ -0,0 +1,70 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTPResponse records the parts of a cache's HTTP response that tell a
+// cache hit from a pass through to the origin
+type HTTPResponse struct {
+	Status        int    `json:"status"`
+	ContentLength int64  `json:"content_length"`
+	XCache        string `json:"x_cache,omitempty"`
+	XCacheStatus  string `json:"x_cache_status,omitempty"`
+	Age           string `json:"age,omitempty"`
+	// Disposition is hit or miss if the headers say, see cacheDisposition
+	Disposition string `json:"disposition,omitempty"`
+}
+
+func newHTTPResponse(resp *http.Response) *HTTPResponse {
+	r := &HTTPResponse{
+		Status:        resp.StatusCode,
+		ContentLength: resp.ContentLength,
+		XCache:        resp.Header.Get("X-Cache"),
+		XCacheStatus:  resp.Header.Get("X-Cache-Status"),
+		Age:           resp.Header.Get("Age"),
+	}
+	r.Disposition = cacheDisposition(r)
+	return r
+}
+
+// cacheDisposition works out whether a response was a cache hit from
+// X-Cache-Status (nginx style HIT, MISS, EXPIRED, ...), then X-Cache (squid
+// and varnish style "HIT from host") and then a positive Age, returning ""
+// if none of them say
+func cacheDisposition(r *HTTPResponse) string {
+	for _, header := range []string{r.XCacheStatus, r.XCache} {
+		value := strings.ToUpper(header)
+		switch {
+		case value == "":
+			continue
+		case strings.Contains(value, "HIT"):
+			return "hit"
+		case strings.Contains(value, "MISS"), strings.Contains(value, "EXPIRED"),
+			strings.Contains(value, "BYPASS"), strings.Contains(value, "PASS"):
+			return "miss"
+		}
+	}
+	if age, err := strconv.Atoi(strings.TrimSpace(r.Age)); err == nil && age > 0 {
+		return "hit"
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// httpBackend downloads files with net/http from the HTTP interface that
+// StashCache/OSDF caches serve alongside xrootd
+type httpBackend struct {
+	scheme string
+}
+
+func init() {
+	registerBackend("http", httpBackend{"http"})
+	registerBackend("https", httpBackend{"https"})
+}
+
+// gridCertDir is checked for CA certificates when X509_CERT_DIR isn't set,
+// caches normally use IGTF certificates that aren't in the system pool
+const gridCertDir = "/etc/grid-security/certificates"
+
+var (
+	httpClientOnce sync.Once
+	httpClient     *http.Client
+)
+
+// sharedHTTPClient returns the client used for downloads, trusting the
+// system CAs plus any in X509_CERT_DIR
+func sharedHTTPClient() *http.Client {
+	httpClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = dialContext
+		transport.Proxy = requestProxy
+		if pool := gridCertPool(); pool != nil {
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+		httpClient = &http.Client{Transport: transport}
+	})
+	return httpClient
+}
+
+// gridCertPool returns the system pool with the certificates in the grid CA
+// directory added, or nil if there's no such directory
+func gridCertPool() *x509.CertPool {
+	dir := os.Getenv("X509_CERT_DIR")
+	if dir == "" {
+		dir = gridCertDir
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if pem, err := ioutil.ReadFile(filepath.Join(dir, entry.Name())); err == nil {
+			pool.AppendCertsFromPEM(pem)
+		}
+	}
+	return pool
+}
+
+func (b httpBackend) URL(ts TestSet, remotePath string) string {
+	// the host name is kept when testing a single address so it's used for
+	// TLS, the address is dialled directly, see withDialAddress
+	return fmt.Sprintf("%s://%s%s", b.scheme, urlHost(ts.hostname(), b.scheme, ts.port(b.scheme)), remotePath)
+}
+
+func (b httpBackend) Download(parent context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	payload := newFilePayload("stashcache-tester-"+b.scheme, ts, filename)
+	start := time.Now()
+	timings := newTransferTimings(start)
+	payload.Start1 = unixMillis(start)
+	payload.Tries = ts.tries()
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	var progress progressCounter
+	stalls := watchStalls(ts, progress.progress, cancel)
+	defer stalls.stop()
+	hung := watchHung(parent, ts, uri, payload, progress.progress)
+	defer hung.stop()
+
+	fail := func(err error) (ESPayload, error) {
+		end := time.Now()
+		payload.End1 = unixMillis(end)
+		payload.DownloadTime = end.Sub(start).Seconds() * 1000
+		payload.TimeStamp = nowMillis()
+		payload.Status = "Failure"
+		timings.record(&payload)
+		fmt.Printf("Can't download %s\nError: %s\n", uri, err)
+		class := errorClass(err)
+		if ctx.Err() == context.DeadlineExceeded {
+			class = ErrorClassTimeout
+		} else if parent.Err() != nil {
+			class = ErrorClassCancelled
+		}
+		err = classify(class, fmt.Errorf("Can't download %s\nError: %s\n", uri, err))
+		err = stalls.check(err, uri, &payload)
+		payload.FailureCategory = failureCategory(err, "")
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
+	}
+
+	digester, err := newDigestWriter(ts)
+	if err != nil {
+		return fail(err)
+	}
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return fail(err)
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	if ts.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ts.bearerToken)
+	}
+	// a pooled connection could be to a different address
+	req.Close = ts.address != ""
+	debugf("Running GET %s\n", uri)
+	req = req.WithContext(timings.trace(requestContext(ctx, ts)))
+	payload.Proxy = proxyUsed(req)
+	resp, err := sharedHTTPClient().Do(req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	payload.XRDExit1 = strconv.Itoa(resp.StatusCode)
+	payload.HTTPResponse = newHTTPResponse(resp)
+	if ts.Redirector {
+		// the client follows redirects, the last request went to the
+		// server that answered
+		payload.ServedBy = resp.Request.URL.Host
+	}
+	// keep the response headers next to the download for --keep-failed
+	if logFile, err := os.Create(filename + ".http.log"); err == nil {
+		fmt.Fprintf(logFile, "GET %s\n%s %s\n", uri, resp.Proto, resp.Status)
+		resp.Header.Write(logFile)
+		logFile.Close()
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("server returned %s", resp.Status))
+	}
+
+	var written int64
+	body := throttle(resp.Body, ts)
+	recordRateLimits(ts, &payload)
+	if ts.Discard {
+		written, err = io.Copy(io.MultiWriter(digester, &progress), body)
+	} else {
+		out, createErr := os.Create(filename)
+		if createErr != nil {
+			return fail(createErr)
+		}
+		written, err = io.Copy(io.MultiWriter(out, digester, &progress), body)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	payload.DownloadSize = written
+	digester.record(&payload)
+	if err != nil {
+		return fail(err)
+	}
+	if resp.ContentLength >= 0 && written != resp.ContentLength {
+		return fail(fmt.Errorf("got %d bytes, expected %d", written, resp.ContentLength))
+	}
+
+	end := time.Now()
+	payload.Status = "Success"
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.FileSize = written
+	payload.TimeStamp = nowMillis()
+	timings.record(&payload)
+	debugf("Downloaded %s (%s) in %s\n", uri, ByteSize(written), end.Sub(start).Round(time.Millisecond))
+	return payload, nil
+}
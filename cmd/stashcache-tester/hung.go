@@ -0,0 +1,80 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// StatusHung is reported in an interim payload for a transfer that's still
+// running after the test set's hungafter.  The transfer's own payload
+// follows when it ends.
+const StatusHung = "Hung"
+
+// hungWatchdog reports a transfer that runs past the test set's hungafter
+// without stopping it, so a slow cache shows up while the transfer is still
+// going instead of only once it times out
+type hungWatchdog struct {
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// watchHung starts a watchdog for the transfer of uri described by payload.
+// If it's still running after hungafter an interim copy of payload with
+// status Hung, the time so far and the bytes from progress is reported, in
+// the background so the transfer and the rest of the run go on.  Nothing is
+// watched if the test set has no hungafter.  The watchdog must be stopped
+// when the transfer ends.
+func watchHung(ctx context.Context, ts TestSet, uri string, payload ESPayload, progress func() int64) *hungWatchdog {
+	w := &hungWatchdog{done: make(chan struct{})}
+	after := time.Duration(ts.HungAfter)
+	if after <= 0 {
+		return w
+	}
+	started := time.Now()
+	go func() {
+		timer := time.NewTimer(after)
+		defer timer.Stop()
+		select {
+		case <-w.done:
+			return
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		now := time.Now()
+		payload.Status = StatusHung
+		payload.Interim = true
+		payload.DownloadSize = progress()
+		payload.DownloadTime = now.Sub(started).Seconds() * 1000
+		payload.TimeStamp = unixMillis(now)
+		fmt.Printf("%s still running after %s with %s transferred, reporting it as hung\n", uri, after, ByteSize(payload.DownloadSize))
+		collectors := ts.Collector
+		if ts.interimCollector != nil {
+			collectors = ts.interimCollector
+		}
+		ReportTest(ctx, payload, collectors)
+	}()
+	return w
+}
+
+func (w *hungWatchdog) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
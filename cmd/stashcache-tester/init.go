@@ -0,0 +1,101 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// starterConfig is written by the init command, it's valid YAML for the
+// subset understood by parseYAML
+const starterConfig = `# stashcache-tester config, see the README for every available setting.
+# Check it with "stashcache-tester validate" and see what it will test with
+# "stashcache-tester list".
+
+# settings inherited by every test set below unless it sets its own
+defaults:
+  # how long a single transfer may take, in seconds or as a duration
+  timeout: 10m
+  # algorithm used by the hash files (md5, sha1, sha256, sha512, adler32 or
+  # crc32c) and their format (coreutils or bsd)
+  hashalgorithm: sha256
+  hashformat: coreutils
+  # where results are sent, can be a list of urls
+  collector: http://uct2-collectd.mwt2.org:9951
+  # extra xrdcp client settings
+  xrdenv:
+    XRD_REQUESTTIMEOUT: 30
+    XRD_CONNECTIONRETRY: 2
+
+testsets:
+  # each entry tests one set of files against one cache
+  - # hostname of the xrootd/stashcache instance, ${VAR:-default} picks
+    # up environment variables so one config works for several caches
+    dnsname: ${STASH_CACHE:-stashcache.grid.uchicago.edu}
+    # name the results are reported under
+    sitename: UC_STASH_CACHE
+    # name of this group of files in the results
+    testsetname: FILE_SIZE_TEST
+    # file with the expected hashes of the test files, in sha256sum format
+    hashfile: /user/sthapa/test-sets/hashes
+    # files to download, either a path or an object with per-file settings
+    testfiles:
+      - /user/sthapa/public/test-sets/test.1M
+      - path: /user/sthapa/public/test-sets/test.100M
+        timeout: 20m
+`
+
+// initCommand writes a starter config, as JSON if the file name ends in
+// .json and annotated YAML otherwise
+func initCommand(args []string) int {
+	flags := newFlagSet("init", "[options] [path]")
+	force := flags.Bool("force", false, "overwrite an existing file")
+	flags.Parse(args)
+
+	location := "siteconfig.yaml"
+	if flags.NArg() > 0 {
+		location = flags.Arg(0)
+	}
+	contents := []byte(starterConfig)
+	if strings.ToLower(filepath.Ext(location)) == ".json" {
+		generic, err := parseYAML(contents)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "can't convert starter config to json: %s\n", err)
+			return 1
+		}
+		if contents, err = json.MarshalIndent(generic, "", "  "); err != nil {
+			fmt.Fprintf(os.Stderr, "can't convert starter config to json: %s\n", err)
+			return 1
+		}
+		contents = append(contents, '\n')
+	}
+	if _, err := os.Stat(location); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s already exists, use --force to overwrite it\n", location)
+		return 1
+	}
+	if err := ioutil.WriteFile(location, contents, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "can't write %s: %s\n", location, err)
+		return 1
+	}
+	fmt.Printf("Wrote starter config to %s\n", location)
+	return 0
+}
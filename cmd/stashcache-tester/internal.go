@@ -0,0 +1,69 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// StatusInternalError is reported for tests that panicked, a bug in the
+// tester rather than a problem with the cache
+const StatusInternalError = "InternalError"
+
+// catchPanic runs f, returning a panic in it as an error of class
+// ErrorClassInternal so a bug in one test is reported like any other
+// failure instead of taking down the whole run
+func catchPanic(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Internal error: %v\n%s", r, debug.Stack())
+			err = classify(ErrorClassInternal, fmt.Errorf("internal error: %v", r))
+		}
+	}()
+	f()
+	return nil
+}
+
+// internalErrorSummaries stands in for the outcome of a site's test sets
+// when testing it panicked outside of any of them, reporting an
+// InternalError payload for each.  The test sets are expanded by address
+// like TestEndpoint does so the rows line up with a normal run's.
+func internalErrorSummaries(ctx context.Context, testSets []TestSet, err error) []TestSetSummary {
+	var expanded []TestSet
+	for _, ts := range testSets {
+		// expanding may be what panicked
+		if catchPanic(func() { expanded = append(expanded, expandAddresses(ts)...) }) != nil {
+			expanded = append(expanded, ts)
+		}
+	}
+	summaries := make([]TestSetSummary, len(expanded))
+	for i, ts := range expanded {
+		summaries[i] = TestSetSummary{SiteName: ts.SiteName, TestSetName: ts.TestSetName, Cache: ts.DNSName,
+			Address: ts.address, Status: StatusInternalError}
+		summaries[i].setError(err)
+		payload := testSetPayload(ts)
+		now := nowMillis()
+		payload.Start1, payload.End1 = now, now
+		payload.Tries = 1
+		payload.Status = StatusInternalError
+		payload.Error = err.Error()
+		ReportTest(ctx, payload, ts.Collector)
+	}
+	return summaries
+}
@@ -0,0 +1,223 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the list test type lists listdir with xrdfs ls, or PROPFIND for the HTTP
+// backends, and checks that every test file is in it with the right size
+func init() {
+	registerTestType("list", runListTest)
+}
+
+// listEntry is a file or directory found in a listing
+type listEntry struct {
+	Path string
+	Size int64
+	Dir  bool
+}
+
+func runListTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	payload := newFilePayload("stashcache-tester-list", ts, ts.ListDir)
+	payload.TestType = ts.Type
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+	uri, entries, err := listDir(ctx, ts, ts.ListDir, time.Duration(ts.Timeout))
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.Entries = len(entries)
+	fail := func(err error) TestResult {
+		fmt.Printf("Listing %s failed: %s\n", uri, err)
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		ReportTest(ctx, payload, ts.Collector)
+		result.success = false
+		result.result = classify(errorClass(err), fmt.Errorf("listing %s failed: %s", uri, err))
+		return result
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	found := make(map[string]listEntry, len(entries))
+	for _, entry := range entries {
+		found[path.Base(entry.Path)] = entry
+	}
+	for i, testFile := range ts.TestFiles {
+		result.files[i].URL = uri
+		result.files[i].Duration = end.Sub(start)
+		entry, ok := found[path.Base(testFile.Path)]
+		switch {
+		case !ok || entry.Dir:
+			err = classify(ErrorClassMissing, fmt.Errorf("%s isn't in the listing", path.Base(testFile.Path)))
+		case testFile.Size > 0 && entry.Size != int64(testFile.Size):
+			err = sizeError(path.Base(testFile.Path), entry.Size, int64(testFile.Size))
+		default:
+			result.files[i].Status = "Success"
+			result.files[i].Bytes = entry.Size
+			continue
+		}
+		result.files[i].Status = "Failure"
+		result.files[i].setError(err)
+		payload.MissingEntries = append(payload.MissingEntries, path.Base(testFile.Path))
+	}
+	if len(payload.MissingEntries) > 0 {
+		return fail(classify(errorClass(err), fmt.Errorf("%s missing or the wrong size", strings.Join(payload.MissingEntries, ", "))))
+	}
+	payload.Status = "Success"
+	payload.TimeStamp = nowMillis()
+	debugf("Listed %d entries in %s in %s\n", len(entries), uri, end.Sub(start).Round(time.Millisecond))
+	ReportTest(ctx, payload, ts.Collector)
+	return result
+}
+
+// discoverTestFiles adds the files in listdir matching the test set's
+// discover pattern to its test files, with the size from the listing, so
+// new files at the origin are tested without changing the config
+func discoverTestFiles(ctx context.Context, ts TestSet) (TestSet, error) {
+	uri, entries, err := listDir(ctx, ts, ts.ListDir, time.Duration(ts.Timeout))
+	if err != nil {
+		return ts, classify(errorClass(err), fmt.Errorf("can't list %s to discover test files: %s", uri, err))
+	}
+	known := make(map[string]bool, len(ts.TestFiles))
+	for _, testFile := range ts.TestFiles {
+		known[path.Base(testFile.Path)] = true
+	}
+	testFiles := append([]TestFile(nil), ts.TestFiles...)
+	for _, entry := range entries {
+		name := path.Base(entry.Path)
+		if matched, _ := path.Match(ts.Discover, name); entry.Dir || !matched || known[name] {
+			continue
+		}
+		testFiles = append(testFiles, TestFile{Path: path.Join(ts.ListDir, name), Size: ByteSize(entry.Size)})
+	}
+	debugf("Discovered %d test files in %s\n", len(testFiles)-len(ts.TestFiles), uri)
+	ts.TestFiles = testFiles
+	return ts, nil
+}
+
+// listDir lists dir on the test set's cache, returning the url listed
+func listDir(ctx context.Context, ts TestSet, dir string, timeout time.Duration) (string, []listEntry, error) {
+	if httpStatBackends[ts.Backend] {
+		uri := statURL(ts, strings.TrimSuffix(dir, "/")+"/")
+		entries, err := propfindList(ctx, ts, uri, timeout)
+		return uri, entries, err
+	}
+	uri := "root://" + ts.endpoint("root") + "/" + dir
+	out, err := xrdfs(ctx, ts, ts.endpoint("root"), timeout, "ls", "-l", dir)
+	if err != nil {
+		return uri, nil, classify(ErrorClassTransfer, err)
+	}
+	return uri, parseXRDListing(out), nil
+}
+
+// parseXRDListing parses "xrdfs ls -l" output, lines of mode, date, time,
+// size and path
+func parseXRDListing(out string) []listEntry {
+	var entries []listEntry
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[len(fields)-2], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, listEntry{Path: fields[len(fields)-1], Size: size, Dir: strings.HasPrefix(fields[0], "d")})
+	}
+	return entries
+}
+
+// multistatus is the part of a PROPFIND response listDir uses
+type multistatus struct {
+	Responses []struct {
+		Href          string    `xml:"href"`
+		ContentLength string    `xml:"propstat>prop>getcontentlength"`
+		Collection    *xml.Name `xml:"propstat>prop>resourcetype>collection"`
+	} `xml:"response"`
+}
+
+// propfindList lists a collection with a depth 1 PROPFIND, leaving out the
+// collection itself
+func propfindList(ctx context.Context, ts TestSet, uri string, timeout time.Duration) ([]listEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("PROPFIND", uri, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, classify(ErrorClassSetup, err)
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml")
+	req.Close = ts.address != ""
+	debugf("Running PROPFIND %s\n", uri)
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, classify(ErrorClassTimeout, fmt.Errorf("PROPFIND %s timed out", uri))
+	}
+	if err != nil {
+		return nil, classify(ErrorClassTransfer, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, classify(ErrorClassTransfer, err)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, classify(ErrorClassTransfer, fmt.Errorf("server returned %s for PROPFIND %s", resp.Status, uri))
+	}
+	var status multistatus
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&status); err != nil {
+		return nil, classify(ErrorClassTransfer, fmt.Errorf("can't parse PROPFIND response: %s", err))
+	}
+	base, _ := url.Parse(uri)
+	var entries []listEntry
+	for _, response := range status.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			continue
+		}
+		entryPath := base.ResolveReference(href).Path
+		if strings.TrimSuffix(entryPath, "/") == strings.TrimSuffix(base.Path, "/") {
+			continue
+		}
+		size, _ := strconv.ParseInt(strings.TrimSpace(response.ContentLength), 10, 64)
+		entries = append(entries, listEntry{Path: strings.TrimSuffix(entryPath, "/"), Size: size, Dir: response.Collection != nil})
+	}
+	return entries, nil
+}
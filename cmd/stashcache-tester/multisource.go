@@ -0,0 +1,120 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// multiSourceEnv has xrdcp read local metalink files and log which servers
+// it reads from, so the sources that contributed can be found
+var multiSourceEnv = XRDEnv{"XRD_LOCALMETALINKFILE": "1", "XRD_LOGLEVEL": "Debug"}
+
+type metalinkURL struct {
+	Priority int    `xml:"priority,attr"`
+	URL      string `xml:",chardata"`
+}
+
+type metalinkFile struct {
+	Name string        `xml:"name,attr"`
+	URLs []metalinkURL `xml:"url"`
+}
+
+type metalink struct {
+	XMLName xml.Name       `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Files   []metalinkFile `xml:"file"`
+}
+
+// sourceURLs returns uri as served by dnsname followed by the same path on
+// each of the test set's extra sources
+func sourceURLs(ts TestSet, uri string) ([]string, error) {
+	urls := []string{uri}
+	for _, source := range ts.Sources {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, err
+		}
+		u.Host = source
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
+
+// writeMetalink writes a metalink file listing urls as sources for the
+// file saved as filename, returning the metalink's name
+func writeMetalink(filename string, urls []string) (string, error) {
+	file := metalinkFile{Name: filepath.Base(filename)}
+	for i, u := range urls {
+		file.URLs = append(file.URLs, metalinkURL{Priority: i + 1, URL: u})
+	}
+	contents, err := xml.MarshalIndent(metalink{Files: []metalinkFile{file}}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := filename + ".meta4"
+	if err := ioutil.WriteFile(name, append([]byte(xml.Header), contents...), 0644); err != nil {
+		return "", fmt.Errorf("can't write metalink %s: %s", name, err)
+	}
+	return name, nil
+}
+
+// sourceMarkers start the part of a line of xrdcp's debug output naming the
+// server a file was opened at, read from or redirected to.  Other lines,
+// like the ones listing the metalink, mention every source.
+var sourceMarkers = []string{
+	"successfully opened at ",
+	"Sending a read command",
+	"Sending a pgread command",
+	"Sending a readv command",
+	"Redirected to ",
+	"redirection to ",
+}
+
+// contributingSources returns the hosts of urls that xrdcp's debug output
+// shows it opened the file at, read from or was redirected to
+func contributingSources(output string, urls []string) []string {
+	var hosts []string
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(output, "\n") {
+			if sourceLine(line, parsed.Host) {
+				hosts = append(hosts, parsed.Host)
+				break
+			}
+		}
+	}
+	return hosts
+}
+
+// sourceLine is whether line shows data coming from host, which has to
+// follow one of the markers
+func sourceLine(line string, host string) bool {
+	for _, marker := range sourceMarkers {
+		if i := strings.Index(line, marker); i >= 0 && strings.Contains(line[i:], host) {
+			return true
+		}
+	}
+	return false
+}
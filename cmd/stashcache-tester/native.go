@@ -0,0 +1,149 @@
+//go:build native
+// +build native
+
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// The native backend reads files with the pure Go xrootd client from
+// go-hep.org so the tester can run without the xrootd client installed.
+// It's only included when building with -tags native.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"go-hep.org/x/hep/xrootd"
+	"go-hep.org/x/hep/xrootd/xrdfs"
+)
+
+type nativeBackend struct{}
+
+func init() {
+	registerBackend("native", nativeBackend{})
+}
+
+func (nativeBackend) URL(ts TestSet, remotePath string) string {
+	return "root://" + ts.endpoint("root") + "/" + remotePath
+}
+
+func (nativeBackend) Download(parent context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	payload := newFilePayload("stashcache-tester-native", ts, filename)
+	start := time.Now()
+	timings := newTransferTimings(start)
+	payload.Start1 = unixMillis(start)
+	payload.Tries = ts.tries()
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	var progress progressCounter
+	stalls := watchStalls(ts, progress.progress, cancel)
+	defer stalls.stop()
+	hung := watchHung(parent, ts, uri, payload, progress.progress)
+	defer hung.stop()
+
+	fail := func(err error) (ESPayload, error) {
+		end := time.Now()
+		payload.End1 = unixMillis(end)
+		payload.DownloadTime = end.Sub(start).Seconds() * 1000
+		payload.TimeStamp = nowMillis()
+		payload.Status = "Failure"
+		timings.record(&payload)
+		fmt.Printf("Can't download %s\nError: %s\n", uri, err)
+		class := errorClass(err)
+		if ctx.Err() == context.DeadlineExceeded {
+			class = ErrorClassTimeout
+		} else if parent.Err() != nil {
+			class = ErrorClassCancelled
+		}
+		err = classify(class, fmt.Errorf("Can't download %s\nError: %s\n", uri, err))
+		err = stalls.check(err, uri, &payload)
+		payload.FailureCategory = failureCategory(err, "")
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
+	}
+
+	digester, err := newDigestWriter(ts)
+	if err != nil {
+		return fail(err)
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fail(err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "1094")
+	}
+	debugf("Reading %s with the native xrootd client\n", uri)
+	client, err := xrootd.NewClient(ctx, addr, "stashcache-tester")
+	if err != nil {
+		return fail(err)
+	}
+	// the client resolves, connects and logs in in one go
+	timings.set(&timings.connectDone)
+	defer client.Close()
+
+	remote, err := client.FS().Open(ctx, path.Clean(u.Path), xrdfs.OpenModeOwnerRead, xrdfs.OpenOptionsOpenRead)
+	if err != nil {
+		return fail(err)
+	}
+	defer remote.Close(ctx)
+	stat, err := remote.Stat(ctx)
+	if err != nil {
+		return fail(err)
+	}
+
+	var written int64
+	source := throttle(io.NewSectionReader(remote, 0, stat.EntrySize), ts)
+	recordRateLimits(ts, &payload)
+	if ts.Discard {
+		written, err = io.Copy(io.MultiWriter(digester, firstByteWriter{timings}, &progress), source)
+	} else {
+		out, createErr := os.Create(filename)
+		if createErr != nil {
+			return fail(createErr)
+		}
+		written, err = io.Copy(io.MultiWriter(out, digester, firstByteWriter{timings}, &progress), source)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	payload.DownloadSize = written
+	digester.record(&payload)
+	if err != nil {
+		return fail(err)
+	}
+
+	end := time.Now()
+	payload.Status = "Success"
+	payload.XRDExit1 = "0"
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.FileSize = written
+	payload.TimeStamp = nowMillis()
+	timings.record(&payload)
+	debugf("Downloaded %s (%s) in %s\n", uri, ByteSize(written), end.Sub(start).Round(time.Millisecond))
+	return payload, nil
+}
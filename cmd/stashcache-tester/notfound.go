@@ -0,0 +1,105 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// the notfound test type requests paths that don't exist and checks the
+// cache says so quickly, a hanging or garbled error path stalls every job
+// that looks for a file before reading it
+func init() {
+	registerTestType("notfound", runNotFoundTest)
+}
+
+// defaultMaxErrorLatency is how long notfound test sets allow for a missing
+// file to be reported when maxerrorlatency isn't given
+const defaultMaxErrorLatency = 10 * time.Second
+
+func runNotFoundTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	for i, testFile := range ts.TestFiles {
+		uri, payload, err := notFoundTestFile(ctx, ts, testFile)
+		result.files[i].URL = uri
+		result.files[i].Status = payload.Status
+		result.files[i].Duration = time.Duration(payload.ErrorLatency * float64(time.Millisecond))
+		if err != nil {
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("missing file %s: %s", testFile.Path, err)))
+		}
+	}
+	return result.aggregate()
+}
+
+// notFoundTestFile downloads a path that shouldn't exist and checks the
+// client was told it's missing within maxerrorlatency, reporting how long
+// that took as error_latency
+func notFoundTestFile(ctx context.Context, ts TestSet, testFile TestFile) (string, ESPayload, error) {
+	maxLatency := time.Duration(ts.MaxErrorLatency)
+	if maxLatency == 0 {
+		maxLatency = defaultMaxErrorLatency
+	}
+	filename := ts.localPath(testFile.Path)
+	// the download's own failure isn't reported, only whether it failed
+	// the right way
+	downloadTS := ts
+	downloadTS.Collector = nil
+	start := time.Now()
+	uri, payload, _, downloadErr := downloadTestPath(ctx, downloadTS, testFile.Path, filename, testFile.timeout(ts))
+	latency := time.Since(start)
+	os.Remove(filename)
+	if payload.Cache == "" {
+		payload = newFilePayload("stashcache-tester", ts, filename)
+	}
+	payload.TestType = ts.Type
+	payload.ErrorLatency = latency.Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+
+	var err error
+	switch {
+	case downloadErr == nil:
+		err = classify(ErrorClassNotFound, fmt.Errorf("%s was downloaded (%s) but shouldn't exist", uri, ByteSize(payload.DownloadSize)))
+	case errorClass(downloadErr) == ErrorClassTimeout || errorClass(downloadErr) == ErrorClassStalled:
+		err = downloadErr
+	case failureCategory(downloadErr, payload.ClientOutput) != FailureNoSuchFile:
+		err = classify(ErrorClassNotFound, fmt.Errorf("expected not found, got: %s", downloadErr))
+	case latency > maxLatency:
+		err = classify(ErrorClassNotFound, fmt.Errorf("not found took %s, longer than %s", latency.Round(time.Millisecond), maxLatency))
+	}
+	if err != nil {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.FailureCategory = failureCategory(err, payload.ClientOutput)
+		fmt.Printf("Missing file %s wasn't reported correctly: %s\n", uri, err)
+		ReportTest(ctx, payload, ts.Collector)
+		return uri, payload, err
+	}
+	payload.Status = "Success"
+	payload.Error = ""
+	payload.FailureCategory = FailureNoSuchFile
+	debugf("%s was reported missing in %s\n", uri, latency.Round(time.Millisecond))
+	ReportTest(ctx, payload, ts.Collector)
+	return uri, payload, nil
+}
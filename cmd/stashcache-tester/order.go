@@ -0,0 +1,62 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// siteOrder decides the order sites are tested in: "config" (the order sites
+// first appear in the config), "alpha" or "random"
+type siteOrder struct {
+	mode string
+	seed int64
+	rng  *rand.Rand
+}
+
+func newSiteOrder(mode string, seed int64) (*siteOrder, error) {
+	switch mode {
+	case "config", "alpha", "random":
+	default:
+		return nil, fmt.Errorf("invalid --order %q, must be config, alpha or random", mode)
+	}
+	return &siteOrder{mode: mode, seed: seed, rng: rand.New(rand.NewSource(seed))}, nil
+}
+
+// sites returns the names of the sites in testSets in the order they should
+// be tested, each call with random ordering gives a new shuffle
+func (o *siteOrder) sites(testSets []TestSet) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, ts := range testSets {
+		if !seen[ts.SiteName] {
+			seen[ts.SiteName] = true
+			names = append(names, ts.SiteName)
+		}
+	}
+	switch o.mode {
+	case "alpha":
+		sort.Strings(names)
+	case "random":
+		o.rng.Shuffle(len(names), func(i, j int) {
+			names[i], names[j] = names[j], names[i]
+		})
+	}
+	return names
+}
@@ -0,0 +1,88 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+)
+
+// compareOrigin downloads a test file straight from the test set's origin
+// with the same backend, recording the origin's download time and how many
+// times faster the cache was in the payload.  The warm download is compared
+// if there was one, as a cold download includes the cache fetching the file
+// from the origin.  The origin's copy has to match the cache's for the times
+// to be compared.  Problems with the origin are logged but aren't the
+// cache's failure.
+func compareOrigin(ctx context.Context, ts TestSet, remotePath string, filename string, timeout time.Duration, payload *ESPayload) {
+	if isTestURL(remotePath) {
+		u, err := url.Parse(remotePath)
+		if err != nil {
+			infof("Can't parse %s to download it from the origin: %s\n", remotePath, err)
+			return
+		}
+		remotePath = u.Path
+	}
+	originTS := ts
+	originTS.DNSName, originTS.requestedPath = ts.Origin, ""
+	// the ports, address and sources configured are for dnsname
+	originTS.XRootPort, originTS.HTTPPort, originTS.HTTPSPort, originTS.address = 0, 0, 0, ""
+	originTS.Sources = nil
+	// only the cache's payload is reported
+	originTS.Collector = nil
+	backend, err := lookupBackend(originTS)
+	if err != nil {
+		infof("Can't download %s from the origin: %s\n", remotePath, err)
+		return
+	}
+	uri := backend.URL(originTS, remotePath)
+	// xrdcp won't overwrite the cache's download
+	os.Remove(filename)
+	origin, err := backend.Download(ctx, uri, filename, originTS, timeout)
+	if err != nil {
+		infof("Can't download %s from the origin: %s\n", uri, err)
+		return
+	}
+	if origin.DownloadSize != payload.DownloadSize {
+		infof("%s from the origin is %d bytes, from the cache %d\n", uri, origin.DownloadSize, payload.DownloadSize)
+		return
+	}
+	if origin.digests == nil {
+		if origin.digests, err = fileDigests(ts, filename); err != nil {
+			infof("Can't hash %s from the origin: %s\n", uri, err)
+			return
+		}
+	}
+	if cacheSum, originSum := payload.digests[ts.HashAlgorithm], origin.digests[ts.HashAlgorithm]; cacheSum != originSum {
+		infof("%s from the origin has %s %s, from the cache %s\n", uri, ts.HashAlgorithm, originSum, cacheSum)
+		return
+	}
+	cacheTime := payload.DownloadTime
+	if payload.WarmDownloadTime > 0 {
+		cacheTime = payload.WarmDownloadTime
+	}
+	payload.OriginDownloadTime = origin.DownloadTime
+	if cacheTime > 0 {
+		payload.OriginRatio = origin.DownloadTime / cacheTime
+	}
+	if payload.OriginRatio > 0 && payload.OriginRatio < 1 {
+		infof("%s is slower from the cache (%.0fms) than from the origin (%.0fms)\n", remotePath, cacheTime, origin.DownloadTime)
+	}
+	debugf("Downloaded %s from the cache in %.0fms and from the origin in %.0fms, a ratio of %.2f\n", remotePath, cacheTime, origin.DownloadTime, payload.OriginRatio)
+}
@@ -0,0 +1,55 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// outputLevel controls how much the run command prints
+type outputLevel int
+
+const (
+	quietOutput   outputLevel = iota // only failures and the final summary
+	normalOutput                     // progress for each site
+	verboseOutput                    // also per-file timings and xrdcp command lines
+)
+
+// verbosity is set by --quiet and --verbose
+var verbosity = normalOutput
+
+// infof prints progress messages, which --quiet suppresses
+func infof(format string, args ...interface{}) {
+	if verbosity >= normalOutput {
+		fmt.Printf(format, args...)
+	}
+}
+
+// debugf prints details only wanted with --verbose
+func debugf(format string, args ...interface{}) {
+	if verbosity >= verboseOutput {
+		fmt.Printf(format, args...)
+	}
+}
+
+// commandLine formats a command with its environment settings so it can be
+// pasted into a shell
+func commandLine(env []string, name string, args ...string) string {
+	parts := append(append([]string{}, env...), name)
+	return strings.Join(append(parts, args...), " ")
+}
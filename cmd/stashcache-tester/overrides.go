@@ -0,0 +1,177 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// configSets holds the --set overrides given on the command line, they're
+// applied to every config file as it's loaded
+var configSets stringList
+
+// pathElem is one step of an override path, either a key in an object or a
+// selector for list elements
+type pathElem struct {
+	key      string
+	selector string
+	isList   bool
+}
+
+type configOverride struct {
+	text  string
+	path  []pathElem
+	value string
+}
+
+// parseOverride parses path.to.key=value, list elements are selected with
+// [index] or [name] where name matches a test set's testsetname, sitename or
+// sitename/testsetname, or a reporter's name
+func parseOverride(text string) (configOverride, error) {
+	override := configOverride{text: text}
+	eq := strings.Index(text, "=")
+	if eq <= 0 {
+		return override, fmt.Errorf("invalid --set %q, expected path.to.key=value", text)
+	}
+	override.value = text[eq+1:]
+	for _, part := range strings.Split(text[:eq], ".") {
+		key := part
+		var selectors []string
+		if open := strings.Index(part, "["); open >= 0 {
+			key = part[:open]
+			rest := part[open:]
+			for rest != "" {
+				end := strings.Index(rest, "]")
+				if rest[0] != '[' || end < 0 {
+					return override, fmt.Errorf("invalid --set %q, unbalanced [ ] in %q", text, part)
+				}
+				selectors = append(selectors, rest[1:end])
+				rest = rest[end+1:]
+			}
+		}
+		if key == "" && len(selectors) == 0 {
+			return override, fmt.Errorf("invalid --set %q, empty key", text)
+		}
+		if key != "" {
+			override.path = append(override.path, pathElem{key: key})
+		}
+		for _, selector := range selectors {
+			override.path = append(override.path, pathElem{selector: selector, isList: true})
+		}
+	}
+	return override, nil
+}
+
+// applyOverrides applies --set overrides to a generically decoded config,
+// returning the possibly replaced root
+func applyOverrides(generic interface{}, sets []string) (interface{}, error) {
+	if len(sets) == 0 {
+		return generic, nil
+	}
+	root, ok := generic.(map[string]interface{})
+	if list, isList := generic.([]interface{}); isList {
+		root, ok = map[string]interface{}{"testsets": list}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("can't apply --set, config isn't an object or list")
+	}
+	for _, text := range sets {
+		override, err := parseOverride(text)
+		if err != nil {
+			return nil, err
+		}
+		if err := setPath(root, override.path, override); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+func setPath(node interface{}, path []pathElem, override configOverride) error {
+	elem := path[0]
+	if elem.isList {
+		list, ok := node.([]interface{})
+		if !ok {
+			return fmt.Errorf("--set %s: [%s] used on something that isn't a list", override.text, elem.selector)
+		}
+		for _, i := range selectElements(list, elem.selector) {
+			if i < 0 || i >= len(list) {
+				return fmt.Errorf("--set %s: index %d out of range, list has %d entries", override.text, i, len(list))
+			}
+			if len(path) == 1 {
+				list[i] = overrideValue(list[i], override.value)
+			} else if err := setPath(list[i], path[1:], override); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	object, ok := node.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("--set %s: %s used on something that isn't an object", override.text, elem.key)
+	}
+	if len(path) == 1 {
+		object[elem.key] = overrideValue(object[elem.key], override.value)
+		return nil
+	}
+	child, exists := object[elem.key]
+	if !exists || child == nil {
+		if path[1].isList {
+			return fmt.Errorf("--set %s: %s doesn't exist", override.text, elem.key)
+		}
+		child = make(map[string]interface{})
+		object[elem.key] = child
+	}
+	return setPath(child, path[1:], override)
+}
+
+// selectElements returns the indexes of the list elements a selector picks
+func selectElements(list []interface{}, selector string) []int {
+	if i, err := strconv.Atoi(selector); err == nil {
+		return []int{i}
+	}
+	var selected []int
+	for i, elem := range list {
+		object, ok := elem.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		site, _ := object["sitename"].(string)
+		name, _ := object["testsetname"].(string)
+		reporter, _ := object["name"].(string)
+		if selector == name || selector == site || selector == site+"/"+name || selector == reporter {
+			selected = append(selected, i)
+		}
+	}
+	return selected
+}
+
+// overrideValue interprets an override the way YAML would, except that a
+// scalar replacing an existing string stays a string
+func overrideValue(current interface{}, value string) interface{} {
+	isFlow := strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{")
+	if _, isString := current.(string); (isString && !isFlow) || value == "" {
+		return value
+	}
+	if parsed, err := parseYAMLScalar(value); err == nil {
+		return parsed
+	}
+	return value
+}
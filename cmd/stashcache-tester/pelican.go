@@ -0,0 +1,51 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// pelicanBackend runs "pelican object get", which asks the federation's
+// director for a cache, so dnsname is only used for reporting
+type pelicanBackend struct{}
+
+func init() {
+	registerBackend("pelican", pelicanBackend{})
+}
+
+// isFederationURL reports whether a test path is an osdf:// or pelican://
+// url rather than a path on the test set's cache
+func isFederationURL(remotePath string) bool {
+	return strings.HasPrefix(remotePath, "osdf://") || strings.HasPrefix(remotePath, "pelican://")
+}
+
+// URL returns osdf:// and pelican:// urls as they are, plain paths are
+// looked up in the OSDF
+func (pelicanBackend) URL(ts TestSet, remotePath string) string {
+	if isFederationURL(remotePath) || isStashURL(remotePath) {
+		return remotePath
+	}
+	return "osdf://" + remotePath
+}
+
+func (pelicanBackend) Download(ctx context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	return execDownload(ctx, "pelican", []string{"object", "get", "-d", uri, filename}, nil, recordServedBy,
+		uri, filename, ts, timeout)
+}
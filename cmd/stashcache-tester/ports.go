@@ -0,0 +1,105 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Port is a port given in the config, 0 when it isn't so the default for
+// the protocol is used
+type Port int
+
+func (p *Port) UnmarshalJSON(data []byte) error {
+	var n float64
+	if err := json.Unmarshal(data, &n); err != nil || n != float64(int(n)) {
+		return fmt.Errorf("port must be a whole number")
+	}
+	if n < 1 || n > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	*p = Port(n)
+	return nil
+}
+
+// defaultPorts are the ports caches serve each protocol on
+var defaultPorts = map[string]int{"root": 1094, "http": 8000, "https": 8443}
+
+// urlPorts are the ports urls for each scheme use when they don't give one
+var urlPorts = map[string]int{"root": 1094, "http": 80, "https": 443}
+
+// splitHostPort splits a dnsname that may be host:port, port is empty if
+// there isn't one
+func splitHostPort(hostport string) (host string, port string) {
+	if host, port, err := net.SplitHostPort(hostport); err == nil {
+		return host, port
+	}
+	return strings.Trim(hostport, "[]"), ""
+}
+
+// hostname returns the cache's dnsname without any port
+func (ts TestSet) hostname() string {
+	host, _ := splitHostPort(ts.DNSName)
+	return host
+}
+
+// port returns the port the cache serves scheme on.  A port in dnsname is
+// the xrootd port, as dnsname has always been the xrootd endpoint.
+func (ts TestSet) port(scheme string) int {
+	switch scheme {
+	case "root":
+		if ts.XRootPort != 0 {
+			return int(ts.XRootPort)
+		}
+		if _, port := splitHostPort(ts.DNSName); port != "" {
+			if n, err := strconv.Atoi(port); err == nil {
+				return n
+			}
+		}
+	case "http":
+		if ts.HTTPPort != 0 {
+			return int(ts.HTTPPort)
+		}
+	case "https":
+		if ts.HTTPSPort != 0 {
+			return int(ts.HTTPSPort)
+		}
+	}
+	return defaultPorts[scheme]
+}
+
+// endpoint returns the host[:port] to put in a scheme url for the address
+// being tested
+func (ts TestSet) endpoint(scheme string) string {
+	return urlHost(strings.Trim(ts.host(), "[]"), scheme, ts.port(scheme))
+}
+
+// urlHost returns host and port as they go in a scheme url, leaving out the
+// port if it's the url default
+func urlHost(host string, scheme string, port int) string {
+	if port != urlPorts[scheme] {
+		return net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
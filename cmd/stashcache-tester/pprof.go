@@ -0,0 +1,110 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"syscall"
+	"time"
+)
+
+// startProfiling starts what the run command's profiling flags ask for and
+// returns the function that finishes it at the end of the run:
+//   - pprofAddr serves net/http/pprof, e.g. localhost:6060
+//   - cpuPath gets a CPU profile of the whole run
+//   - memPath gets a heap profile at the end of the run
+//
+// SIGUSR1 writes a heap profile at any time, next to memPath or in the
+// system temporary directory.
+func startProfiling(pprofAddr string, cpuPath string, memPath string) (func(), error) {
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return nil, fmt.Errorf("can't serve pprof on %s: %s", pprofAddr, err)
+		}
+		infof("Serving pprof on http://%s/debug/pprof/\n", listener.Addr())
+		go http.Serve(listener, nil)
+	}
+	var cpuFile *os.File
+	if cpuPath != "" {
+		var err error
+		if cpuFile, err = os.Create(cpuPath); err != nil {
+			return nil, fmt.Errorf("can't create CPU profile: %s", err)
+		}
+		if err := pprof.StartCPUProfile(cpuFile); err != nil {
+			cpuFile.Close()
+			return nil, fmt.Errorf("can't start CPU profile: %s", err)
+		}
+	}
+
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
+	go func() {
+		for range usr1 {
+			name := onDemandProfilePath(memPath)
+			if err := writeHeapProfile(name); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			} else {
+				fmt.Printf("Wrote heap profile %s\n", name)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(usr1)
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+		if memPath != "" {
+			if err := writeHeapProfile(memPath); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}, nil
+}
+
+// onDemandProfilePath names a heap profile written on SIGUSR1
+func onDemandProfilePath(memPath string) string {
+	dir := os.TempDir()
+	if memPath != "" {
+		dir = filepath.Dir(memPath)
+	}
+	return filepath.Join(dir, "stashcache-tester-heap-"+time.Now().Format("20060102T150405")+".pprof")
+}
+
+func writeHeapProfile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return fmt.Errorf("can't create heap profile: %s", err)
+	}
+	defer f.Close()
+	// get up to date statistics
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		return fmt.Errorf("can't write heap profile %s: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ServiceProbe is what the probe command found out about one service
+type ServiceProbe struct {
+	Service   string  `json:"service"`
+	Port      int     `json:"port"`
+	Reachable bool    `json:"reachable"`
+	ConnectMS float64 `json:"connect_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+
+	// xroot
+	ProtocolVersion string   `json:"protocol_version,omitempty"`
+	Role            string   `json:"role,omitempty"`
+	Attributes      []string `json:"attributes,omitempty"`
+
+	// http and https
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Server     string `json:"server,omitempty"`
+
+	// https
+	TLSVersion  string     `json:"tls_version,omitempty"`
+	CipherSuite string     `json:"cipher_suite,omitempty"`
+	Subject     string     `json:"subject,omitempty"`
+	Issuer      string     `json:"issuer,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Verified    bool       `json:"verified,omitempty"`
+	VerifyError string     `json:"verify_error,omitempty"`
+}
+
+// probeCommand checks which services a cache exposes and prints a table, or
+// json, of what it found
+func probeCommand(args []string) int {
+	flags := newFlagSet("probe", "[options] <host>")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout for each service")
+	xrootPort := flags.Int("xroot-port", defaultPorts["root"], "xrootd port")
+	httpPort := flags.Int("http-port", defaultPorts["http"], "HTTP port")
+	httpsPort := flags.Int("https-port", defaultPorts["https"], "HTTPS port")
+	jsonOutput := flags.Bool("json", false, "print the results as json")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return ExitConfigError
+	}
+	host, port := splitHostPort(flags.Arg(0))
+	if port != "" {
+		var err error
+		if *xrootPort, err = strconv.Atoi(port); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid port %q\n", port)
+			return ExitConfigError
+		}
+	}
+
+	probes := []ServiceProbe{
+		probeXRootD(host, *xrootPort, *timeout),
+		probeHTTP(host, "http", *httpPort, *timeout),
+		probeHTTP(host, "https", *httpsPort, *timeout),
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(probes)
+	} else {
+		printProbes(host, probes)
+	}
+	for _, probe := range probes {
+		if probe.Reachable {
+			return 0
+		}
+	}
+	return 1
+}
+
+func printProbes(host string, probes []ServiceProbe) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "%s\nSERVICE\tPORT\tSTATUS\tDETAILS\n", host)
+	for _, probe := range probes {
+		status := "closed"
+		if probe.Reachable {
+			status = fmt.Sprintf("open (%.0fms)", probe.ConnectMS)
+		}
+		var details []string
+		if probe.ProtocolVersion != "" {
+			details = append(details, "protocol "+probe.ProtocolVersion)
+		}
+		if probe.Role != "" {
+			details = append(details, probe.Role)
+		}
+		details = append(details, probe.Attributes...)
+		if probe.HTTPStatus != 0 {
+			details = append(details, fmt.Sprintf("HTTP %d", probe.HTTPStatus))
+		}
+		if probe.Server != "" {
+			details = append(details, "server "+probe.Server)
+		}
+		if probe.TLSVersion != "" {
+			details = append(details, probe.TLSVersion, probe.CipherSuite,
+				"certificate expires "+probe.NotAfter.Format("2006-01-02"))
+			if probe.Verified {
+				details = append(details, "verified")
+			} else {
+				details = append(details, "not verified: "+probe.VerifyError)
+			}
+		}
+		if probe.Error != "" {
+			details = append(details, probe.Error)
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\n", probe.Service, probe.Port, status, strings.Join(details, ", "))
+	}
+	table.Flush()
+}
+
+// probeXRootD does the xrootd handshake and kXR_protocol to find the
+// server's protocol version and role
+func probeXRootD(host string, port int, timeout time.Duration) ServiceProbe {
+	probe := ServiceProbe{Service: "xroot", Port: port}
+	start := time.Now()
+	conn, err := connectXRootD(net.JoinHostPort(host, strconv.Itoa(port)), start.Add(timeout))
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	defer conn.Close()
+	probe.Reachable = true
+	probe.ConnectMS = time.Since(start).Seconds() * 1000
+	version, flags, err := conn.protocol()
+	if err != nil {
+		// old servers only give the handshake's details
+		probe.ProtocolVersion = formatXRDVersion(conn.protocolVersion)
+		probe.Error = fmt.Sprintf("kXR_protocol failed: %s", err)
+		return probe
+	}
+	probe.ProtocolVersion = formatXRDVersion(version)
+	switch {
+	case flags&kXRIsManager != 0:
+		probe.Role = "redirector"
+	case flags&kXRIsServer != 0:
+		probe.Role = "data server"
+	}
+	for _, attr := range []struct {
+		flag uint32
+		name string
+	}{{kXRAttrProxy, "proxy"}, {kXRAttrMeta, "meta manager"}, {kXRAttrSuper, "supervisor"},
+		{kXRHaveTLS, "tls"}, {kXRGotoTLS, "requires tls"}} {
+		if flags&attr.flag != 0 {
+			probe.Attributes = append(probe.Attributes, attr.name)
+		}
+	}
+	return probe
+}
+
+// probeHTTP sends HEAD / to the HTTP or HTTPS port, recording the TLS
+// handshake for HTTPS.  The certificate is verified separately so the
+// request is made even if it isn't trusted.
+func probeHTTP(host string, scheme string, port int, timeout time.Duration) ServiceProbe {
+	probe := ServiceProbe{Service: scheme, Port: port}
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	conn.Close()
+	probe.Reachable = true
+	probe.ConnectMS = time.Since(start).Seconds() * 1000
+
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	req, err := http.NewRequest("HEAD", fmt.Sprintf("%s://%s/", scheme, addr), nil)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	resp, err := client.Do(req)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	resp.Body.Close()
+	probe.HTTPStatus = resp.StatusCode
+	probe.Server = resp.Header.Get("Server")
+	if resp.TLS != nil {
+		recordTLS(&probe, host, resp.TLS)
+	}
+	return probe
+}
+
+// recordTLS records the negotiated TLS parameters and whether the server's
+// certificate is trusted for host
+func recordTLS(probe *ServiceProbe, host string, state *tls.ConnectionState) {
+	probe.TLSVersion = tlsVersionName(state.Version)
+	probe.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	if len(state.PeerCertificates) == 0 {
+		probe.VerifyError = "no certificate"
+		return
+	}
+	cert := state.PeerCertificates[0]
+	probe.Subject = cert.Subject.String()
+	probe.Issuer = cert.Issuer.String()
+	probe.NotAfter = &cert.NotAfter
+	if err := verifyCertificate(host, state.PeerCertificates); err != nil {
+		probe.VerifyError = err.Error()
+		return
+	}
+	probe.Verified = true
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS 0x%04x", version)
+}
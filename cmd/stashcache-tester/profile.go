@@ -0,0 +1,100 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// profileFilter returns a filter matching the sites in the named profiles,
+// profiles list site names or --site style patterns.  An empty list of
+// names selects every site.
+func profileFilter(config *Config, names []string) (*nameFilter, error) {
+	var patterns []string
+	for _, name := range names {
+		sites, ok := config.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, the config defines: %s", name, profileNames(config))
+		}
+		patterns = append(patterns, sites...)
+	}
+	return newNameFilter(patterns)
+}
+
+// profileNames lists the profiles in a config for error messages
+func profileNames(config *Config) string {
+	if len(config.Profiles) == 0 {
+		return "no profiles"
+	}
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// selectProfiles loads and validates a config and returns the test sets for
+// sites in the named profiles
+func selectProfiles(configLocation string, profiles []string) ([]TestSet, error) {
+	config, err := loadConfigFile(configLocation)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfig(configLocation, config); err != nil {
+		return nil, err
+	}
+	if len(profiles) == 0 {
+		return config.TestSets, nil
+	}
+	filter, err := profileFilter(config, profiles)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", configLocation, err)
+	}
+	if filter.empty() {
+		return nil, fmt.Errorf("%s: profile %s doesn't list any sites", configLocation, strings.Join(profiles, ", "))
+	}
+	return filterTestSets(config.TestSets, filter, &nameFilter{}), nil
+}
+
+// validateProfiles checks that profile patterns are valid and match at
+// least one site
+func validateProfiles(config *Config) []ValidationError {
+	var errs []ValidationError
+	var names []string
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, pattern := range config.Profiles[name] {
+			filter, err := newNameFilter([]string{pattern})
+			if err != nil {
+				errs = append(errs, ValidationError{Index: -1, Field: "profiles." + name,
+					Message: fmt.Sprintf("profile %s: %s", name, err)})
+				continue
+			}
+			if len(filterTestSets(config.TestSets, filter, &nameFilter{})) == 0 {
+				errs = append(errs, ValidationError{Index: -1, Field: "profiles." + name,
+					Message: fmt.Sprintf("profile %s: %q doesn't match any site", name, pattern)})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,101 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// proxyBackends can download through the proxy setting
+var proxyBackends = map[string]bool{"http": true, "https": true, "curl": true}
+
+// ProxyDirect as a test set's proxy ignores HTTP_PROXY and HTTPS_PROXY
+const ProxyDirect = "direct"
+
+type proxyKey struct{}
+
+// requestContext returns ctx carrying what the shared HTTP client needs for
+// a test set's requests, the address to connect to and the proxy to use
+func requestContext(ctx context.Context, ts TestSet) context.Context {
+	ctx = withDialAddress(ctx, ts.address)
+	if ts.Proxy != "" {
+		ctx = context.WithValue(ctx, proxyKey{}, ts.Proxy)
+	}
+	return ctx
+}
+
+// requestProxy is the shared HTTP client's proxy function, using the test
+// set's proxy if it has one and HTTP_PROXY, HTTPS_PROXY and NO_PROXY if
+// not.  Requests for a single address of a cache go direct as the proxy
+// would connect to the name.
+func requestProxy(req *http.Request) (*url.URL, error) {
+	if _, ok := req.Context().Value(dialAddressKey{}).(string); ok {
+		return nil, nil
+	}
+	if proxy, ok := req.Context().Value(proxyKey{}).(string); ok {
+		if proxy == ProxyDirect {
+			return nil, nil
+		}
+		return url.Parse(proxy)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// proxyUsed returns the proxy a request will go through, without any
+// credentials, for the payload
+func proxyUsed(req *http.Request) string {
+	proxy, err := requestProxy(req)
+	if err != nil || proxy == nil {
+		return ""
+	}
+	redacted := *proxy
+	redacted.User = nil
+	return redacted.String()
+}
+
+// proxyFor returns the proxy requests for uri go through for a test set
+func proxyFor(ts TestSet, uri string) string {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return ""
+	}
+	return proxyUsed(req.WithContext(requestContext(context.Background(), ts)))
+}
+
+// checkProxy returns a description of what's wrong with a proxy setting or
+// an empty string if it's usable
+func checkProxy(proxy string) string {
+	if proxy == ProxyDirect {
+		return ""
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err.Error()
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Sprintf("proxy must be %s or an http://, https:// or socks5:// url", ProxyDirect)
+	}
+	if u.Host == "" {
+		return "proxy url has no host"
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// PushgatewayEnvVar names the environment variable with the Pushgateway
+// used when --pushgateway isn't given
+const PushgatewayEnvVar = "STASHCACHE_TESTER_PUSHGATEWAY"
+
+// DefaultPushgatewayJob is the job label of the pushed metrics
+const DefaultPushgatewayJob = "stashcache_tester"
+
+// checkPushgateway returns an error if gateway isn't a Pushgateway url
+func checkPushgateway(gateway string) error {
+	if u, err := url.Parse(gateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid Pushgateway url %q", gateway)
+	}
+	return nil
+}
+
+// pushMetrics replaces this host's metrics for job on a Prometheus
+// Pushgateway with the round's, so test sets that are no longer run don't
+// keep their last values
+func pushMetrics(ctx context.Context, gateway string, job string, run *RunResult) error {
+	instance, _ := os.Hostname()
+	if instance == "" {
+		instance = "unknown"
+	}
+	target := strings.TrimRight(gateway, "/") + "/metrics/job/" + url.PathEscape(job) + "/instance/" + url.PathEscape(instance)
+	req, err := http.NewRequestWithContext(ctx, "PUT", target, bytes.NewReader(runMetrics(run)))
+	if err != nil {
+		return fmt.Errorf("can't push metrics to %s: %s", gateway, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := reportHTTPClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("can't push metrics to %s: %s", gateway, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		status := resp.Status
+		if message := strings.TrimSpace(string(body)); message != "" {
+			status += ": " + message
+		}
+		return fmt.Errorf("can't push metrics to %s: %s", gateway, status)
+	}
+	return nil
+}
+
+// testSetMetrics are the metrics pushed for every test set, labelled with
+// its site, cache, address and test set
+var testSetMetrics = []struct {
+	name  string
+	help  string
+	value func(s TestSetSummary) float64
+}{
+	{"stashcache_tester_success", "1 if the test set passed or was degraded, 0 if it failed", func(s TestSetSummary) float64 {
+		if s.failed() {
+			return 0
+		}
+		return 1
+	}},
+	{"stashcache_tester_duration_seconds", "Time taken by the test set", func(s TestSetSummary) float64 {
+		return s.Duration.Seconds()
+	}},
+	{"stashcache_tester_download_seconds", "Time taken by the test set's successful downloads", func(s TestSetSummary) float64 {
+		_, duration := summaryTransfers(s)
+		return duration.Seconds()
+	}},
+	{"stashcache_tester_bytes", "Bytes of the test set's successful downloads", func(s TestSetSummary) float64 {
+		bytes, _ := summaryTransfers(s)
+		return float64(bytes)
+	}},
+	{"stashcache_tester_throughput_bytes_per_second", "Download speed of the test set's successful downloads", func(s TestSetSummary) float64 {
+		bytes, duration := summaryTransfers(s)
+		if duration <= 0 {
+			return 0
+		}
+		return float64(bytes) / duration.Seconds()
+	}},
+}
+
+// runMetrics writes the round's metrics in the Prometheus text format
+func runMetrics(run *RunResult) []byte {
+	buf := new(bytes.Buffer)
+	testSets := run.testSets()
+	for _, metric := range testSetMetrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, s := range testSets {
+			fmt.Fprintf(buf, "%s{site=%s,cache=%s,address=%s,testset=%s} %g\n", metric.name,
+				metricLabel(s.SiteName), metricLabel(s.Cache), metricLabel(s.Address), metricLabel(s.TestSetName), metric.value(s))
+		}
+	}
+	fmt.Fprintf(buf, "# HELP stashcache_tester_report_failures Payloads the round couldn't report\n# TYPE stashcache_tester_report_failures gauge\n")
+	fmt.Fprintf(buf, "stashcache_tester_report_failures %d\n", run.ReportFailures)
+	fmt.Fprintf(buf, "# HELP stashcache_tester_run_duration_seconds Time taken by the round\n# TYPE stashcache_tester_run_duration_seconds gauge\n")
+	fmt.Fprintf(buf, "stashcache_tester_run_duration_seconds %g\n", run.End.Sub(run.Start).Seconds())
+	fmt.Fprintf(buf, "# HELP stashcache_tester_last_run_timestamp_seconds When the round finished\n# TYPE stashcache_tester_last_run_timestamp_seconds gauge\n")
+	fmt.Fprintf(buf, "stashcache_tester_last_run_timestamp_seconds %d\n", run.End.Unix())
+	return buf.Bytes()
+}
+
+// metricLabel quotes a label value for the Prometheus text format
+func metricLabel(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
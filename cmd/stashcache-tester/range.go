@@ -0,0 +1,275 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the range test type downloads each test file over HTTPS as a reference,
+// checks it, then reads parts of it with range requests and compares them
+// with the reference, catching caches that serve partial reads wrongly.
+// Fixtures are checked against their generated content instead.
+func init() {
+	registerTestType("range", runRangeTest)
+}
+
+// rangeLength is how much each range request asks for
+const rangeLength = 64 * 1024
+
+// RangeRead records one range request of a range test
+type RangeRead struct {
+	Offset  int64   `json:"offset"`
+	Length  int64   `json:"length"`
+	Status  int     `json:"status,omitempty"`
+	Latency float64 `json:"latency,omitempty"` // milliseconds
+	Error   string  `json:"error,omitempty"`
+}
+
+func runRangeTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	fail := func(i int, err error) {
+		result.files[i].Status = "Failure"
+		result.files[i].setError(err)
+		result.fail(classify(errorClass(err), fmt.Errorf("range test of %s failed: %s", ts.TestFiles[i].Path, err)))
+	}
+	var hashes map[string]string
+	if ts.HashFile != "" {
+		contents, _, err := httpGet(ctx, ts, httpBackend{"https"}.URL(ts, ts.HashFile), "", time.Duration(ts.Timeout))
+		if err != nil {
+			fmt.Printf("Can't download hash file %s: %s\n", ts.HashFile, err)
+			result.success = false
+			result.result = classify(ErrorClassHashFile, fmt.Errorf("can't download hash file %s: %s", ts.HashFile, err))
+			return result
+		}
+		if hashes, err = parseHashFile(ts, string(contents)); err != nil {
+			result.success = false
+			result.result = err
+			return result
+		}
+	}
+	for i, testFile := range ts.TestFiles {
+		uri := httpBackend{"https"}.URL(ts, testFile.Path)
+		filename := ts.localPath(testFile.Path)
+		result.files[i].URL = uri
+		// fixtures are their own reference
+		if testFile.Fixture == "" {
+			reference, err := (httpBackend{"https"}).Download(ctx, uri, filename, ts, testFile.timeout(ts))
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			if err := checkReference(ts, testFile, filename, reference, hashes[filepath.Base(filename)]); err != nil {
+				fmt.Printf("Reference copy of %s is bad: %s\n", uri, err)
+				fail(i, err)
+				continue
+			}
+		}
+		payload, err := readRanges(ctx, ts, testFile, uri, filename)
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+		if err != nil {
+			fail(i, err)
+		}
+	}
+	return result.aggregate()
+}
+
+// checkReference checks a reference copy against its size and sha256 in the
+// config and expectedHash, its entry in the hash file, using the hashes
+// worked out as it was downloaded if the backend could
+func checkReference(ts TestSet, testFile TestFile, filename string, reference ESPayload, expectedHash string) error {
+	if reference.digests == nil {
+		digests, err := fileDigests(ts, filename)
+		if err != nil {
+			return classify(ErrorClassSetup, fmt.Errorf("can't read %s: %s", filename, err))
+		}
+		reference.digests = digests
+	}
+	if err := verifyDigests(ts, testFile, reference); err != nil {
+		return err
+	}
+	if sum := reference.digests[ts.HashAlgorithm]; expectedHash != "" && sum != expectedHash {
+		return classify(ErrorClassChecksum, fmt.Errorf("%s of %s is %s, %s lists %s", ts.HashAlgorithm, filename, sum, ts.HashFile, expectedHash))
+	}
+	return nil
+}
+
+// rangeOffsets picks where to read length bytes from a file of size bytes:
+// the start, the middle, the end and a couple of unaligned offsets between.
+// An empty file has none.
+func rangeOffsets(size int64, length int64) []int64 {
+	var offsets []int64
+	seen := make(map[int64]bool)
+	for _, offset := range []int64{0, size/3 + 1, size / 2, 2*size/3 - 1, size - length} {
+		if offset < 0 {
+			offset = 0
+		}
+		if !seen[offset] && offset < size {
+			seen[offset] = true
+			offsets = append(offsets, offset)
+		}
+	}
+	return offsets
+}
+
+// readRanges reads parts of uri with range requests, compares them with the
+// reference copy in filename, or the test file's fixture, and reports the
+// payload
+func readRanges(ctx context.Context, ts TestSet, testFile TestFile, uri string, filename string) (ESPayload, error) {
+	payload := newFilePayload("stashcache-tester-range", ts, testFile.Path)
+	payload.Backend = "https"
+	payload.TestType = ts.Type
+	payload.Proxy = proxyFor(ts, uri)
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+
+	fail := func(err error) (ESPayload, error) {
+		end := time.Now()
+		payload.End1 = unixMillis(end)
+		payload.DownloadTime = end.Sub(start).Seconds() * 1000
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Range requests for %s failed: %s\n", uri, err)
+		ReportTest(ctx, payload, ts.Collector)
+		return payload, err
+	}
+
+	var reference io.ReaderAt
+	size := int64(testFile.Size)
+	if testFile.Fixture != "" {
+		reference = newFixture(testFile.Fixture, size)
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't open %s: %s", filename, err)))
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't stat %s: %s", filename, err)))
+		}
+		reference, size = f, info.Size()
+	}
+	payload.FileSize = size
+
+	offsets := rangeOffsets(size, rangeLength)
+	if len(offsets) == 0 {
+		return fail(classify(ErrorClassSetup, fmt.Errorf("%s is empty, there are no ranges to read", testFile.Path)))
+	}
+	var firstErr error
+	for _, offset := range offsets {
+		length := int64(rangeLength)
+		if offset+length > size {
+			length = size - offset
+		}
+		read := RangeRead{Offset: offset, Length: length}
+		err := readRange(ctx, ts, testFile, uri, reference, &read)
+		if err != nil {
+			read.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		debugf("Range %d-%d of %s: %d in %.1fms\n", offset, offset+length-1, uri, read.Status, read.Latency)
+		payload.Ranges = append(payload.Ranges, read)
+		payload.DownloadSize += length
+	}
+	if firstErr != nil {
+		return fail(firstErr)
+	}
+
+	end := time.Now()
+	payload.Status = "Success"
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+	ReportTest(ctx, payload, ts.Collector)
+	return payload, nil
+}
+
+// readRange makes one range request, filling in its status and latency, and
+// checks the bytes returned against the reference
+func readRange(ctx context.Context, ts TestSet, testFile TestFile, uri string, reference io.ReaderAt, read *RangeRead) error {
+	last := read.Offset + read.Length - 1
+	start := time.Now()
+	body, status, err := httpGet(ctx, ts, uri, fmt.Sprintf("bytes=%d-%d", read.Offset, last), testFile.timeout(ts))
+	read.Latency = time.Since(start).Seconds() * 1000
+	read.Status = status
+	if err != nil {
+		return err
+	}
+	expected := make([]byte, read.Length)
+	if _, err := reference.ReadAt(expected, read.Offset); err != nil && err != io.EOF {
+		return classify(ErrorClassSetup, fmt.Errorf("can't read reference copy: %s", err))
+	}
+	if !bytes.Equal(body, expected) {
+		return classify(ErrorClassChecksum, fmt.Errorf("bytes %d-%d don't match the file (got %d bytes)", read.Offset, last, len(body)))
+	}
+	return nil
+}
+
+// httpGet fetches uri, or the given range of it, returning the body and the
+// status code.  Anything but 200, or 206 for a range, is an error.
+func httpGet(ctx context.Context, ts TestSet, uri string, byteRange string, timeout time.Duration) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	req.Close = ts.address != ""
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, 0, classify(ErrorClassTimeout, fmt.Errorf("GET %s timed out", uri))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	expected := http.StatusOK
+	if byteRange != "" {
+		expected = http.StatusPartialContent
+	}
+	if resp.StatusCode != expected {
+		return nil, resp.StatusCode, fmt.Errorf("server returned %s for GET %s %s", resp.Status, uri, byteRange)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, resp.StatusCode, classify(ErrorClassTimeout, fmt.Errorf("GET %s timed out", uri))
+	}
+	return body, resp.StatusCode, err
+}
@@ -0,0 +1,210 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the readv test type downloads each test file as a reference with the test
+// set's backend, then reads scattered chunks of it in one vector read
+// (kXR_readv) and compares them with the reference, since some cache
+// versions mishandle vector reads even though whole-file copies work.
+// Fixtures are checked against their generated content instead.
+func init() {
+	registerTestType("readv", runReadVTest)
+}
+
+// readvChunk is the size of each chunk in the vector read
+const readvChunk = 16 * 1024
+
+func runReadVTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	fail := func(i int, err error) {
+		result.files[i].Status = "Failure"
+		result.files[i].setError(err)
+		result.fail(classify(errorClass(err), fmt.Errorf("vector read of %s failed: %s", ts.TestFiles[i].Path, err)))
+	}
+	backend, err := lookupBackend(ts)
+	if err != nil {
+		result.success = false
+		result.result = classify(ErrorClassSetup, err)
+		return result
+	}
+	var hashes map[string]string
+	if ts.HashFile != "" {
+		hashFile := ts.localPath(ts.HashFile)
+		_, err := backend.Download(ctx, backend.URL(ts, ts.HashFile), hashFile, ts, time.Duration(ts.Timeout))
+		var contents []byte
+		if err == nil {
+			contents, err = ioutil.ReadFile(hashFile)
+		}
+		if err != nil {
+			result.success = false
+			result.result = classify(ErrorClassHashFile, fmt.Errorf("can't download hash file %s: %s", ts.HashFile, err))
+			return result
+		}
+		if hashes, err = parseHashFile(ts, string(contents)); err != nil {
+			result.success = false
+			result.result = err
+			return result
+		}
+	}
+	for i, testFile := range ts.TestFiles {
+		filename := ts.localPath(testFile.Path)
+		result.files[i].URL = "root://" + ts.endpoint("root") + "/" + testFile.Path
+		// fixtures are their own reference
+		if testFile.Fixture == "" {
+			reference, err := backend.Download(ctx, backend.URL(ts, testFile.Path), filename, ts, testFile.timeout(ts))
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			if err := checkReference(ts, testFile, filename, reference, hashes[filepath.Base(filename)]); err != nil {
+				fmt.Printf("Reference copy of %s is bad: %s\n", testFile.Path, err)
+				fail(i, err)
+				continue
+			}
+		}
+		payload, err := vectorRead(ctx, ts, testFile, filename)
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+		if err != nil {
+			fail(i, err)
+		}
+	}
+	return result.aggregate()
+}
+
+// vectorRead reads scattered chunks of a test file in one kXR_readv request,
+// compares them with the reference copy in filename, or the test file's
+// fixture, and reports the payload
+func vectorRead(ctx context.Context, ts TestSet, testFile TestFile, filename string) (ESPayload, error) {
+	uri := "root://" + ts.endpoint("root") + "/" + testFile.Path
+	payload := newFilePayload("stashcache-tester-readv", ts, testFile.Path)
+	payload.Backend = "xrootd"
+	payload.TestType = ts.Type
+	timeout := testFile.timeout(ts)
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+
+	fail := func(err error) (ESPayload, error) {
+		end := time.Now()
+		payload.End1 = unixMillis(end)
+		payload.DownloadTime = end.Sub(start).Seconds() * 1000
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Vector read of %s failed: %s\n", uri, err)
+		ReportTest(ctx, payload, ts.Collector)
+		if time.Since(start) >= timeout {
+			err = classify(ErrorClassTimeout, fmt.Errorf("timed out after %s", timeout))
+		}
+		return payload, err
+	}
+
+	var reference io.ReaderAt
+	size := int64(testFile.Size)
+	if testFile.Fixture != "" {
+		reference = newFixture(testFile.Fixture, size)
+	} else {
+		f, err := os.Open(filename)
+		if err != nil {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't read reference copy: %s", err)))
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't read reference copy: %s", err)))
+		}
+		reference, size = f, info.Size()
+	}
+	payload.FileSize = size
+	var chunks []RangeRead
+	for _, offset := range rangeOffsets(size, readvChunk) {
+		length := int64(readvChunk)
+		if offset+length > size {
+			length = size - offset
+		}
+		chunks = append(chunks, RangeRead{Offset: offset, Length: length})
+	}
+	payload.Ranges = chunks
+	if len(chunks) == 0 {
+		return fail(classify(ErrorClassSetup, fmt.Errorf("%s is empty, there are no chunks to read", testFile.Path)))
+	}
+
+	addr := net.JoinHostPort(strings.Trim(ts.host(), "[]"), strconv.Itoa(ts.port("root")))
+	debugf("Sending a %d chunk vector read for %s\n", len(chunks), uri)
+	conn, handle, server, err := openRedirected(addr, testFile.Path, start.Add(timeout))
+	if server != addr {
+		payload.ServedBy = server
+	}
+	if err != nil {
+		return fail(err)
+	}
+	defer conn.Close()
+	read, err := conn.readv(handle, chunks)
+	end := time.Now()
+	conn.close(handle)
+	if err != nil {
+		return fail(err)
+	}
+
+	if len(read) != len(chunks) {
+		return fail(fmt.Errorf("asked for %d chunks, got %d", len(chunks), len(read)))
+	}
+	for j, chunk := range read {
+		payload.DownloadSize += int64(len(chunk.data))
+		if chunk.offset != chunks[j].Offset || int64(len(chunk.data)) != chunks[j].Length {
+			return fail(fmt.Errorf("chunk %d is %d bytes at %d, asked for %d at %d", j, len(chunk.data),
+				chunk.offset, chunks[j].Length, chunks[j].Offset))
+		}
+		expected := make([]byte, chunks[j].Length)
+		if _, err := reference.ReadAt(expected, chunk.offset); err != nil && err != io.EOF {
+			return fail(classify(ErrorClassSetup, fmt.Errorf("can't read reference copy: %s", err)))
+		}
+		if !bytes.Equal(chunk.data, expected) {
+			return fail(classify(ErrorClassChecksum, fmt.Errorf("bytes %d-%d don't match the file",
+				chunk.offset, chunk.offset+chunks[j].Length-1)))
+		}
+	}
+
+	payload.Status = "Success"
+	payload.XRDExit1 = "0"
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+	debugf("Vector read %d chunks of %s in %s\n", len(chunks), uri, end.Sub(start).Round(time.Millisecond))
+	ReportTest(ctx, payload, ts.Collector)
+	return payload, nil
+}
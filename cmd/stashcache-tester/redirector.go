@@ -0,0 +1,48 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// redirectorBackends can tell which data server a redirector sent them to
+var redirectorBackends = map[string]bool{"xrdcp": true, "http": true, "https": true}
+
+// xrdOpenedPattern finds the data server xrdcp logs at debug level once a
+// file is open, e.g. "successfully opened at data1.example.org:1094"
+var xrdOpenedPattern = regexp.MustCompile(`successfully opened at ([^\s,]+)`)
+
+// xrdRedirectPattern finds the redirects xrdcp logs at debug level, e.g.
+// "Got kXR_redirect response to message kXR_open (file: ...): host, port 1094"
+var xrdRedirectPattern = regexp.MustCompile(`Got kXR_redirect response to message .*?: ([^\s,?]+)[^\s,]*, port (\d+)`)
+
+// xrdServedBy returns the data server that served a transfer from xrdcp's
+// debug log, the server the file was opened at or else the last one it was
+// redirected to
+func xrdServedBy(log string) string {
+	if matches := xrdOpenedPattern.FindAllStringSubmatch(log, -1); len(matches) > 0 {
+		return matches[len(matches)-1][1]
+	}
+	if matches := xrdRedirectPattern.FindAllStringSubmatch(log, -1); len(matches) > 0 {
+		last := matches[len(matches)-1]
+		return net.JoinHostPort(strings.Trim(last[1], "[]"), last[2])
+	}
+	return ""
+}
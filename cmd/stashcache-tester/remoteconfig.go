@@ -0,0 +1,137 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteConfigMeta holds the validators used for conditional requests
+type remoteConfigMeta struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified"`
+}
+
+func isRemoteConfig(location string) bool {
+	return strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://")
+}
+
+// remoteConfigCacheDir is where fetched configs are kept so unchanged configs
+// aren't downloaded again and a copy is available if the server is down
+func remoteConfigCacheDir() string {
+	if dir := os.Getenv("STASHCACHE_TESTER_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "stashcache-tester")
+	}
+	return filepath.Join(os.TempDir(), "stashcache-tester")
+}
+
+// fetchRemoteConfig downloads a config, using the cached copy when the
+// server reports it unchanged or can't be reached
+func fetchRemoteConfig(configURL string) ([]byte, error) {
+	cacheDir := remoteConfigCacheDir()
+	sum := sha256.Sum256([]byte(configURL))
+	cacheBase := filepath.Join(cacheDir, hex.EncodeToString(sum[:8]))
+	cachedConfig := cacheBase + ".config"
+	cachedMeta := cacheBase + ".meta"
+
+	var meta remoteConfigMeta
+	cachedContents, cacheErr := ioutil.ReadFile(cachedConfig)
+	if cacheErr == nil {
+		if metaContents, err := ioutil.ReadFile(cachedMeta); err == nil {
+			json.Unmarshal(metaContents, &meta)
+		}
+	}
+
+	fallback := func(fetchErr error) ([]byte, error) {
+		if cacheErr != nil {
+			return nil, fmt.Errorf("can't fetch config from %s and no cached copy is available: %s", configURL, fetchErr)
+		}
+		fmt.Printf("Can't fetch config from %s, using cached copy %s: %s\n", configURL, cachedConfig, fetchErr)
+		return cachedContents, nil
+	}
+
+	req, err := http.NewRequest("GET", configURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config url %s: %s", configURL, err)
+	}
+	if cacheErr == nil && meta.URL == configURL {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fallback(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cacheErr != nil {
+			return fallback(fmt.Errorf("got 304 Not Modified without a cached copy"))
+		}
+		return cachedContents, nil
+	case http.StatusOK:
+	default:
+		return fallback(fmt.Errorf("got HTTP status %s", resp.Status))
+	}
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fallback(err)
+	}
+	meta = remoteConfigMeta{
+		URL:          configURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if err := saveRemoteConfig(cacheDir, cachedConfig, cachedMeta, contents, meta); err != nil {
+		fmt.Printf("Can't cache config from %s: %s\n", configURL, err)
+	}
+	return contents, nil
+}
+
+func saveRemoteConfig(cacheDir, configPath, metaPath string, contents []byte, meta remoteConfigMeta) error {
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return err
+	}
+	metaContents, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(configPath, contents, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, metaContents, 0644)
+}
@@ -0,0 +1,87 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Reporter is a named destination in the config's reporters.  Every payload
+// goes to each reporter that isn't disabled, which can be switched with
+// --set reporters[name].disabled=true.
+type Reporter struct {
+	Name     string `json:"name"`
+	URL      string `json:"url"`
+	Disabled bool   `json:"disabled"`
+}
+
+// reporterCollectors returns the urls of the reporters that aren't disabled
+func reporterCollectors(reporters []Reporter) CollectorList {
+	collectors := CollectorList{}
+	for _, reporter := range reporters {
+		if !reporter.Disabled {
+			collectors = append(collectors, reporter.URL)
+		}
+	}
+	return collectors
+}
+
+// checkCollector returns an error if a collector isn't a url the tester can
+// report to
+func checkCollector(collector string) error {
+	switch {
+	case isESCollector(collector):
+		_, err := parseESCollector(collector)
+		return err
+	case isFileCollector(collector):
+		_, err := fileCollectorPath(collector)
+		return err
+	}
+	if u, err := url.Parse(collector); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid collector url %q", collector)
+	}
+	return nil
+}
+
+// validateReporters checks that reporters have unique names and urls.  The
+// urls of enabled reporters are checked as the test sets' collectors, so
+// only disabled ones are checked here.
+func validateReporters(config *Config) []ValidationError {
+	var errs []ValidationError
+	seen := make(map[string]bool)
+	for i, reporter := range config.Reporters {
+		field := fmt.Sprintf("reporters[%d]", i)
+		addErr := func(format string, args ...interface{}) {
+			errs = append(errs, ValidationError{Index: -1, Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+		name := reporter.Name
+		if name == "" {
+			name = field
+			addErr("%s needs a name", field)
+		} else if seen[name] {
+			addErr("reporter %s is defined more than once", name)
+		}
+		seen[name] = true
+		if reporter.URL == "" {
+			addErr("reporter %s needs a url", name)
+		} else if err := checkCollector(reporter.URL); err != nil && reporter.Disabled {
+			addErr("reporter %s: %s", name, err)
+		}
+	}
+	return errs
+}
@@ -0,0 +1,209 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ESCollector is used when neither the config nor the command line give a
+// collector
+const ESCollector = "http://uct2-collectd.mwt2.org:9951"
+
+// CollectorEnvVar names the environment variable with a comma separated
+// list of collectors that replace the configured ones
+const CollectorEnvVar = "STASHCACHE_TESTER_COLLECTOR"
+
+// collectorOverride returns the collectors given on the command line or in
+// CollectorEnvVar, or nil if the configured collectors should be used
+func collectorOverride(flagValues []string) []string {
+	if len(flagValues) > 0 {
+		return flagValues
+	}
+	var collectors []string
+	for _, collector := range strings.Split(os.Getenv(CollectorEnvVar), ",") {
+		if collector = strings.TrimSpace(collector); collector != "" {
+			collectors = append(collectors, collector)
+		}
+	}
+	return collectors
+}
+
+func applyCollectorOverride(testSets []TestSet, override []string) {
+	if len(override) == 0 {
+		return
+	}
+	for i := range testSets {
+		testSets[i].Collector = override
+	}
+}
+
+// reportTimeout bounds each POST to a collector, so one that accepts the
+// connection and never answers can't hold up the tests
+const reportTimeout = 30 * time.Second
+
+var (
+	reportClientOnce sync.Once
+	reportClient     *http.Client
+)
+
+// reportHTTPClient returns the client payloads are sent with, it keeps
+// connections to the collectors open between reports.  It's shared by every
+// test set, so it uses the proxy environment variables rather than a test
+// set's proxy.
+func reportHTTPClient() *http.Client {
+	reportClientOnce.Do(func() {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.DialContext = (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.TLSHandshakeTimeout = 10 * time.Second
+		transport.ResponseHeaderTimeout = reportTimeout
+		transport.MaxIdleConnsPerHost = 4
+		transport.IdleConnTimeout = 90 * time.Second
+		reportClient = &http.Client{Transport: transport, Timeout: reportTimeout}
+	})
+	return reportClient
+}
+
+// unixMillis is t as the payloads' timestamps have it, in milliseconds since
+// the epoch as ES expects but only to the second
+func unixMillis(t time.Time) int64 {
+	return t.Unix() * 1000
+}
+
+// nowMillis is the current time as a payload timestamp
+func nowMillis() int64 {
+	return unixMillis(time.Now())
+}
+
+// reportFailures counts payloads that couldn't be sent, the report queue
+// counts a payload once for each collector that didn't take it
+var reportFailures int64
+
+func reportFailureCount() int64 {
+	return atomic.LoadInt64(&reportFailures)
+}
+
+// ReportTest sends payload to every collector, returning an error if any of
+// them couldn't be reached before ctx was done
+func ReportTest(ctx context.Context, payload ESPayload, collectors []string) error {
+	return reportPayload(ctx, payload, collectors)
+}
+
+// reportPayload sends any payload to the collectors, for test types with
+// their own payload.  With a report queue the payload is only queued and
+// failures are counted when it's sent.
+func reportPayload(ctx context.Context, payload interface{}, collectors []string) error {
+	if reports != nil {
+		reports.add(ctx, payload, collectors)
+		return nil
+	}
+	if err := postPayload(ctx, payload, collectors); err != nil {
+		atomic.AddInt64(&reportFailures, 1)
+		return err
+	}
+	return nil
+}
+
+// postPayload sends a payload, or a list of them, to every collector.  With
+// a spool directory what a collector couldn't take is spooled for later.
+func postPayload(ctx context.Context, payload interface{}, collectors []string) error {
+	var failed []string
+	for _, collector := range collectors {
+		name, err := postCollector(ctx, payload, collector)
+		if err == nil {
+			continue
+		}
+		if isESCollector(collector) {
+			fmt.Printf("Error reporting test results to Elasticsearch %s: %s\n", name, err)
+		} else if isFileCollector(collector) {
+			fmt.Printf("Error writing test results to %s: %s\n", name, err)
+		} else {
+			fmt.Printf("Error reporting test results to ES collector %s: %s\n", name, err)
+		}
+		failed = append(failed, name)
+		if retry := retryPayload(payload, err); spoolDir != "" && retry != nil {
+			if err := spoolReport(spoolDir, collector, retry); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("can't report test results to %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// postCollector sends a payload, or a list of them, to one collector,
+// returning the collector's name without credentials for messages
+func postCollector(ctx context.Context, payload interface{}, collector string) (string, error) {
+	if isESCollector(collector) {
+		return postES(ctx, payload, collector)
+	}
+	if isFileCollector(collector) {
+		return collector, appendPayloads(collector, payload)
+	}
+	buf := new(bytes.Buffer)
+	json.NewEncoder(buf).Encode(payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", collector, buf)
+	if err != nil {
+		return collector, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := reportHTTPClient().Do(req)
+	if err != nil {
+		return collector, err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	// read the rest so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		status := resp.Status
+		// the first line of the body usually explains the failure
+		message := strings.SplitN(strings.TrimSpace(string(body)), "\n", 2)[0]
+		if message = strings.TrimSpace(message); message != "" {
+			status += ": " + message
+		}
+		return collector, fmt.Errorf("%s", status)
+	}
+	return collector, nil
+}
+
+// postES writes a payload to an Elasticsearch collector, returning the
+// collector's name without credentials for messages
+func postES(ctx context.Context, payload interface{}, collector string) (string, error) {
+	c, err := lookupESCollector(collector)
+	if err != nil {
+		return esCollectorPrefix + "...", err
+	}
+	return c.String(), c.write(ctx, payload)
+}
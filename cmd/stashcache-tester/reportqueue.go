@@ -0,0 +1,211 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// reportQueueSize is how many payloads can wait to be sent to a collector
+// before reporting one waits for room
+const reportQueueSize = 1000
+
+// reports queues the run command's payloads, nil when payloads are sent
+// straight away
+var reports *reportQueue
+
+type queuedReport struct {
+	ctx     context.Context
+	payload interface{}
+	// flushed is closed once everything queued before it has been sent
+	flushed chan struct{}
+}
+
+// reportQueue sends payloads in the background so tests never wait on the
+// collectors.  Each collector has its own queue, so one that's slow or down
+// doesn't hold up the others.  Payloads that pile up while a batch is being
+// sent go together, up to batchSize in one POST as a json list or an
+// Elasticsearch bulk request.  With linger a batch waits that long for more
+// payloads before it's sent.  Batches are paced by a limiter for each
+// collector so bursts of results don't flood them.  When a collector's
+// queue is full its payloads are spooled if there's a spool directory,
+// otherwise reporting waits for room, holding up the next test rather than
+// dropping results.
+type reportQueue struct {
+	batchSize  int
+	linger     time.Duration
+	rate       float64
+	mu         sync.Mutex
+	collectors map[string]*collectorQueue
+}
+
+// collectorQueue holds the payloads waiting for one collector
+type collectorQueue struct {
+	collector string
+	queue     chan queuedReport
+	limiter   *rateLimiter
+}
+
+// startReportQueue starts sending queued payloads in batches of up to
+// batchSize, waiting up to linger for a batch to fill, and at most rate
+// batches a second to each collector if rate is above 0
+func startReportQueue(batchSize int, linger time.Duration, rate float64) *reportQueue {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &reportQueue{batchSize: batchSize, linger: linger, rate: rate, collectors: make(map[string]*collectorQueue)}
+}
+
+func (q *reportQueue) add(ctx context.Context, payload interface{}, collectors []string) {
+	for _, collector := range collectors {
+		cq := q.collectorQueue(collector)
+		r := queuedReport{ctx: ctx, payload: payload}
+		if spoolDir == "" {
+			cq.queue <- r
+			continue
+		}
+		select {
+		case cq.queue <- r:
+		default:
+			// the collector has fallen behind, keep the payload for later
+			// rather than hold up the tests
+			atomic.AddInt64(&reportFailures, 1)
+			if err := spoolReport(spoolDir, collector, payload); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// collectorQueue returns the queue for a collector, starting it the first
+// time the collector is reported to
+func (q *reportQueue) collectorQueue(collector string) *collectorQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cq, ok := q.collectors[collector]
+	if !ok {
+		cq = &collectorQueue{collector: collector, queue: make(chan queuedReport, reportQueueSize)}
+		if q.rate > 0 {
+			cq.limiter = &rateLimiter{rate: q.rate}
+		}
+		q.collectors[collector] = cq
+		go q.run(cq)
+	}
+	return cq
+}
+
+// flush waits until every payload queued so far has been sent or failed
+func (q *reportQueue) flush() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	var queues []*collectorQueue
+	for _, cq := range q.collectors {
+		queues = append(queues, cq)
+	}
+	q.mu.Unlock()
+	var waiting []chan struct{}
+	for _, cq := range queues {
+		flushed := make(chan struct{})
+		cq.queue <- queuedReport{flushed: flushed}
+		waiting = append(waiting, flushed)
+	}
+	for _, flushed := range waiting {
+		<-flushed
+	}
+}
+
+func (q *reportQueue) run(cq *collectorQueue) {
+	for first := range cq.queue {
+		batch := []queuedReport{first}
+		var timer *time.Timer
+		var lingered <-chan time.Time
+		if q.linger > 0 && q.batchSize > 1 {
+			timer = time.NewTimer(q.linger)
+			lingered = timer.C
+		}
+	fill:
+		for first.flushed == nil && len(batch) < q.batchSize {
+			// take whatever is waiting, then wait for more until the
+			// batch has lingered long enough
+			var next queuedReport
+			select {
+			case next = <-cq.queue:
+			default:
+				if lingered == nil {
+					break fill
+				}
+				select {
+				case next = <-cq.queue:
+				case <-lingered:
+					break fill
+				}
+			}
+			batch = append(batch, next)
+			if next.flushed != nil {
+				break fill
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		// a panic sending one batch mustn't stop reporting or leave a
+		// flush waiting forever
+		if catchPanic(func() { cq.send(batch) }) != nil {
+			atomic.AddInt64(&reportFailures, 1)
+		}
+	}
+}
+
+// send posts a batch to the queue's collector
+func (cq *collectorQueue) send(batch []queuedReport) {
+	defer func() {
+		for _, r := range batch {
+			if r.flushed != nil {
+				close(r.flushed)
+			}
+		}
+	}()
+	var ctx context.Context
+	var payloads []interface{}
+	for _, r := range batch {
+		if r.flushed == nil {
+			if ctx == nil {
+				ctx = r.ctx
+			}
+			payloads = append(payloads, r.payload)
+		}
+	}
+	if len(payloads) == 0 {
+		return
+	}
+	if cq.limiter != nil {
+		cq.limiter.wait(1)
+	}
+	var body interface{} = payloads
+	if len(payloads) == 1 {
+		body = payloads[0]
+	}
+	if postPayload(ctx, body, []string{cq.collector}) != nil {
+		atomic.AddInt64(&reportFailures, int64(len(payloads)))
+	}
+}
@@ -0,0 +1,164 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunResults is the machine readable summary of a run written by --results
+type RunResults struct {
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Duration float64        `json:"duration_seconds"`
+	ExitCode int            `json:"exit_code"`
+	Version  string         `json:"tester_version"`
+	Totals   map[string]int `json:"totals"`
+	Sites    []SiteResults  `json:"sites"`
+}
+
+type SiteResults struct {
+	SiteName string           `json:"sitename"`
+	Status   string           `json:"status"`
+	TestSets []TestSetResults `json:"testsets"`
+}
+
+type TestSetResults struct {
+	TestSetName string        `json:"testsetname"`
+	Cache       string        `json:"cache"`
+	Address     string        `json:"address,omitempty"`
+	Status      string        `json:"status"`
+	Duration    float64       `json:"duration_seconds"`
+	ErrorClass  string        `json:"error_class,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Files       []FileResults `json:"files"`
+	Tiers       []TierResults `json:"tiers,omitempty"`
+}
+
+type FileResults struct {
+	Path            string  `json:"path"`
+	URL             string  `json:"url,omitempty"`
+	Backend         string  `json:"backend,omitempty"`
+	Fallbacks       int     `json:"fallbacks,omitempty"`
+	Status          string  `json:"status"`
+	Bytes           int64   `json:"bytes"`
+	Duration        float64 `json:"duration_seconds"`
+	WarmDuration    float64 `json:"warm_duration_seconds,omitempty"`
+	ErrorClass      string  `json:"error_class,omitempty"`
+	FailureCategory string  `json:"failure_category,omitempty"`
+	Tier            string  `json:"tier,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// newRunResults converts a round's results for --results, keeping the order
+// sites were tested in
+func newRunResults(run *RunResult) RunResults {
+	results := RunResults{
+		Start:    run.Start.UTC(),
+		End:      run.End.UTC(),
+		Duration: run.End.Sub(run.Start).Seconds(),
+		ExitCode: run.exitCode(),
+		Version:  versionString(),
+		Totals:   make(map[string]int),
+		Sites:    []SiteResults{},
+	}
+	for _, site := range run.Sites {
+		siteResults := SiteResults{SiteName: site.SiteName, Status: "Success"}
+		if site.failed() {
+			siteResults.Status = "Failure"
+		}
+		for _, s := range site.TestSets {
+			results.Totals[s.Status]++
+			siteResults.TestSets = append(siteResults.TestSets, testSetResults(s))
+		}
+		results.Sites = append(results.Sites, siteResults)
+	}
+	return results
+}
+
+// testSetResults converts the outcome of a test set for --results
+func testSetResults(s TestSetSummary) TestSetResults {
+	ts := TestSetResults{
+		TestSetName: s.TestSetName,
+		Cache:       s.Cache,
+		Address:     s.Address,
+		Status:      s.Status,
+		Duration:    s.Duration.Seconds(),
+		ErrorClass:  s.ErrorClass,
+		Error:       s.Error,
+		Files:       []FileResults{},
+	}
+	for _, f := range s.Files {
+		ts.Files = append(ts.Files, FileResults{
+			Path:            f.Path,
+			URL:             f.URL,
+			Backend:         f.Backend,
+			Fallbacks:       f.Fallbacks,
+			Status:          f.Status,
+			Bytes:           f.Bytes,
+			Duration:        f.Duration.Seconds(),
+			WarmDuration:    f.WarmDuration.Seconds(),
+			ErrorClass:      f.ErrorClass,
+			FailureCategory: f.FailureCategory,
+			Tier:            f.Tier,
+			Error:           f.Error,
+		})
+	}
+	ts.Tiers = tierResults(s.Files)
+	return ts
+}
+
+// writeResults writes results, such as RunResults or a SoakReport, as json
+// to path, or to stdout if path is -.  Files are written to a temporary file
+// and renamed so readers never see a partial summary.
+func writeResults(path string, results interface{}) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't encode results: %s", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = resultsStdout.Write(data)
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".results-")
+	if err != nil {
+		return fmt.Errorf("can't write results to %s: %s", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can't write results to %s: %s", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't write results to %s: %s", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can't write results to %s: %s", path, err)
+	}
+	return nil
+}
+
+// resultsStdout is where --results - writes, the rest of the output is moved
+// to stderr so the json can be piped straight into another program
+var resultsStdout = os.Stdout
@@ -0,0 +1,385 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// keepFailed preserves the working directory of failed test sets instead of
+// removing it, set by --keep-failed
+var keepFailed bool
+
+// runOptions holds the run command's settings used each time the config is
+// loaded
+type runOptions struct {
+	configLocation  string
+	profiles        stringList
+	sitePatterns    stringList
+	testSetPatterns stringList
+	siteFilter      *nameFilter
+	testSetFilter   *nameFilter
+	collectors      []string
+	resultsPath     string
+	pushgateway     string
+	pushgatewayJob  string
+	statOnly        bool
+	parallel        int
+	// jitter and stagger spread the load of many testers over the interval
+	jitter  time.Duration
+	stagger time.Duration
+	rng     *rand.Rand
+	// soak collects every round's results when running a soak test
+	soak *soakTracker
+}
+
+// loadTestSets loads the config and selects the test sets to run
+func (o *runOptions) loadTestSets() ([]TestSet, error) {
+	testSets, err := selectProfiles(o.configLocation, o.profiles)
+	if err != nil {
+		return nil, err
+	}
+	testSets = filterTestSets(testSets, o.siteFilter, o.testSetFilter)
+	if len(testSets) == 0 && (!o.siteFilter.empty() || !o.testSetFilter.empty()) {
+		return nil, fmt.Errorf("no test sets in %s match --site %s --testset %s",
+			o.configLocation, o.sitePatterns.String(), o.testSetPatterns.String())
+	}
+	applyCollectorOverride(testSets, o.collectors)
+	if o.statOnly {
+		testSets = statOnlyTestSets(testSets)
+	}
+	return testSets, nil
+}
+
+// runCommand implements the run subcommand, testing every selected site once
+// or every --interval until killed
+func runCommand(args []string) int {
+	flags := newFlagSet("run", "[options] [config]")
+	configFlag := addConfigFlag(flags)
+	var opts runOptions
+	flags.Var(&opts.profiles, "profile", "only test the sites in this profile from the config (repeatable)")
+	flags.Var(&opts.sitePatterns, "site",
+		"only test sites matching this glob, or regular expression if prefixed with re: (repeatable)")
+	flags.Var(&opts.testSetPatterns, "testset",
+		"only run test sets with this name, or matching this regular expression if prefixed with re: (repeatable)")
+	var collectors stringList
+	flags.Var(&collectors, "collector",
+		"url of an ES collector to report to instead of the configured ones (repeatable, default: $"+CollectorEnvVar+")")
+	timeout := flags.Duration("timeout", time.Duration(builtinDefaults.Timeout),
+		"transfer timeout for test sets and files that don't set one in the config")
+	interval := flags.Duration("interval", 0,
+		"keep running, starting a new round of tests this often; SIGHUP reloads the config")
+	scratchDir := flags.String("scratch-dir", builtinDefaults.ScratchDir,
+		"directory to download files into for test sets that don't set scratchdir in the config")
+	flags.BoolVar(&keepFailed, "keep-failed", false,
+		"keep the downloaded files and xrdcp logs of failed test sets for debugging")
+	orderMode := flags.String("order", "config",
+		"order to test sites in: config (as listed in the config), alpha or random")
+	seed := flags.Int64("seed", 0, "seed for --order random, by default a new seed is picked and printed each run")
+	quiet := flags.Bool("quiet", false, "only print failures and the final summary")
+	verbose := flags.Bool("verbose", false, "also print per-file timings and xrdcp command lines")
+	flags.IntVar(&opts.parallel, "parallel", 1, "number of sites to test at once")
+	flags.DurationVar(&opts.jitter, "jitter", 0,
+		"wait a random time up to this long before each round, so testers started together don't all hit the caches at once")
+	flags.DurationVar(&opts.stagger, "stagger", 0, "start each site at least this long after the one before it")
+	var totalRate ByteSize
+	flags.Var(&totalRate, "total-rate-limit",
+		"limit the combined download speed of all transfers to this many bytes per second, such as 50M")
+	maxXrdcp := flags.Int("max-xrdcp", 0, "run at most this many xrdcp processes at once across all sites and files (default no limit)")
+	reportBatch := flags.Int("report-batch", 1, "send up to this many waiting payloads to a collector in one request")
+	spool := flags.String("spool-dir", os.Getenv(SpoolDirEnvVar), "keep payloads the collectors couldn't take in this directory and send them on later runs (default: $"+SpoolDirEnvVar+")")
+	flags.StringVar(&opts.pushgateway, "pushgateway", os.Getenv(PushgatewayEnvVar),
+		"push each round's metrics to this Prometheus Pushgateway (default: $"+PushgatewayEnvVar+")")
+	flags.StringVar(&opts.pushgatewayJob, "pushgateway-job", DefaultPushgatewayJob, "the job label of the metrics pushed to --pushgateway")
+	reportLinger := flags.Duration("report-linger", 0, "wait up to this long for a batch of --report-batch payloads to fill before sending it")
+	reportRate := flags.Float64("report-rate", 0, "send at most this many requests a second to the collectors (default no limit)")
+	flags.BoolVar(&opts.statOnly, "stat-only", false,
+		"only stat the files of download test sets instead of downloading them, e.g. with a short --interval")
+	flags.StringVar(&opts.resultsPath, "results", "",
+		"write a json summary of the run to this file, or to stdout if - (other output then goes to stderr)")
+	soak := flags.Duration("soak", 0,
+		"run the tests every --interval (default "+defaultSoakInterval.String()+") for this long, then summarise the failure rate and throughput drift")
+	soakReport := flags.String("soak-report", "", "write the soak test summary as json to this file, or to stdout if -")
+	pprofAddr := flags.String("pprof", "", "serve net/http/pprof on this address, such as localhost:6060")
+	cpuProfile := flags.String("cpu-profile", "", "write a CPU profile of the run to this file")
+	memProfile := flags.String("mem-profile", "", "write a heap profile to this file at the end of the run, SIGUSR1 writes one next to it at any time")
+	flags.Parse(args)
+	if *quiet && *verbose {
+		fmt.Fprintln(os.Stderr, "--quiet and --verbose can't be used together")
+		return ExitConfigError
+	}
+	if *quiet {
+		verbosity = quietOutput
+	} else if *verbose {
+		verbosity = verboseOutput
+	}
+	if opts.parallel < 1 {
+		fmt.Fprintln(os.Stderr, "--parallel must be at least 1")
+		return ExitConfigError
+	}
+	if opts.jitter < 0 || opts.stagger < 0 {
+		fmt.Fprintln(os.Stderr, "--jitter and --stagger can't be negative")
+		return ExitConfigError
+	}
+	if opts.pushgateway != "" {
+		if err := checkPushgateway(opts.pushgateway); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitConfigError
+		}
+	}
+	if *reportBatch < 1 || *reportLinger < 0 || *reportRate < 0 {
+		fmt.Fprintln(os.Stderr, "--report-batch must be at least 1 and --report-linger and --report-rate can't be negative")
+		return ExitConfigError
+	}
+	opts.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	if opts.resultsPath == "-" && *soakReport == "-" {
+		fmt.Fprintln(os.Stderr, "--results and --soak-report can't both be written to stdout")
+		return ExitConfigError
+	}
+	if opts.resultsPath == "-" || *soakReport == "-" {
+		os.Stdout = os.Stderr
+	}
+	setTotalRateLimit(totalRate)
+	xrdcpSlots = newSemaphore(*maxXrdcp)
+	spoolDir = *spool
+	reports = startReportQueue(*reportBatch, *reportLinger, *reportRate)
+	builtinDefaults.Timeout = Duration(*timeout)
+	builtinDefaults.ScratchDir = *scratchDir
+	opts.collectors = collectorOverride(collectors)
+
+	var err error
+	if opts.siteFilter, err = newNameFilter(opts.sitePatterns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	if opts.testSetFilter, err = newNameFilter(opts.testSetPatterns); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	order, err := newSiteOrder(*orderMode, *seed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	if order.mode == "random" {
+		infof("Testing sites in random order, use --seed %d to repeat it\n", order.seed)
+	}
+	if flags.NArg() > 0 {
+		*configFlag = flags.Arg(0)
+	}
+	if opts.configLocation, err = findConfig(*configFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	testSets, err := opts.loadTestSets()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	stopProfiling, err := startProfiling(*pprofAddr, *cpuProfile, *memProfile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	defer stopProfiling()
+
+	if *soak > 0 {
+		if *interval <= 0 {
+			*interval = defaultSoakInterval
+		}
+		// a soak test stops between rounds so the rounds so far are reported
+		return runSoak(context.Background(), &opts, testSets, order, *interval, *soak, *soakReport)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if *interval <= 0 {
+		return runRound(ctx, &opts, testSets, order)
+	}
+	serve(ctx, &opts, testSets, order, *interval, time.Time{})
+	return 0
+}
+
+// runSoak runs the tests every interval for duration, or until interrupted,
+// then prints and optionally writes a summary of the whole period
+func runSoak(ctx context.Context, opts *runOptions, testSets []TestSet, order *siteOrder, interval time.Duration, duration time.Duration, reportPath string) int {
+	opts.soak = newSoakTracker(interval)
+	infof("Soak testing until %s\n", opts.soak.start.Add(duration).Format(time.RFC3339))
+	serve(ctx, opts, testSets, order, interval, opts.soak.start.Add(duration))
+	report := opts.soak.report()
+	printSoakReport(os.Stdout, report)
+	exitCode := ExitSuccess
+	if report.Failures > 0 {
+		exitCode = ExitTestFailure
+	}
+	if reportPath != "" {
+		if err := writeResults(reportPath, report); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if exitCode == ExitSuccess {
+				exitCode = ExitReportFailure
+			}
+		}
+	}
+	return exitCode
+}
+
+// runRound runs one round of tests, writing --results and pushing metrics if
+// requested, and returns the exit code for it
+func runRound(ctx context.Context, opts *runOptions, testSets []TestSet, order *siteOrder) int {
+	if opts.jitter > 0 {
+		delay := time.Duration(opts.rng.Int63n(int64(opts.jitter)))
+		infof("Waiting %s before testing\n", delay.Round(time.Millisecond))
+		sleepContext(ctx, delay)
+	}
+	run := runTests(ctx, testSets, order, opts.parallel, opts.stagger)
+	if opts.soak != nil {
+		opts.soak.add(run)
+	}
+	exitCode := run.exitCode()
+	if opts.pushgateway != "" {
+		if err := pushMetrics(ctx, opts.pushgateway, opts.pushgatewayJob, run); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			if exitCode == ExitSuccess {
+				exitCode = ExitReportFailure
+			}
+		}
+	}
+	if opts.resultsPath == "" {
+		return exitCode
+	}
+	if err := writeResults(opts.resultsPath, newRunResults(run)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if exitCode == ExitSuccess {
+			exitCode = ExitReportFailure
+		}
+	}
+	return exitCode
+}
+
+// runTests runs one round of tests, testing up to parallel sites at once and
+// starting each at least stagger after the one before, then prints a summary
+// and returns the results
+func runTests(ctx context.Context, testSets []TestSet, order *siteOrder, parallel int, stagger time.Duration) *RunResult {
+	bySite := groupBySite(testSets)
+	sites := order.sites(testSets)
+	run := newRunResult(sites)
+	spoolRetried := retrySpool(ctx)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < len(sites); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				k := sites[i]
+				infof("Testing endpoint %s\n", k)
+				if err := catchPanic(func() { run.Sites[i].TestSets = TestEndpoint(ctx, bySite[k]) }); err != nil {
+					run.Sites[i].TestSets = internalErrorSummaries(ctx, bySite[k], err)
+				}
+				if run.Sites[i].failed() {
+					fmt.Printf("%s failed testing\n", k)
+				} else {
+					infof("%s passed testing\n", k)
+				}
+			}
+		}()
+	}
+	start := time.Now()
+	for i := range sites {
+		if stagger > 0 {
+			sleepContext(ctx, time.Until(start.Add(time.Duration(i)*stagger)))
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	spoolRetried()
+	// the round's report failures count towards its exit code
+	reports.flush()
+	run.finish()
+	run.print(os.Stdout)
+	return run
+}
+
+// serve runs the tests every interval, forever or until no round can start
+// before until.  A SIGHUP reloads the config before the next round, a round
+// that's already running finishes with the config it started with.  If the
+// reloaded config is invalid the old one is kept.  When there's an end,
+// SIGINT and SIGTERM stop it early after the current round.
+func serve(ctx context.Context, opts *runOptions, testSets []TestSet, order *siteOrder, interval time.Duration, until time.Time) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	stop := make(chan os.Signal, 1)
+	if !until.IsZero() {
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(stop)
+	}
+
+	for {
+		start := time.Now()
+		runRound(ctx, opts, testSets, order)
+		next := start.Add(interval)
+		if ctx.Err() != nil {
+			return
+		}
+		if !until.IsZero() && !next.Before(until) {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-hup:
+				testSets = reloadTestSets(opts, testSets)
+			case sig := <-stop:
+				timer.Stop()
+				fmt.Printf("Got %s, stopping\n", sig)
+				return
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+func reloadTestSets(opts *runOptions, current []TestSet) []TestSet {
+	if opts.configLocation == StdinConfig {
+		fmt.Println("Got SIGHUP but the config was read from stdin and can't be reloaded")
+		return current
+	}
+	testSets, err := opts.loadTestSets()
+	if err != nil {
+		fmt.Printf("Got SIGHUP but can't reload config, keeping the current one: %s\n", err)
+		return current
+	}
+	infof("Reloaded config from %s: %d test sets across %d sites\n",
+		opts.configLocation, len(testSets), len(groupBySite(testSets)))
+	return testSets
+}
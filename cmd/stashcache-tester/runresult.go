@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// RunResult collects the outcome of every site, test set and file of a
+// round as the tests finish.  The summary table, exit code, --results file
+// and soak statistics are all derived from it.
+type RunResult struct {
+	Start time.Time
+	End   time.Time
+	// Sites are in the order they were tested in, each is only written by
+	// the worker testing it
+	Sites []SiteResult
+	// ReportFailures counts the round's payloads that couldn't be sent to
+	// every collector
+	ReportFailures int64
+
+	reportFailuresBefore int64
+}
+
+// SiteResult is the outcome of every test set of a site
+type SiteResult struct {
+	SiteName string
+	TestSets []TestSetSummary
+}
+
+func newRunResult(sites []string) *RunResult {
+	r := &RunResult{Start: time.Now(), Sites: make([]SiteResult, len(sites)), reportFailuresBefore: reportFailureCount()}
+	for i, site := range sites {
+		r.Sites[i].SiteName = site
+	}
+	return r
+}
+
+// finish records the end of the round
+func (r *RunResult) finish() {
+	r.End = time.Now()
+	r.ReportFailures = reportFailureCount() - r.reportFailuresBefore
+}
+
+// failed is true if any of the site's test sets failed
+func (s SiteResult) failed() bool {
+	for _, ts := range s.TestSets {
+		if ts.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// testSets returns the outcome of every test set in site order
+func (r *RunResult) testSets() []TestSetSummary {
+	var summaries []TestSetSummary
+	for _, site := range r.Sites {
+		summaries = append(summaries, site.TestSets...)
+	}
+	return summaries
+}
+
+// exitCode picks the run command's exit code, test failures take precedence
+// over reporting failures
+func (r *RunResult) exitCode() int {
+	for _, site := range r.Sites {
+		if site.failed() {
+			return ExitTestFailure
+		}
+	}
+	if r.ReportFailures > 0 {
+		return ExitReportFailure
+	}
+	return ExitSuccess
+}
+
+// print writes a table of test set outcomes followed by totals
+func (r *RunResult) print(w io.Writer) {
+	printSummary(w, r.testSets())
+}
@@ -0,0 +1,158 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StatusChecksumDisagreement is reported for files whose checksum the
+// server reports doesn't match the one worked out from the download
+const StatusChecksumDisagreement = "ChecksumDisagreement"
+
+// digestNames maps the algorithm names servers use in xrdfs query checksum
+// output and RFC 3230 Digest headers to hashFunctions names
+var digestNames = map[string]string{
+	"adler32": "adler32",
+	"crc32c":  "crc32c",
+	"md5":     "md5",
+	"sha":     "sha1",
+	"sha1":    "sha1",
+	"sha-1":   "sha1",
+	"sha256":  "sha256",
+	"sha-256": "sha256",
+	"sha512":  "sha512",
+	"sha-512": "sha512",
+}
+
+// wantDigest asks for the checksums caches usually keep, cheapest first
+const wantDigest = "adler32, crc32c;q=0.9, md5;q=0.5, sha-256;q=0.5, sha-512;q=0.5"
+
+// compareServerChecksum asks the server uri was downloaded from for its
+// checksum of the file and compares it with the download's, recording it in
+// the payload.  A server that can't report a checksum isn't an error, one
+// that reports the wrong checksum is.
+func compareServerChecksum(ctx context.Context, ts TestSet, uri string, payload *ESPayload, timeout time.Duration) error {
+	algorithm, sum, err := serverChecksum(ctx, ts, uri, timeout)
+	if err != nil {
+		infof("Can't get the server's checksum of %s: %s\n", uri, err)
+		return nil
+	}
+	payload.ServerChecksum = algorithm + " " + sum
+	local, ok := payload.digests[algorithm]
+	if !ok {
+		infof("Can't compare the server's %s checksum of %s\n", algorithm, uri)
+		return nil
+	}
+	if local != sum {
+		return classify(ErrorClassDisagree, fmt.Errorf("server reports %s %s for %s but the download's is %s", algorithm, sum, uri, local))
+	}
+	debugf("Server's %s checksum of %s matches\n", algorithm, uri)
+	return nil
+}
+
+// serverChecksum returns the algorithm and hex encoded checksum the server
+// reports for uri, with xrdfs query checksum for root:// urls and a HEAD
+// request with Want-Digest for http ones
+func serverChecksum(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (string, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	switch u.Scheme {
+	case "root", "roots":
+		host := u.Host
+		if u.Scheme == "roots" {
+			host = "roots://" + u.Host
+		}
+		out, err := queryChecksum(ctx, ts, host, "/"+strings.TrimLeft(u.Path, "/"), timeout)
+		if err != nil {
+			return "", "", err
+		}
+		fields := strings.Fields(out)
+		if len(fields) < 2 {
+			return "", "", fmt.Errorf("unexpected xrdfs query checksum output %q", out)
+		}
+		return parseDigest(fields[0], fields[1])
+	case "http", "https":
+		return headDigest(ctx, ts, uri, timeout)
+	}
+	return "", "", fmt.Errorf("checksums of %s urls can't be queried", u.Scheme)
+}
+
+// headDigest asks an http server for the Digest of uri
+func headDigest(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", uri, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	req.Header.Set("Want-Digest", wantDigest)
+	req.Close = ts.address != ""
+	debugf("Running HEAD %s\n", uri)
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("server returned %s for HEAD %s", resp.Status, uri)
+	}
+	header := resp.Header.Get("Digest")
+	if header == "" {
+		return "", "", fmt.Errorf("no Digest header in the response")
+	}
+	for _, digest := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if algorithm, sum, err := parseDigest(parts[0], parts[1]); err == nil {
+			return algorithm, sum, nil
+		}
+	}
+	return "", "", fmt.Errorf("no supported algorithm in Digest %q", header)
+}
+
+// parseDigest returns the hashFunctions name and hex encoding of a checksum
+// a server reported.  Digest headers base64 encode most algorithms but not
+// adler32 or crc32c, so values that are already hex of the right length are
+// kept as they are.
+func parseDigest(name string, value string) (string, string, error) {
+	algorithm, ok := digestNames[strings.ToLower(name)]
+	newHash, known := hashFunctions[algorithm]
+	if !ok || !known {
+		return "", "", fmt.Errorf("unsupported checksum algorithm %q", name)
+	}
+	if isDigest(algorithm, value) {
+		return algorithm, strings.ToLower(value), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(decoded) != newHash().Size() {
+		return "", "", fmt.Errorf("can't decode %s checksum %q", name, value)
+	}
+	return algorithm, hex.EncodeToString(decoded), nil
+}
@@ -0,0 +1,182 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultSoakInterval is how often soak tests run when --interval isn't given
+const defaultSoakInterval = 10 * time.Minute
+
+// SoakReport is the summary written at the end of a soak test, for judging
+// whether a cache is ready for production
+type SoakReport struct {
+	Start       time.Time     `json:"start"`
+	End         time.Time     `json:"end"`
+	Duration    float64       `json:"duration_seconds"`
+	Interval    float64       `json:"interval_seconds"`
+	Rounds      int           `json:"rounds"`
+	Runs        int           `json:"runs"`
+	Failures    int           `json:"failures"`
+	FailureRate float64       `json:"failure_rate"`
+	TestSets    []SoakTestSet `json:"testsets"`
+	Version     string        `json:"tester_version"`
+}
+
+// SoakTestSet is how one test set did over a soak test.  Throughput is in
+// MB/s, drift compares the mean of the last third of the runs with the first
+// third as a percentage.
+type SoakTestSet struct {
+	SiteName        string     `json:"sitename"`
+	TestSetName     string     `json:"testsetname"`
+	Cache           string     `json:"cache"`
+	Address         string     `json:"address,omitempty"`
+	Runs            int        `json:"runs"`
+	Failures        int        `json:"failures"`
+	FailureRate     float64    `json:"failure_rate"`
+	Throughput      BenchStats `json:"throughput"`
+	FirstThroughput float64    `json:"first_throughput,omitempty"`
+	LastThroughput  float64    `json:"last_throughput,omitempty"`
+	Drift           float64    `json:"throughput_drift"`
+
+	// throughputs are the test set's successful runs in order
+	throughputs []float64
+}
+
+// soakTracker collects the outcome of every round of a soak test
+type soakTracker struct {
+	start    time.Time
+	interval time.Duration
+	rounds   int
+	testSets []*SoakTestSet
+	index    map[string]*SoakTestSet
+}
+
+func newSoakTracker(interval time.Duration) *soakTracker {
+	return &soakTracker{start: time.Now(), interval: interval, index: make(map[string]*SoakTestSet)}
+}
+
+// add records the outcome of a round's test sets
+func (t *soakTracker) add(run *RunResult) {
+	t.rounds++
+	for _, s := range run.testSets() {
+		key := s.SiteName + "/" + s.TestSetName + "/" + s.Address
+		testSet, ok := t.index[key]
+		if !ok {
+			testSet = &SoakTestSet{SiteName: s.SiteName, TestSetName: s.TestSetName, Cache: s.Cache, Address: s.Address}
+			t.index[key] = testSet
+			t.testSets = append(t.testSets, testSet)
+		}
+		if s.Status == StatusSkippedNoSpace || s.Status == StatusNotRun {
+			continue
+		}
+		testSet.Runs++
+		if s.failed() {
+			testSet.Failures++
+			continue
+		}
+		if mbps := summaryThroughput(s); mbps > 0 {
+			testSet.throughputs = append(testSet.throughputs, mbps)
+		}
+	}
+}
+
+// summaryThroughput returns a test set's download speed in MB/s over all
+// its successful files
+func summaryThroughput(s TestSetSummary) float64 {
+	bytes, duration := summaryTransfers(s)
+	if duration <= 0 {
+		return 0
+	}
+	return float64(bytes) / bytesPerMB / duration.Seconds()
+}
+
+// summaryTransfers returns the bytes and time taken by a test set's
+// successful files
+func summaryTransfers(s TestSetSummary) (bytes int64, duration time.Duration) {
+	for _, f := range s.Files {
+		if f.ErrorClass == "" && f.Status != StatusNotRun {
+			bytes += f.Bytes
+			duration += f.Duration
+		}
+	}
+	return bytes, duration
+}
+
+// report summarises the soak test so far
+func (t *soakTracker) report() SoakReport {
+	end := time.Now()
+	report := SoakReport{
+		Start:    t.start.UTC(),
+		End:      end.UTC(),
+		Duration: end.Sub(t.start).Seconds(),
+		Interval: t.interval.Seconds(),
+		Rounds:   t.rounds,
+		TestSets: []SoakTestSet{},
+		Version:  versionString(),
+	}
+	for _, testSet := range t.testSets {
+		s := *testSet
+		if s.Runs > 0 {
+			s.FailureRate = float64(s.Failures) / float64(s.Runs)
+		}
+		s.Throughput = benchStats(s.throughputs)
+		if third := len(s.throughputs) / 3; third > 0 {
+			s.FirstThroughput = mean(s.throughputs[:third])
+			s.LastThroughput = mean(s.throughputs[len(s.throughputs)-third:])
+			s.Drift = (s.LastThroughput - s.FirstThroughput) / s.FirstThroughput * 100
+		}
+		report.Runs += s.Runs
+		report.Failures += s.Failures
+		report.TestSets = append(report.TestSets, s)
+	}
+	if report.Runs > 0 {
+		report.FailureRate = float64(report.Failures) / float64(report.Runs)
+	}
+	return report
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// printSoakReport writes a table of each test set's failure rate and
+// throughput over the soak test
+func printSoakReport(w io.Writer, report SoakReport) {
+	fmt.Fprintf(w, "\nSoak test from %s to %s, %d rounds every %s\n", report.Start.Local().Format(time.RFC3339),
+		report.End.Local().Format(time.RFC3339), report.Rounds, time.Duration(report.Interval*float64(time.Second)))
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "SITE\tTEST SET\tCACHE\tRUNS\tFAILED\tFAILURE RATE\tMEDIAN MB/s\tDRIFT")
+	for _, s := range report.TestSets {
+		drift := "-"
+		if s.FirstThroughput > 0 {
+			drift = fmt.Sprintf("%+.1f%%", s.Drift)
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%d\t%d\t%.1f%%\t%.2f\t%s\n", s.SiteName, s.TestSetName, s.Cache,
+			s.Runs, s.Failures, s.FailureRate*100, s.Throughput.Median, drift)
+	}
+	table.Flush()
+	fmt.Fprintf(w, "%d test set runs: %d failed (%.1f%%)\n", report.Runs, report.Failures, report.FailureRate*100)
+}
@@ -0,0 +1,162 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SpaceInfo is how much space a cache has, in bytes
+type SpaceInfo struct {
+	Total int64
+	Free  int64
+	Used  int64
+}
+
+func (s SpaceInfo) String() string {
+	if s.Total <= 0 {
+		return fmt.Sprintf("%s free, %s used", ByteSize(s.Free), ByteSize(s.Used))
+	}
+	return fmt.Sprintf("%s free of %s (%.0f%% used)", ByteSize(s.Free), ByteSize(s.Total), 100*float64(s.Used)/float64(s.Total))
+}
+
+// recordSpace asks the test set's cache how much space it has left and
+// records it in the payload, with xrdfs spaceinfo for the xrootd backends
+// and a PROPFIND for the RFC 4331 quota properties for the HTTP ones.
+// Caches that won't say are only noted.
+func recordSpace(ctx context.Context, ts TestSet, payload *ESPayload) {
+	space, err := querySpace(ctx, ts, time.Duration(ts.Timeout))
+	if err != nil {
+		infof("Can't get the free space of %s: %s\n", ts.DNSName, err)
+		return
+	}
+	payload.DestinationSpace = space.String()
+	payload.SpaceTotal, payload.SpaceFree, payload.SpaceUsed = space.Total, space.Free, space.Used
+	debugf("%s has %s\n", ts.DNSName, space)
+}
+
+func querySpace(ctx context.Context, ts TestSet, timeout time.Duration) (SpaceInfo, error) {
+	dir := "/"
+	if len(ts.TestFiles) > 0 && !isTestURL(ts.TestFiles[0].Path) && !isStashURL(ts.TestFiles[0].Path) {
+		dir = path.Dir(ts.TestFiles[0].Path)
+	}
+	backend, err := lookupBackend(ts)
+	if err != nil {
+		return SpaceInfo{}, err
+	}
+	if uri := backend.URL(ts, dir); strings.HasPrefix(uri, "http") {
+		return propfindSpace(ctx, ts, uri, timeout)
+	}
+	out, err := xrdfs(ctx, ts, ts.endpoint("root"), timeout, "spaceinfo", dir)
+	if err != nil {
+		return SpaceInfo{}, err
+	}
+	return parseSpaceInfo(out)
+}
+
+// parseSpaceInfo reads the Total, Free and Used lines of xrdfs spaceinfo
+func parseSpaceInfo(output string) (SpaceInfo, error) {
+	var space SpaceInfo
+	found := 0
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "Total:":
+			space.Total = value
+		case "Free:":
+			space.Free = value
+		case "Used:":
+			space.Used = value
+		default:
+			continue
+		}
+		found++
+	}
+	if found == 0 {
+		return space, fmt.Errorf("no space information in xrdfs spaceinfo output")
+	}
+	return space, nil
+}
+
+// quotaPropfindBody asks for the RFC 4331 quota properties
+const quotaPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:quota-available-bytes/><D:quota-used-bytes/></D:prop></D:propfind>`
+
+// quotaStatus is the part of a quota PROPFIND response propfindSpace uses
+type quotaStatus struct {
+	Available string `xml:"response>propstat>prop>quota-available-bytes"`
+	Used      string `xml:"response>propstat>prop>quota-used-bytes"`
+}
+
+// propfindSpace gets the space left in the collection at uri from its quota
+// properties
+func propfindSpace(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (SpaceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("PROPFIND", uri, strings.NewReader(quotaPropfindBody))
+	if err != nil {
+		return SpaceInfo{}, err
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml")
+	req.Close = ts.address != ""
+	debugf("Running PROPFIND %s\n", uri)
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if ctx.Err() == context.DeadlineExceeded {
+		return SpaceInfo{}, classify(ErrorClassTimeout, fmt.Errorf("PROPFIND %s timed out", uri))
+	}
+	if err != nil {
+		return SpaceInfo{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return SpaceInfo{}, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return SpaceInfo{}, fmt.Errorf("server returned %s for PROPFIND %s", resp.Status, uri)
+	}
+	var status quotaStatus
+	if err := xml.NewDecoder(bytes.NewReader(body)).Decode(&status); err != nil {
+		return SpaceInfo{}, fmt.Errorf("can't parse PROPFIND response: %s", err)
+	}
+	if strings.TrimSpace(status.Available) == "" {
+		return SpaceInfo{}, fmt.Errorf("%s has no quota properties", uri)
+	}
+	var space SpaceInfo
+	space.Free, _ = strconv.ParseInt(strings.TrimSpace(status.Available), 10, 64)
+	space.Used, _ = strconv.ParseInt(strings.TrimSpace(status.Used), 10, 64)
+	space.Total = space.Free + space.Used
+	return space, nil
+}
@@ -0,0 +1,204 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SpoolDirEnvVar names the environment variable with the spool directory
+// used when --spool-dir isn't given
+const SpoolDirEnvVar = "STASHCACHE_TESTER_SPOOL_DIR"
+
+// spoolDir is where payloads a collector couldn't take are kept to be sent
+// again later, nothing is spooled if it's empty
+var spoolDir string
+
+// spooledReport is a spool file, the payloads one collector didn't take
+type spooledReport struct {
+	Collector string            `json:"collector"`
+	Spooled   int64             `json:"spooled"`
+	Payloads  []json.RawMessage `json:"payloads"`
+}
+
+// spoolReport writes a payload, or a list of them, to a new file in dir to
+// be sent to collector later.  The file is only readable by the user as
+// the collector may hold credentials.
+func spoolReport(dir string, collector string, payload interface{}) error {
+	report := spooledReport{Collector: collector, Spooled: nowMillis()}
+	list, ok := payload.([]interface{})
+	if !ok {
+		list = []interface{}{payload}
+	}
+	for _, p := range list {
+		encoded, err := json.Marshal(p)
+		if err != nil {
+			return fmt.Errorf("can't spool payload: %s", err)
+		}
+		report.Payloads = append(report.Payloads, encoded)
+	}
+	name, err := writeSpool(dir, report, "")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Spooled %d payloads to %s\n", len(report.Payloads), name)
+	return nil
+}
+
+// writeSpool writes report to the file name in dir, or to a new file after
+// the ones already there if name is empty, and returns its path
+func writeSpool(dir string, report spooledReport, name string) (string, error) {
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("can't spool payload: %s", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("can't create spool directory: %s", err)
+	}
+	// written under a temporary name so a flush never reads half a file
+	f, err := ioutil.TempFile(dir, ".spool-")
+	if err != nil {
+		return "", fmt.Errorf("can't spool payload: %s", err)
+	}
+	_, err = f.Write(encoded)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if name == "" {
+		name = filepath.Join(dir, fmt.Sprintf("%d-%s.json", time.Now().UnixNano(), strings.TrimPrefix(filepath.Base(f.Name()), ".spool-")))
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("can't spool payload: %s", err)
+	}
+	return name, nil
+}
+
+// retryPayload is the part of payload worth spooling after err, or nil when
+// Elasticsearch refused all of it for good
+func retryPayload(payload interface{}, err error) interface{} {
+	rejection, ok := err.(*esRejection)
+	if !ok {
+		return payload
+	}
+	if len(rejection.retry) == 0 {
+		return nil
+	}
+	return rejection.retry
+}
+
+// flushSpool sends the spooled payloads in dir, oldest first, and removes
+// the files that were sent.  Payloads Elasticsearch refused for good are
+// dropped and the ones it may take later are kept.  After a collector fails
+// once its other files are left for next time rather than waiting on it for
+// each of them.
+func flushSpool(ctx context.Context, dir string) (sent int, left int, err error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, 0, err
+	}
+	sort.Strings(names)
+	down := make(map[string]bool)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return sent, left + 1, ctx.Err()
+		}
+		contents, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Printf("Can't read spooled report %s: %s\n", name, err)
+			left++
+			continue
+		}
+		var report spooledReport
+		if err := json.Unmarshal(contents, &report); err != nil || report.Collector == "" || len(report.Payloads) == 0 {
+			fmt.Printf("Can't decode spooled report %s, leaving it\n", name)
+			left++
+			continue
+		}
+		if down[report.Collector] {
+			left++
+			continue
+		}
+		var payload interface{} = report.Payloads[0]
+		if len(report.Payloads) > 1 {
+			list := make([]interface{}, len(report.Payloads))
+			for i, p := range report.Payloads {
+				list[i] = p
+			}
+			payload = list
+		}
+		collector, err := postCollector(ctx, payload, report.Collector)
+		if rejection, ok := err.(*esRejection); ok {
+			// the collector is up, only keep what it may take later
+			fmt.Printf("Spooled reports in %s rejected by %s: %s\n", name, collector, err)
+			sent += rejection.total - rejection.rejected
+			if len(rejection.retry) == 0 {
+				os.Remove(name)
+				continue
+			}
+			report.Payloads = report.Payloads[:0]
+			for _, p := range rejection.retry {
+				report.Payloads = append(report.Payloads, p.(json.RawMessage))
+			}
+			if _, err := writeSpool(dir, report, name); err != nil {
+				fmt.Println(err)
+			}
+			left++
+			continue
+		}
+		if err != nil {
+			fmt.Printf("Can't send spooled reports to %s: %s\n", collector, err)
+			down[report.Collector] = true
+			left++
+			continue
+		}
+		os.Remove(name)
+		sent += len(report.Payloads)
+	}
+	return sent, left, nil
+}
+
+// retrySpool sends the spool left by earlier runs in the background while
+// the tests run, the returned function waits for it to finish
+func retrySpool(ctx context.Context) func() {
+	if spoolDir == "" {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var sent int
+		if err := catchPanic(func() { sent, _, _ = flushSpool(ctx, spoolDir) }); err != nil {
+			fmt.Println(err)
+		}
+		if sent > 0 {
+			infof("Sent %d spooled payloads\n", sent)
+		}
+	}()
+	return func() { <-done }
+}
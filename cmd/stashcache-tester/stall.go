@@ -0,0 +1,124 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StatusStalled is reported for transfers aborted because no bytes moved for
+// the test set's stalltimeout
+const StatusStalled = "Stalled"
+
+// stallWatcher cancels a transfer when its progress hasn't moved for the
+// test set's stalltimeout, so a hung transfer doesn't hold up every test
+// after it for the whole timeout
+type stallWatcher struct {
+	window   time.Duration
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	stalled  bool
+	bytes    int64
+}
+
+// watchStalls polls progress, the bytes transferred so far, and calls cancel
+// if it stays the same for stalltimeout.  Nothing is watched if the test set
+// has no stalltimeout.  The watcher must be stopped when the transfer ends.
+func watchStalls(ts TestSet, progress func() int64, cancel context.CancelFunc) *stallWatcher {
+	w := &stallWatcher{window: time.Duration(ts.StallTimeout), done: make(chan struct{})}
+	if w.window <= 0 {
+		return w
+	}
+	interval := w.window / 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last, moved := progress(), time.Now()
+		for {
+			select {
+			case <-w.done:
+				return
+			case now := <-ticker.C:
+				if n := progress(); n != last {
+					last, moved = n, now
+					continue
+				}
+				if now.Sub(moved) >= w.window {
+					w.mu.Lock()
+					w.stalled, w.bytes = true, last
+					w.mu.Unlock()
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return w
+}
+
+func (w *stallWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// check returns err unchanged unless the transfer was aborted as stalled, in
+// which case the payload is marked Stalled with the bytes transferred and a
+// stalled error is returned instead
+func (w *stallWatcher) check(err error, uri string, payload *ESPayload) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stalled {
+		return err
+	}
+	payload.Status = StatusStalled
+	payload.StalledAt = w.bytes
+	return classify(ErrorClassStalled, fmt.Errorf("%s stalled, no progress for %s after %d bytes", uri, w.window, w.bytes))
+}
+
+// fileProgress returns the progress of a download to name as its size
+func fileProgress(name string) func() int64 {
+	return func() int64 {
+		info, err := os.Stat(name)
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+}
+
+// progressCounter counts the bytes written through it, for backends that
+// copy downloads themselves
+type progressCounter struct {
+	n int64
+}
+
+func (c *progressCounter) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.n, int64(len(p)))
+	return len(p), nil
+}
+
+func (c *progressCounter) progress() int64 {
+	return atomic.LoadInt64(&c.n)
+}
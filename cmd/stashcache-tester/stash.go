@@ -0,0 +1,74 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultDirector resolves stash:// urls for test sets without a director
+const DefaultDirector = "https://osdf-director.osg-htc.org"
+
+// isStashURL reports whether a test path is a stash:///namespace/path url
+// to be resolved through the director
+func isStashURL(remotePath string) bool {
+	return strings.HasPrefix(remotePath, "stash://")
+}
+
+// usesDirector reports whether stash:// urls are resolved by the tester for
+// a test set, stashcp and pelican do it themselves
+func usesDirector(ts TestSet) bool {
+	return ts.Backend != "stashcp" && ts.Backend != "pelican"
+}
+
+// resolveStashURL asks the test set's director which cache serves a stash://
+// url, returning the cache's hostname and the path to fetch from it
+func resolveStashURL(ctx context.Context, ts TestSet, stashURL string) (string, string, error) {
+	u, err := url.Parse(stashURL)
+	if err != nil {
+		return "", "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ts.Timeout))
+	defer cancel()
+	req, err := http.NewRequest("GET", strings.TrimSuffix(ts.Director, "/")+u.Path, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	// the redirect is the answer, so don't follow it
+	client := *sharedHTTPClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	debugf("Resolving %s with %s\n", stashURL, ts.Director)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", "", fmt.Errorf("can't resolve %s: %s", stashURL, err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return "", "", fmt.Errorf("director %s didn't redirect %s to a cache: %s", ts.Director, stashURL, resp.Status)
+	}
+	debugf("Director sent %s to %s\n", stashURL, location)
+	return location.Hostname(), location.Path, nil
+}
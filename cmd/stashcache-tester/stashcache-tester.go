@@ -19,301 +19,709 @@ package main
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"time"
 )
 
 type TestSet struct {
-	DNSName     string   `json:"dnsname"`
-	SiteName    string   `json:"sitename"`
-	HashFile    string   `json:"hashfile"`
-	TestSetName string   `json:"testsetname"`
-	TestFiles   []string `json:"testfiles"`
+	DNSName     string     `json:"dnsname"`
+	SiteName    string     `json:"sitename"`
+	HashFile    string     `json:"hashfile"`
+	TestSetName string     `json:"testsetname"`
+	TestFiles   []TestFile `json:"testfiles"`
+	XRDEnv      XRDEnv     `json:"xrdenv"`
+
+	Timeout         Duration      `json:"timeout"`
+	HashAlgorithm   string        `json:"hashalgorithm"`
+	HashFormat      string        `json:"hashformat"`
+	Collector       CollectorList `json:"collector"`
+	ScratchDir      string        `json:"scratchdir"`
+	Backend         string        `json:"backend"`
+	Fallback        []string      `json:"fallback"`
+	Discard         bool          `json:"discard"`
+	Proxy           string        `json:"proxy"`
+	ServerChecksum  bool          `json:"serverchecksum"`
+	StatSize        bool          `json:"statsize"`
+	Warm            bool          `json:"warm"`
+	SpaceInfo       bool          `json:"spaceinfo"`
+	CompareOrigin   bool          `json:"compareorigin"`
+	ListDir         string        `json:"listdir"`
+	Discover        string        `json:"discover"`
+	Timings         bool          `json:"timings"`
+	MinThroughput   float64       `json:"minthroughput"`
+	Director        string        `json:"director"`
+	Redirector      bool          `json:"redirector"`
+	Streams         int           `json:"streams"`
+	ParallelFiles   int           `json:"parallelfiles"`
+	RateLimit       ByteSize      `json:"ratelimit"`
+	Attempts        int           `json:"attempts"`
+	Backoff         Duration      `json:"backoff"`
+	StallTimeout    Duration      `json:"stalltimeout"`
+	HungAfter       Duration      `json:"hungafter"`
+	MinBandwidth    float64       `json:"minbandwidth"`
+	TimeoutOverhead Duration      `json:"timeoutoverhead"`
+	Tiers           []SizeTier    `json:"tiers"`
+
+	Type            string   `json:"type"`
+	TPCDestination  string   `json:"tpcdestination"`
+	TPCDir          string   `json:"tpcdir"`
+	Origin          string   `json:"origin"`
+	UploadDir       string   `json:"uploaddir"`
+	UploadSize      ByteSize `json:"uploadsize"`
+	CertWarnDays    int      `json:"certwarndays"`
+	TokenFile       string   `json:"tokenfile"`
+	Clients         int      `json:"clients"`
+	MaxErrorLatency Duration `json:"maxerrorlatency"`
+	FreshnessTTL    Duration `json:"freshnessttl"`
+	Addresses       string   `json:"addresses"`
+	Sources         []string `json:"sources"`
+	XRootPort       Port     `json:"xrootport"`
+	HTTPPort        Port     `json:"httpport"`
+	HTTPSPort       Port     `json:"httpsport"`
+
+	// requestedPath is the stash:// or full url being downloaded, DNSName is
+	// then the cache the director picked or the url's host
+	requestedPath string
+	// address and ipVersion are set when testing a single address of
+	// DNSName, see expandAddresses
+	address   string
+	ipVersion int
+	// attempt is which attempt at a file this is and attemptErrors the
+	// errors of the ones before it, see downloadAttempts
+	attempt       int
+	attemptErrors []string
+	// fallbacks is how many fallback backends were tried before the one
+	// downloading, see downloadWithFallback
+	fallbacks int
+	// tierName is the size tier of the file being downloaded, when its
+	// configured size or tier picks one, see testDataFile
+	tierName string
+	// interimCollector is where interim payloads such as Hung go while the
+	// attempts at a file aren't reported themselves, see downloadAttempts
+	interimCollector CollectorList
+	// bearerToken is sent by the http backends, see the token test type
+	bearerToken string
+	// fixture is the test file being downloaded if it's a fixture, backends
+	// that see the data as it arrives compare it with the fixture then
+	fixture *TestFile
+	// workDir is the directory the test set's files are downloaded to
+	workDir string
+}
+
+// localPath returns where the file name (or the last element of a remote
+// path) is kept in the test set's working directory
+func (ts TestSet) localPath(name string) string {
+	return filepath.Join(ts.workDir, filepath.Base(name))
 }
 
 type TestResult struct {
-	success bool
-	result  error
+	success  bool
+	skipped  bool
+	degraded bool
+	result   error
+	files    []FileSummary
 }
 
 type ESPayload struct {
-	Cache            string  `json:"cache"`
-	DestinationSpace string  `json:"destination_space"`
-	DownloadSize     int64   `json:"download_size"`
-	DownloadTime     float64 `json:"download_time"`
-	End1             int64   `json:"end1"`
-	End2             int64   `json:"end2"`
-	End3             int64   `json:"end3"`
-	FileName         string  `json:"filename"`
-	FileSize         int64   `json:"filesize"`
-	Host             string  `json:"host"`
-	SiteName         string  `json:"sitename"`
-	Start1           int64   `json:"start1"`
-	Start2           int64   `json:"start2"`
-	Start3           int64   `json:"start3"`
-	Status           string  `json:"status"`
-	TimeStamp        int64   `json:"timestamp"`
-	Tries            int     `json:"tries"`
-	XRDcpVersion     string  `json:"xrdcp_version"`
-	XRDExit1         string  `json:"xrdexit1"`
-	XRDExit2         string  `json:"xrdexit2"`
+	Cache               string            `json:"cache"`
+	DestinationSpace    string            `json:"destination_space"`
+	SpaceTotal          int64             `json:"space_total,omitempty"`
+	SpaceFree           int64             `json:"space_free,omitempty"`
+	SpaceUsed           int64             `json:"space_used,omitempty"`
+	Error               string            `json:"error,omitempty"`
+	DownloadSize        int64             `json:"download_size"`
+	DownloadTime        float64           `json:"download_time"`
+	WarmDownloadTime    float64           `json:"warm_download_time,omitempty"`
+	CacheSpeedup        float64           `json:"cache_speedup,omitempty"`
+	OriginDownloadTime  float64           `json:"origin_download_time,omitempty"`
+	OriginRatio         float64           `json:"origin_ratio,omitempty"`
+	Throughput          float64           `json:"throughput,omitempty"`
+	DNSTime             float64           `json:"dns_time,omitempty"`
+	ConnectTime         float64           `json:"connect_time,omitempty"`
+	TLSTime             float64           `json:"tls_time,omitempty"`
+	OpenTime            float64           `json:"open_time,omitempty"`
+	TTFB                float64           `json:"ttfb,omitempty"`
+	ConnectionReused    bool              `json:"connection_reused,omitempty"`
+	End1                int64             `json:"end1"`
+	End2                int64             `json:"end2"`
+	End3                int64             `json:"end3"`
+	FileName            string            `json:"filename"`
+	FileSize            int64             `json:"filesize"`
+	ExpectedSize        int64             `json:"expected_size,omitempty"`
+	StalledAt           int64             `json:"stalled_at,omitempty"`
+	Interim             bool              `json:"interim,omitempty"`
+	Host                string            `json:"host"`
+	SiteName            string            `json:"sitename"`
+	Start1              int64             `json:"start1"`
+	Start2              int64             `json:"start2"`
+	Start3              int64             `json:"start3"`
+	Status              string            `json:"status"`
+	TimeStamp           int64             `json:"timestamp"`
+	Tries               int               `json:"tries"`
+	AttemptErrors       []string          `json:"attempt_errors,omitempty"`
+	XRDcpVersion        string            `json:"xrdcp_version"`
+	XRDExit1            string            `json:"xrdexit1"`
+	XRDExit2            string            `json:"xrdexit2"`
+	FailureCategory     string            `json:"failure_category,omitempty"`
+	Tier                string            `json:"tier,omitempty"`
+	Backend             string            `json:"backend,omitempty"`
+	ClientOutput        string            `json:"client_output,omitempty"`
+	ServedBy            string            `json:"served_by,omitempty"`
+	Redirector          string            `json:"redirector,omitempty"`
+	RequestedPath       string            `json:"requested_path,omitempty"`
+	Streams             int               `json:"streams,omitempty"`
+	RateLimit           int64             `json:"rate_limit,omitempty"`
+	TotalRateLimit      int64             `json:"total_rate_limit,omitempty"`
+	TestType            string            `json:"test_type,omitempty"`
+	Destination         string            `json:"destination,omitempty"`
+	Checksum            string            `json:"checksum,omitempty"`
+	ServerChecksum      string            `json:"server_checksum,omitempty"`
+	UploadStart         int64             `json:"upload_start,omitempty"`
+	UploadEnd           int64             `json:"upload_end,omitempty"`
+	UploadTime          float64           `json:"upload_time,omitempty"`
+	WebDAV              *WebDAVProbe      `json:"webdav,omitempty"`
+	HTTPResponse        *HTTPResponse     `json:"http_response,omitempty"`
+	Auth                string            `json:"auth,omitempty"`
+	Certificate         *CertificateCheck `json:"certificate,omitempty"`
+	IPAddress           string            `json:"ip_address,omitempty"`
+	IPVersion           int               `json:"ip_version,omitempty"`
+	Ranges              []RangeRead       `json:"ranges,omitempty"`
+	Sources             []string          `json:"sources,omitempty"`
+	ContributingSources []string          `json:"contributing_sources,omitempty"`
+	Fallbacks           int               `json:"fallbacks,omitempty"`
+	Discard             bool              `json:"discard,omitempty"`
+	Proxy               string            `json:"proxy,omitempty"`
+	Entries             int               `json:"entries,omitempty"`
+	MissingEntries      []string          `json:"missing_entries,omitempty"`
+	ErrorLatency        float64           `json:"error_latency,omitempty"`
+	Staleness           float64           `json:"staleness,omitempty"`
+
+	// digests are the hashes of the download by algorithm
+	digests map[string]string
+	// fixture compared the download with its fixture as it arrived
+	fixture         *fixtureWriter
+	TesterCommit    string `json:"tester_commit,omitempty"`
+	TesterBuildDate string `json:"tester_build_date,omitempty"`
 }
 
-const ESCollector = "http://uct2-collectd.mwt2.org:9951"
+// newPayload returns a payload with the tester's build metadata filled in
+func newPayload(prefix string) ESPayload {
+	return ESPayload{
+		XRDcpVersion:    clientVersion(prefix),
+		TesterCommit:    commit,
+		TesterBuildDate: buildDate,
+	}
+}
 
-func decodeJSON(configLocation string) (map[string][]TestSet, error) {
-	decodedConfig := make(map[string][]TestSet)
-	fileContents, err := ioutil.ReadFile(configLocation)
-	if err != nil {
-		log.Fatalf("Can't read config file %s, got error %s\n", configLocation, err)
+// newFilePayload returns a payload for downloading filename for a test set
+func newFilePayload(prefix string, ts TestSet, filename string) ESPayload {
+	payload := newPayload(prefix)
+	payload.Backend = ts.Backend
+	payload.SiteName = ts.SiteName
+	payload.FileName = filepath.Base(filename)
+	payload.Cache = ts.DNSName
+	payload.Host = ts.DNSName
+	payload.IPAddress = ts.address
+	payload.IPVersion = ts.ipVersion
+	payload.Discard = ts.Discard
+	payload.AttemptErrors = ts.attemptErrors
+	payload.Tier = ts.tierName
+	payload.Fallbacks = ts.fallbacks
+	if ts.requestedPath != "" {
+		payload.RequestedPath = ts.requestedPath
+		payload.ServedBy = ts.DNSName
 	}
-	var rawConfig []TestSet
-	err = json.Unmarshal(fileContents, &rawConfig)
-	if err != nil {
-		log.Fatal("Can't decode json from config file")
+	if ts.Redirector {
+		payload.Redirector = ts.DNSName
 	}
-	for _, val := range rawConfig {
-		if entry, ok := decodedConfig[val.SiteName]; ok {
-			decodedConfig[val.SiteName] = append(entry, val)
-		} else {
-			entry = []TestSet{val}
-			decodedConfig[val.SiteName] = entry
-		}
+	if ts.bearerToken != "" {
+		payload.Auth = AuthToken
+	}
+	return payload
+}
+
+// defaultXRDEnv holds the xrdcp settings used unless overridden by xrdenv in
+// the config
+var defaultXRDEnv = XRDEnv{
+	"XRD_REQUESTTIMEOUT":    "30",      // Wait 30s before timing out
+	"XRD_CPCHUNKSIZE":       "8388608", // read 8MB at a time
+	"XRD_TIMEOUTRESOLUTION": "5",       // Check for timeouts every 5s
+	"XRD_CONNECTIONWINDOW":  "30",      // Wait 30s for initial TCP connection
+	"XRD_CONNECTIONRETRY":   "2",       // Retry 2 times
+	"XRD_STREAMTIMEOUT":     "30",      // Wait 30s for TCP activity
+}
 
+// xrdcpEnv returns the XRD_* environment settings for a transfer, sorted so
+// the command line is stable
+func xrdcpEnv(overrides XRDEnv) []string {
+	var env []string
+	for key, value := range defaultXRDEnv.merge(overrides) {
+		env = append(env, key+"="+value)
 	}
-	return decodedConfig, nil
+	sort.Strings(env)
+	return env
 }
 
-func DownloadXRDFile(uri string, filename string, ts TestSet) (ESPayload, error) {
-	// Setup context to terminate commands after 600 seconds
+// xrdcpSlots caps how many xrdcp processes run at once across every site and
+// file, set by --max-xrdcp
+var xrdcpSlots semaphore
 
-	var payload ESPayload
+// runXrdcp waits for one of the xrdcpSlots, returning the function that
+// frees it once xrdcp has finished
+func runXrdcp(ctx context.Context) (func(), error) {
+	if err := xrdcpSlots.acquire(ctx); err != nil {
+		return nil, classify(ErrorClassCancelled, fmt.Errorf("gave up waiting to run xrdcp: %s", err))
+	}
+	return xrdcpSlots.release, nil
+}
+
+func DownloadXRDFile(parent context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	payload := newFilePayload("stashcache-tester", ts, filename)
 	var out bytes.Buffer
+	var clientLog bytes.Buffer
+
+	// waiting for a slot doesn't count towards the timeout
+	release, err := runXrdcp(parent)
+	if err != nil {
+		return payload, err
+	}
+	defer release()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Second)
+	// Setup context to terminate commands after timeout
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "xrdcp", uri, ".")
+	args := []string{uri, filename}
+	env := ts.XRDEnv
+	var sources []string
+	if len(ts.Sources) > 0 {
+		// read from every source at once through a metalink
+		if sources, err = sourceURLs(ts, uri); err != nil {
+			return payload, classify(ErrorClassSetup, err)
+		}
+		metalinkFile, err := writeMetalink(filename, sources)
+		if err != nil {
+			return payload, classify(ErrorClassSetup, err)
+		}
+		args = []string{"--sources", strconv.Itoa(len(sources)), metalinkFile, filename}
+		env = multiSourceEnv.merge(ts.XRDEnv)
+		payload.Sources = sources
+	}
+	if ts.Streams > 0 {
+		args = append([]string{"--streams", strconv.Itoa(ts.Streams)}, args...)
+		payload.Streams = ts.Streams
+	}
+	if limit := clientRateLimit(ts); limit > 0 {
+		args = append([]string{"--xrate", strconv.FormatInt(int64(limit), 10)}, args...)
+	}
+	recordRateLimits(ts, &payload)
+	if ts.Timings || ts.Redirector {
+		env = timingsEnv.merge(env)
+	}
+	cmd := exec.CommandContext(ctx, "xrdcp", args...)
 	//  populate payload info to report to ES
-	payload.XRDcpVersion = "stashcache-tester"
-	payload.SiteName = ts.SiteName
-	payload.FileName = filepath.Base(filename)
-	payload.Cache = ts.DNSName
-	payload.Host = ts.DNSName
 	start := time.Now()
-	payload.Start1 = start.Unix() * 1000 // need to multiple by 1000 for ES
-	payload.Tries = 1
+	payload.Start1 = unixMillis(start)
+	payload.Tries = ts.tries()
 	cmd.Stdout = &out
-	cmd.Env = append(os.Environ(),
-		"XRD_REQUESTTIMEOUT=30",   // Wait 30s before timing out
-		"XRD_CPCHUNKSIZE=8388608", // read 8MB at a time
-		"XRD_TIMEOUTRESOLUTION=5", // Check for timeouts every 5s
-		"XRD_CONNECTIONWINDOW=30", // Wait 30s for initial TCP connection
-		"XRD_CONNECTIONRETRY=2",   // Retry 2 times
-		"XRD_STREAMTIMEOUT=30")    // Wait 30s for TCP activity
-
-	if err := cmd.Run(); err != nil {
+	// keep xrdcp's output next to the download for --keep-failed
+	if logFile, err := os.Create(filename + ".xrdcp.log"); err == nil {
+		defer logFile.Close()
+		cmd.Stdout = io.MultiWriter(&out, logFile)
+		cmd.Stderr = logFile
+	}
+	// xrdcp's log is parsed for the sources, timings and failures
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&clientLog, cmd.Stderr)
+	} else {
+		cmd.Stderr = &clientLog
+	}
+	cmd.Env = append(os.Environ(), xrdcpEnv(env)...)
+	debugf("Running %s\n", commandLine(xrdcpEnv(env), "xrdcp", args...))
+
+	stalls := watchStalls(ts, fileProgress(filename), cancel)
+	hung := watchHung(parent, ts, uri, payload, fileProgress(filename))
+	err = cmd.Run()
+	stalls.stop()
+	hung.stop()
+	if len(sources) > 0 {
+		payload.ContributingSources = contributingSources(clientLog.String(), sources)
+	}
+	if ts.Timings {
+		timings := newTransferTimings(start)
+		parseXRDTimings(clientLog.String(), timings)
+		timings.record(&payload)
+	}
+	if ts.Redirector {
+		if server := xrdServedBy(clientLog.String()); server != "" {
+			payload.ServedBy = server
+		}
+	}
+	if cmd.ProcessState != nil {
+		payload.XRDExit1 = fmt.Sprint(cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
 		end := time.Now()
-		payload.End1 = end.Unix() * 1000 // need to multiple by 1000 for ES
+		payload.End1 = unixMillis(end)
 		payload.DownloadTime = end.Sub(start).Seconds() * 1000
 		payload.DownloadSize = 0
-		payload.TimeStamp = time.Now().Unix() * 1000 // need to multiple by 1000 for ES
+		payload.TimeStamp = nowMillis()
 		payload.Status = "Failure"
 
 		fmt.Printf("Can't download %s\nError: %s\n", uri, err)
-		ReportTest(payload)
-		return payload, fmt.Errorf("Can't download %s\nError: %s\n", uri, err)
+		class := ErrorClassTransfer
+		if ctx.Err() == context.DeadlineExceeded {
+			class = ErrorClassTimeout
+		} else if parent.Err() != nil {
+			class = ErrorClassCancelled
+		}
+		err = classify(class, fmt.Errorf("Can't download %s\nError: %s\n", uri, err))
+		err = stalls.check(err, uri, &payload)
+		payload.FailureCategory = failureCategory(err, clientLog.String())
+		payload.ClientOutput = clientOutputTail(clientLog.String())
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
 	} else {
 		payload.Status = "Success"
-		payload.XRDExit1 = "0"
-
 	}
 	end := time.Now()
-	payload.End1 = end.Unix() * 1000 // need to multiple by 1000 for ES
+	payload.End1 = unixMillis(end)
 	payload.DownloadTime = end.Sub(start).Seconds() * 1000
 
-	if fileInfo, err := os.Stat(payload.FileName); err != nil {
+	if fileInfo, err := os.Stat(filename); err != nil {
 		payload.DownloadSize = 0
-		payload.TimeStamp = time.Now().Unix() * 1000 // need to multiple by 1000 for ES
-		ReportTest(payload)
-		return payload, fmt.Errorf("Can't state file %s\nError: %s\n", payload.FileName, err)
+		payload.TimeStamp = nowMillis()
+		ReportTest(parent, payload, ts.Collector)
+		return payload, fmt.Errorf("Can't state file %s\nError: %s\n", filename, err)
 	} else {
 		payload.DownloadSize = fileInfo.Size()
 		payload.FileSize = fileInfo.Size()
-		payload.TimeStamp = time.Now().Unix() * 1000 // need to multiple by 1000 for ES
+		payload.TimeStamp = nowMillis()
 	}
+	debugf("Downloaded %s (%s) in %s\n", uri, ByteSize(payload.DownloadSize), end.Sub(start).Round(time.Millisecond))
 
 	return payload, nil
 }
 
-func TestDataSet(ts TestSet, resultChan chan TestResult) {
+// TestDataSet runs a test set in a new working directory and returns its
+// outcome
+func TestDataSet(ctx context.Context, ts TestSet) (result TestResult) {
+	if ts.Discover != "" {
+		var err error
+		if ts, err = discoverTestFiles(ctx, ts); err != nil {
+			fmt.Println(err)
+			result.result = err
+			return result
+		}
+	}
 
-	var result = TestResult{false, fmt.Errorf("")}
+	if err := checkScratchSpace(ts); err != nil {
+		infof("Skipping %s: %s\n", ts.TestSetName, err)
+		result.skipped = true
+		result.result = classify(ErrorClassNoSpace, err)
+		return result
+	}
 
-	workingDir, err := ioutil.TempDir(".", "")
+	workingDir, err := ioutil.TempDir(ts.ScratchDir, "stashcache-tester-")
 	if err != nil {
 		fmt.Printf("Couldn't create directory for %s\n", workingDir)
 		result.success = false
-		result.result = fmt.Errorf("couldn't create directory for %s", workingDir)
-		resultChan <- result
-		return
+		result.result = classify(ErrorClassSetup, fmt.Errorf("couldn't create directory for %s", workingDir))
+		return result
 	}
-	defer os.RemoveAll(workingDir)
+	defer func() {
+		if keepFailed && !result.success {
+			fmt.Printf("Keeping files from failed test set %s/%s in %s\n", ts.SiteName, ts.TestSetName, workingDir)
+			return
+		}
+		os.RemoveAll(workingDir)
+	}()
 
-	curDir, err := os.Getwd()
-	if err != nil {
-		fmt.Println("Couldn't get current directory")
-		result.success = false
-		result.result = fmt.Errorf("couldn't get current directory")
-		resultChan <- result
-		return
+	ts.workDir = workingDir
+
+	if ts.Type != DefaultTestType {
+		if err := checkTestType(ts); err != nil {
+			result.result = classify(ErrorClassSetup, err)
+		} else {
+			result = testTypes[ts.Type](ctx, ts)
+		}
+		return result
 	}
-	defer os.Chdir(curDir)
-	if err := os.Chdir(workingDir); err != nil {
-		fmt.Println("Can't change to working directory")
+
+	_, err = lookupBackend(ts)
+	if err != nil {
 		result.success = false
-		result.result = fmt.Errorf("can't change to working directory")
-		resultChan <- result
-		return
+		result.result = classify(ErrorClassSetup, err)
+		return result
 	}
 
-	for _, remoteFile := range ts.TestFiles {
-		// Setup context to terminate commands after 600 seconds
-
-		origURI := "root://" + ts.DNSName + "/" + remoteFile
-		payload, err := DownloadXRDFile(origURI, filepath.Base(remoteFile), ts)
-		if err != nil {
-			result.success = false
-			result.result = fmt.Errorf("can't download %s", origURI)
-			resultChan <- result
-			return
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	fileResults := make([]fileResult, len(ts.TestFiles))
+	forEachFile(len(ts.TestFiles), ts.ParallelFiles, func(i int) {
+		// files may be downloaded in their own goroutines, a panic has to be
+		// caught in the one it happened in
+		if err := catchPanic(func() { fileResults[i] = testDataFile(ctx, ts, ts.TestFiles[i], &result.files[i]) }); err != nil {
+			result.files[i].Status = StatusInternalError
+			result.files[i].setError(err)
+			fileResults[i] = fileResult{err: err}
+			payload := newFilePayload("stashcache-tester", ts, ts.TestFiles[i].Path)
+			payload.Status = StatusInternalError
+			payload.Error = err.Error()
+			payload.FailureCategory = failureCategory(err, "")
+			payload.TimeStamp = nowMillis()
+			ReportTest(ctx, payload, ts.Collector)
+		}
+	})
+	digests := make(map[string]string)
+	for i, r := range fileResults {
+		if r.digest != "" {
+			digests[filepath.Base(ts.TestFiles[i].Path)] = r.digest
 		}
-		ReportTest(payload)
+		if r.err != nil {
+			result.fail(r.err)
+		}
+		result.degraded = result.degraded || r.degraded
+	}
+	result.success = result.result == nil
+	// files without a hash file were checked against the sha256 in the
+	// config, there's nothing to check if none downloaded
+	if ts.HashFile == "" || len(digests) == 0 {
+		return result.aggregate()
 	}
-	hashURI := "root://" + ts.DNSName + "/" + ts.HashFile
-	_, err = DownloadXRDFile(hashURI, filepath.Base(ts.HashFile), ts)
+	// the hash file is always saved so it can be checked against
+	hashTS := ts
+	hashTS.Discard = false
+	_, _, _, err = downloadTestPath(ctx, hashTS, ts.HashFile, ts.localPath(ts.HashFile), time.Duration(ts.Timeout))
 	if err != nil {
 		fmt.Printf("Can't download file hash: %s\n", err)
-		result.success = false
-		result.result = fmt.Errorf("can't download file hash: %s", err)
-		resultChan <- result
-		return
+		result.fail(classify(ErrorClassHashFile, fmt.Errorf("can't download file hash: %s", err)))
+		return result.aggregate()
 	}
 
-	var out bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sha256sum", "-c", "hashes")
-	cmd.Stdout = &out
-	err = cmd.Run()
-	if err != nil {
+	if err := checkDigests(ts, result.files, digests); err != nil {
 		fmt.Printf("Can't verify file hashes: %s\n", err)
-		result.success = false
-		result.result = fmt.Errorf("can't verify file hashes: %s", err)
-		resultChan <- result
-		return
+		result.fail(err)
 	}
-	result.success = true
-	result.result = nil
-	resultChan <- result
+	return result.aggregate()
+}
+
+// fileResult is the outcome of testing one file of a download test set.
+// digest is its hash with the test set's algorithm for checking against the
+// hash file, set even if other checks failed.
+type fileResult struct {
+	digest   string
+	degraded bool
+	err      error
 }
 
-func TestEndpoint(testsets []TestSet, c chan bool) {
-	workDir, err := ioutil.TempDir("", "")
-	testsSucceeded := true
+// testDataFile downloads and checks one file of a download test set,
+// filling in its summary and reporting its payload
+func testDataFile(ctx context.Context, ts TestSet, testFile TestFile, file *FileSummary) fileResult {
+	// the tier is picked from the configured size so failure payloads are
+	// tagged too, a file without one is only put in a tier for the summary
+	// once its download size is known
+	tier := ts.tier(testFile, int64(testFile.Size))
+	if tier != nil {
+		ts.tierName = tier.Name
+	}
+	fileTS, remotePath := ts, testFile.Path
+	if isStashURL(testFile.Path) && usesDirector(ts) {
+		cache, cachePath, err := resolveStashURL(ctx, ts, testFile.Path)
+		if err != nil {
+			fmt.Printf("Can't resolve %s: %s\n", testFile.Path, err)
+			payload := newFilePayload("stashcache-tester", ts, testFile.Path)
+			payload.RequestedPath = testFile.Path
+			payload.Status = "Failure"
+			payload.Error = err.Error()
+			payload.TimeStamp = nowMillis()
+			ReportTest(ctx, payload, ts.Collector)
+			err = classify(ErrorClassDirector, err)
+			file.Status = "Failure"
+			file.setError(err)
+			return fileResult{err: err}
+		}
+		fileTS.DNSName, fileTS.requestedPath, remotePath = cache, testFile.Path, cachePath
+		// the ports and address configured are for dnsname, not the
+		// cache the director picked
+		fileTS.XRootPort, fileTS.HTTPPort, fileTS.HTTPSPort, fileTS.address = 0, 0, 0, ""
+	}
+	if ts.StatSize && testFile.Size == 0 {
+		if size, err := statSize(ctx, fileTS, remotePath, testFile.timeout(ts)); err != nil {
+			infof("Can't get the expected size of %s: %s\n", testFile.Path, err)
+		} else {
+			testFile.Size = ByteSize(size)
+			if tier = ts.tier(testFile, size); tier != nil {
+				fileTS.tierName = tier.Name
+			}
+		}
+	}
+	local := ts.localPath(testFile.Path)
+	var digest string
+	var degraded bool
+	downloadTS := fileTS
+	if testFile.Fixture != "" {
+		downloadTS.fixture = &testFile
+	}
+	origURI, payload, fallbacks, err := downloadAttempts(ctx, downloadTS, remotePath, local, testFile.timeout(ts))
+	payload.ExpectedSize = int64(testFile.Size)
+	if tier == nil {
+		tier = ts.tier(testFile, payload.DownloadSize)
+	}
+	if tier != nil {
+		file.Tier = tier.Name
+		if tier.MinThroughput > 0 {
+			fileTS.MinThroughput = tier.MinThroughput
+		}
+	}
+	file.URL = origURI
+	file.Backend = payload.Backend
+	file.Fallbacks = fallbacks
+	file.Status = payload.Status
+	file.Bytes = payload.DownloadSize
+	file.Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
 	if err != nil {
-		fmt.Println("Couldn't create test directory: ", err)
-		c <- false
-		return
+		ReportTest(ctx, payload, ts.Collector)
+		file.Status = "Failure"
+		file.setError(err)
+		file.FailureCategory = payload.FailureCategory
+		return fileResult{err: classify(errorClass(err), fmt.Errorf("can't download %s", origURI))}
+	}
+	if payload.digests == nil {
+		if payload.digests, err = fileDigests(ts, local); err != nil {
+			err = classify(ErrorClassSetup, fmt.Errorf("can't hash %s: %s", filepath.Base(testFile.Path), err))
+		}
+	}
+	if err == nil {
+		digest = payload.digests[ts.HashAlgorithm]
+		payload.Checksum = ts.HashAlgorithm + " " + payload.digests[ts.HashAlgorithm]
+		err = verifyDigests(ts, testFile, payload)
+	}
+	if err == nil && testFile.Fixture != "" {
+		err = checkFixture(testFile, local, payload)
+	}
+	if err == nil && ts.ServerChecksum {
+		err = compareServerChecksum(ctx, fileTS, origURI, &payload, testFile.timeout(ts))
+	}
+	if err == nil && ts.Warm {
+		err = warmDownload(ctx, fileTS, testFile, remotePath, local, &payload)
+		file.WarmDuration = time.Duration(payload.WarmDownloadTime * float64(time.Millisecond))
+	}
+	if err == nil && ts.CompareOrigin {
+		compareOrigin(ctx, fileTS, remotePath, local, testFile.timeout(ts), &payload)
 	}
-	defer os.RemoveAll(workDir)
-	curDir, err := os.Getwd()
 	if err != nil {
-		fmt.Println("Couldn't get current directory", workDir)
-		c <- false
-		return
-	}
-	if os.Chdir(workDir) != nil {
-		c <- false
-		return
-	}
-
-	testResultChan := make(chan TestResult)
-	for _, ts := range testsets {
-		var payload ESPayload
-		payload.SiteName = ts.SiteName
-		payload.FileName = ""
-		payload.Cache = ts.DNSName
-		payload.Host = ts.DNSName
+		fmt.Printf("Can't verify %s: %s\n", origURI, err)
+		payload.Status = failureStatus(err)
+		payload.Error = err.Error()
+		payload.FailureCategory = failureCategory(err, "")
+		ReportTest(ctx, payload, ts.Collector)
+		file.Status = payload.Status
+		file.setError(err)
+		file.FailureCategory = payload.FailureCategory
+		return fileResult{digest: digest, err: classify(errorClass(err), fmt.Errorf("can't verify %s: %s", origURI, err))}
+	}
+	payload.Throughput = throughput(payload)
+	if isDegraded(fileTS, payload) {
+		infof("%s downloaded at %.2f MB/s, below the minimum of %.2f MB/s\n", origURI, payload.Throughput, fileTS.MinThroughput)
+		payload.Status = StatusDegraded
+		file.Status = payload.Status
+		degraded = true
+	}
+	// a file a retry recovered is only reported as the success, the failed
+	// attempts are in attempt_errors rather than failure documents of their
+	// own
+	ReportTest(ctx, payload, ts.Collector)
+	return fileResult{digest: digest, degraded: degraded}
+}
+
+// testSetPayload returns the payload for the outcome of a test set as a
+// whole
+func testSetPayload(ts TestSet) ESPayload {
+	payload := newPayload("stashcache-tester-testresult")
+	payload.SiteName = ts.SiteName
+	payload.FileName = ""
+	payload.Cache = ts.DNSName
+	payload.Host = ts.DNSName
+	payload.IPAddress = ts.address
+	payload.IPVersion = ts.ipVersion
+	payload.Backend = ts.Backend
+	payload.Streams = ts.Streams
+	payload.TestType = ts.Type
+	return payload
+}
+
+// TestEndpoint runs the test sets of a site in turn, reporting a payload for
+// each, and returns their outcomes
+func TestEndpoint(ctx context.Context, siteTestSets []TestSet) []TestSetSummary {
+	var testsets []TestSet
+	for _, ts := range siteTestSets {
+		testsets = append(testsets, expandAddresses(ts)...)
+	}
+	summaries := make([]TestSetSummary, len(testsets))
+	for i, ts := range testsets {
+		summaries[i] = TestSetSummary{SiteName: ts.SiteName, TestSetName: ts.TestSetName, Cache: ts.DNSName,
+			Address: ts.address, Status: StatusNotRun}
+	}
+
+	for i, ts := range testsets {
+		payload := testSetPayload(ts)
 		start := time.Now()
-		payload.Start1 = start.Unix() * 1000 // need to multiple by 1000 for ES
+		payload.Start1 = unixMillis(start)
 		payload.Tries = 1
-		payload.XRDcpVersion = "stashcache-tester-testresult"
 
-		go TestDataSet(ts, testResultChan)
-		result := <-testResultChan
+		var result TestResult
+		if err := catchPanic(func() { result = TestDataSet(ctx, ts) }); err != nil {
+			result = TestResult{result: err}
+		}
+		if ts.SpaceInfo {
+			recordSpace(ctx, ts, &payload)
+		}
 
 		end := time.Now()
-		payload.End1 = end.Unix() * 1000 // need to multiple by 1000 for ES
+		payload.End1 = unixMillis(end)
 		payload.DownloadTime = end.Sub(start).Seconds() * 1000
-
-		testsSucceeded = testsSucceeded && result.success
+		summaries[i].Duration = end.Sub(start)
+		summaries[i].Files = result.files
+
+		if result.skipped {
+			payload.Status = StatusSkippedNoSpace
+			payload.Error = fmt.Sprintf("%s", result.result)
+			summaries[i].Status = payload.Status
+			summaries[i].setError(result.result)
+			ReportTest(ctx, payload, ts.Collector)
+			continue
+		}
 		if !result.success {
 			fmt.Printf("Failed to verify %s using endpoint %s\n", ts.TestSetName, ts.SiteName)
-			payload.Status = fmt.Sprintf("Failure")
-			payload.DestinationSpace = fmt.Sprintf("%s", result.result)
-			payload.XRDExit1 = "0"
-			ReportTest(payload)
-			return
+			payload.Status = "Failure"
+			if errorClass(result.result) == ErrorClassInternal {
+				payload.Status = StatusInternalError
+			}
+			payload.Error = fmt.Sprintf("%s", result.result)
+			payload.FailureCategory = firstFailureCategory(result.files)
+			summaries[i].Status = payload.Status
+			summaries[i].setError(result.result)
+			ReportTest(ctx, payload, ts.Collector)
+			continue
 		}
 		payload.Status = "Success"
-		payload.XRDExit1 = "0"
-		ReportTest(payload)
-	}
-
-	if os.Chdir(curDir) != nil {
-		c <- false
-		return
-	}
-	c <- testsSucceeded
-}
-
-func ReportTest(payload ESPayload) {
-	buf := new(bytes.Buffer)
-	json.NewEncoder(buf).Encode(payload)
-	_, err := http.Post(ESCollector, "application/json", buf)
-	if err != nil {
-		fmt.Printf("Error reporting test results to ES collector\n")
-	}
-
-}
-
-func main() {
-
-	c := make(chan bool)
-	var testSets map[string][]TestSet
-	var err error
-	if testSets, err = decodeJSON("siteconfig.json"); err != nil {
-		panic("Can't read config file")
-	}
-	for k, v := range testSets {
-		fmt.Printf("Testing endpoint %s\n", k)
-		go TestEndpoint(v, c)
-		success := <-c
-		if !success {
-			fmt.Printf("%s failed testing\n", k)
-		} else {
-			fmt.Printf("%s passed testing\n", k)
+		if result.degraded {
+			payload.Status = StatusDegraded
 		}
+		payload.XRDExit1 = "0"
+		summaries[i].Status = payload.Status
+		ReportTest(ctx, payload, ts.Collector)
 	}
 
+	return summaries
 }
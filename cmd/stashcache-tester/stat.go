@@ -0,0 +1,251 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// the stat test type only checks that each test file is there and the right
+// size with xrdfs stat or an HTTP HEAD, cheap enough to run every few
+// minutes for availability data between full download runs
+func init() {
+	registerTestType("stat", runStatTest)
+}
+
+// httpStatBackends stat test files with a HEAD request rather than xrdfs
+var httpStatBackends = map[string]bool{"http": true, "https": true, "curl": true, "davix": true}
+
+// StatPayload is the smaller payload reported by stat test sets
+type StatPayload struct {
+	TestType        string  `json:"test_type"`
+	Cache           string  `json:"cache"`
+	Host            string  `json:"host"`
+	SiteName        string  `json:"sitename"`
+	FileName        string  `json:"filename"`
+	URL             string  `json:"url"`
+	FileSize        int64   `json:"filesize"`
+	Status          string  `json:"status"`
+	StatTime        float64 `json:"stat_time"`
+	TimeStamp       int64   `json:"timestamp"`
+	Error           string  `json:"error,omitempty"`
+	IPAddress       string  `json:"ip_address,omitempty"`
+	XRDcpVersion    string  `json:"xrdcp_version"`
+	TesterCommit    string  `json:"tester_commit,omitempty"`
+	TesterBuildDate string  `json:"tester_build_date,omitempty"`
+}
+
+func runStatTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	for i, testFile := range ts.TestFiles {
+		payload, err := statTestFile(ctx, ts, testFile)
+		result.files[i].URL = payload.URL
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.FileSize
+		result.files[i].Duration = time.Duration(payload.StatTime * float64(time.Millisecond))
+		if err != nil {
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("stat of %s failed: %s", testFile.Path, err)))
+		}
+	}
+	return result.aggregate()
+}
+
+// cantStat returns why a test set's files can't be stat'ed, by a stat test
+// set or a download test set run with --stat-only, or "" if they can
+func cantStat(ts TestSet) string {
+	if usesDirector(ts) {
+		return ""
+	}
+	for _, testFile := range ts.TestFiles {
+		if isStashURL(testFile.Path) || isFederationURL(testFile.Path) {
+			scheme := testFile.Path[:strings.Index(testFile.Path, "://")]
+			return fmt.Sprintf("the %s backend finds the cache for %s:// urls itself, so %s can't be stat'ed", ts.Backend, scheme, testFile.Path)
+		}
+	}
+	return ""
+}
+
+// asStatTestSet returns a download test set as the stat test set --stat-only
+// runs, without the settings that only apply to downloads
+func asStatTestSet(ts TestSet) TestSet {
+	ts.Type = "stat"
+	ts.Fallback, ts.Addresses, ts.Sources, ts.Tiers = nil, "", nil, nil
+	ts.Discard, ts.ServerChecksum, ts.StatSize, ts.Warm, ts.CompareOrigin, ts.Redirector = false, false, false, false, false, false
+	ts.Attempts, ts.ParallelFiles, ts.MinThroughput = 0, 0, 0
+	testFiles := make([]TestFile, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		testFile.Tier = ""
+		testFiles[i] = testFile
+	}
+	ts.TestFiles = testFiles
+	return ts
+}
+
+// statOnlyTestSets turns the download test sets of testSets into stat test
+// sets for --stat-only, leaving out the ones that can't be stat'ed
+func statOnlyTestSets(testSets []TestSet) []TestSet {
+	var stats []TestSet
+	for _, ts := range testSets {
+		if ts.Type != DefaultTestType {
+			stats = append(stats, ts)
+		} else if msg := cantStat(ts); msg != "" {
+			fmt.Printf("Skipping %s/%s with --stat-only: %s\n", ts.SiteName, ts.TestSetName, msg)
+		} else {
+			stats = append(stats, asStatTestSet(ts))
+		}
+	}
+	return stats
+}
+
+// statTestFile stats a test file, checks it against the size given for it
+// and reports the payload
+func statTestFile(ctx context.Context, ts TestSet, testFile TestFile) (StatPayload, error) {
+	payload := StatPayload{
+		TestType:        "stat",
+		Cache:           ts.DNSName,
+		Host:            ts.DNSName,
+		SiteName:        ts.SiteName,
+		FileName:        path.Base(testFile.Path),
+		IPAddress:       ts.address,
+		XRDcpVersion:    clientVersion("stashcache-tester-stat"),
+		TesterCommit:    commit,
+		TesterBuildDate: buildDate,
+	}
+	fail := func(err error) (StatPayload, error) {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Can't stat %s: %s\n", testFile.Path, err)
+		reportPayload(ctx, payload, ts.Collector)
+		return payload, err
+	}
+
+	remotePath := testFile.Path
+	if isStashURL(remotePath) && usesDirector(ts) {
+		cache, cachePath, err := resolveStashURL(ctx, ts, remotePath)
+		if err != nil {
+			return fail(classify(ErrorClassDirector, err))
+		}
+		ts.DNSName, remotePath = cache, cachePath
+		ts.XRootPort, ts.HTTPPort, ts.HTTPSPort, ts.address = 0, 0, 0, ""
+		payload.Cache, payload.Host, payload.IPAddress = cache, cache, ""
+	}
+	payload.URL = statURL(ts, remotePath)
+
+	start := time.Now()
+	size, err := statRemote(ctx, ts, payload.URL, testFile.timeout(ts))
+	payload.StatTime = time.Since(start).Seconds() * 1000
+	if err != nil {
+		return fail(err)
+	}
+	payload.FileSize = size
+	if testFile.Size > 0 && size != int64(testFile.Size) {
+		return fail(sizeError(testFile.Path, size, int64(testFile.Size)))
+	}
+	payload.Status = "Success"
+	payload.TimeStamp = nowMillis()
+	debugf("Stat of %s found %s in %s\n", payload.URL, ByteSize(size), time.Since(start).Round(time.Millisecond))
+	reportPayload(ctx, payload, ts.Collector)
+	return payload, nil
+}
+
+// statURL returns the url to stat a test path at, full urls are used as they
+// are and paths on the cache use HTTPS for the HTTP backends and xroot
+// otherwise
+func statURL(ts TestSet, remotePath string) string {
+	if isTestURL(remotePath) {
+		if u, err := url.Parse(remotePath); err == nil {
+			u.Scheme = testURLSchemes[u.Scheme]
+			return u.String()
+		}
+		return remotePath
+	}
+	if httpStatBackends[ts.Backend] {
+		scheme := "https"
+		if ts.Backend == "http" {
+			scheme = "http"
+		}
+		return httpBackend{scheme}.URL(ts, remotePath)
+	}
+	return "root://" + ts.endpoint("root") + "/" + remotePath
+}
+
+// statRemote returns the size of the file at uri
+func statRemote(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (int64, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return 0, err
+	}
+	switch u.Scheme {
+	case "root", "roots":
+		host := u.Host
+		if u.Scheme == "roots" {
+			host = "roots://" + u.Host
+		}
+		out, err := xrdfs(ctx, ts, host, timeout, "stat", "/"+strings.TrimLeft(u.Path, "/"))
+		if err != nil {
+			return 0, classify(ErrorClassTransfer, err)
+		}
+		size, ok := parseStatSize(out)
+		if !ok {
+			return 0, classify(ErrorClassTransfer, fmt.Errorf("no size in xrdfs stat output %q", out))
+		}
+		return size, nil
+	case "http", "https":
+		return headSize(ctx, ts, uri, timeout)
+	}
+	return 0, classify(ErrorClassSetup, fmt.Errorf("%s urls can't be stat'd", u.Scheme))
+}
+
+// headSize returns the Content-Length of a HEAD request for uri
+func headSize(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", uri, nil)
+	if err != nil {
+		return 0, classify(ErrorClassSetup, err)
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	req.Close = ts.address != ""
+	debugf("Running HEAD %s\n", uri)
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, classify(ErrorClassTimeout, fmt.Errorf("HEAD %s timed out", uri))
+	}
+	if err != nil {
+		return 0, classify(ErrorClassTransfer, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, classify(ErrorClassTransfer, fmt.Errorf("server returned %s for HEAD %s", resp.Status, uri))
+	}
+	if resp.ContentLength < 0 {
+		return 0, classify(ErrorClassTransfer, fmt.Errorf("no Content-Length for HEAD %s", uri))
+	}
+	return resp.ContentLength, nil
+}
@@ -0,0 +1,180 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// the stream test type reads each test file with xrdfs cat straight into a
+// hash without writing it to disk, exercising the read path jobs doing
+// direct I/O use rather than whole-file copies
+func init() {
+	registerTestType("stream", runStreamTest)
+}
+
+func runStreamTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	newHash, ok := hashFunctions[ts.HashAlgorithm]
+	if !ok {
+		result.success = false
+		result.result = classify(ErrorClassSetup, fmt.Errorf("unsupported hash algorithm %q", ts.HashAlgorithm))
+		return result
+	}
+	var hashes map[string]string
+	if ts.HashFile != "" {
+		var err error
+		if hashes, err = streamHashFile(ctx, ts); err != nil {
+			fmt.Printf("Can't read hash file %s: %s\n", ts.HashFile, err)
+			result.success = false
+			result.result = classify(ErrorClassHashFile, fmt.Errorf("can't read hash file %s: %s", ts.HashFile, err))
+			return result
+		}
+	}
+	for i, testFile := range ts.TestFiles {
+		payload, err := streamTestFile(ctx, ts, testFile, newHash, hashes[path.Base(testFile.Path)])
+		result.files[i].URL = "root://" + ts.endpoint("root") + "/" + testFile.Path
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+		if err != nil {
+			result.files[i].Status = "Failure"
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("streaming read of %s failed: %s", testFile.Path, err)))
+		}
+	}
+	return result.aggregate()
+}
+
+// streamHashFile reads the test set's hash file with xrdfs cat, returning
+// the hashes it lists by file name
+func streamHashFile(ctx context.Context, ts TestSet) (map[string]string, error) {
+	out, err := xrdfs(ctx, ts, ts.endpoint("root"), time.Duration(ts.Timeout), "cat", ts.HashFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseHashFile(ts, out)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// streamTestFile reads a test file with xrdfs cat, checks it against the
+// size, sha256 and hash file entry given for it, hashing it with newHash for
+// the test set's algorithm, and reports the payload
+func streamTestFile(parent context.Context, ts TestSet, testFile TestFile, newHash func() hash.Hash, expectedHash string) (ESPayload, error) {
+	uri := "root://" + ts.endpoint("root") + "/" + testFile.Path
+	payload := newFilePayload("stashcache-tester-stream", ts, testFile.Path)
+	payload.Backend = "xrdfs"
+	payload.TestType = ts.Type
+	timeout := testFile.timeout(ts)
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+
+	fail := func(err error) (ESPayload, error) {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Can't stream %s: %s\n", uri, err)
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	var counter countingWriter
+	sha := sha256.New()
+	algorithmHash := newHash()
+	args := []string{ts.endpoint("root"), "cat", testFile.Path}
+	cmd := exec.CommandContext(ctx, "xrdfs", args...)
+	cmd.Stdout = io.MultiWriter(&counter, sha, algorithmHash)
+	var fixture *fixtureWriter
+	if testFile.Fixture != "" {
+		fixture = newFixtureWriter(testFile)
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, fixture)
+	}
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), xrdcpEnv(ts.XRDEnv)...)
+	debugf("Running %s\n", commandLine(xrdcpEnv(ts.XRDEnv), "xrdfs", args...))
+	err := cmd.Run()
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.DownloadSize = counter.n
+	payload.FileSize = counter.n
+	payload.ClientOutput = clientOutputTail(stderr.String())
+	if cmd.ProcessState != nil {
+		payload.XRDExit1 = fmt.Sprint(cmd.ProcessState.ExitCode())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fail(classify(ErrorClassTimeout, fmt.Errorf("timed out after %s", timeout)))
+	}
+	if err != nil {
+		return fail(fmt.Errorf("xrdfs cat failed: %s", err))
+	}
+
+	sum := hex.EncodeToString(sha.Sum(nil))
+	payload.Checksum = "sha256 " + sum
+	if testFile.Size > 0 && counter.n != int64(testFile.Size) {
+		return fail(sizeError(path.Base(testFile.Path), counter.n, int64(testFile.Size)))
+	}
+	if testFile.SHA256 != "" && sum != strings.ToLower(testFile.SHA256) {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("sha256 is %s, expected %s", sum, testFile.SHA256)))
+	}
+	algorithmSum := hex.EncodeToString(algorithmHash.Sum(nil))
+	if testFile.Checksum != "" && algorithmSum != strings.ToLower(testFile.Checksum) {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("%s is %s, expected %s", ts.HashAlgorithm, algorithmSum, testFile.Checksum)))
+	}
+	if expectedHash != "" && algorithmSum != expectedHash {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("%s is %s, %s lists %s", ts.HashAlgorithm, algorithmSum, ts.HashFile, expectedHash)))
+	}
+	if fixture != nil {
+		if err := fixture.check(path.Base(testFile.Path), testFile.Fixture); err != nil {
+			return fail(err)
+		}
+	}
+
+	payload.Status = "Success"
+	payload.TimeStamp = nowMillis()
+	debugf("Streamed %s (%d bytes) in %s\n", uri, counter.n, end.Sub(start).Round(time.Millisecond))
+	ReportTest(parent, payload, ts.Collector)
+	return payload, nil
+}
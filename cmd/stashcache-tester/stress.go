@@ -0,0 +1,191 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// the stress test type downloads each test file with clients simultaneous
+// transfers from one cache, to check it can sustain a site's job
+// concurrency before it's added to the production pool
+func init() {
+	registerTestType("stress", runStressTest)
+}
+
+// defaultStressClients is how many transfers stress test sets run at once
+// when clients isn't given
+const defaultStressClients = 10
+
+// StressPayload is the aggregate payload reported for each test file of a
+// stress test set.  Throughput is in MB/s, the aggregate is the bytes of
+// every successful stream over the time from the first starting to the last
+// finishing.
+type StressPayload struct {
+	TestType            string     `json:"test_type"`
+	Cache               string     `json:"cache"`
+	Host                string     `json:"host"`
+	SiteName            string     `json:"sitename"`
+	FileName            string     `json:"filename"`
+	URL                 string     `json:"url"`
+	Backend             string     `json:"backend"`
+	Clients             int        `json:"clients"`
+	Failures            int        `json:"failures"`
+	DownloadSize        int64      `json:"download_size"`
+	Duration            float64    `json:"duration"`
+	AggregateThroughput float64    `json:"aggregate_throughput"`
+	StreamThroughput    BenchStats `json:"stream_throughput"`
+	Status              string     `json:"status"`
+	TimeStamp           int64      `json:"timestamp"`
+	Error               string     `json:"error,omitempty"`
+	XRDcpVersion        string     `json:"xrdcp_version"`
+	TesterCommit        string     `json:"tester_commit,omitempty"`
+	TesterBuildDate     string     `json:"tester_build_date,omitempty"`
+}
+
+func runStressTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	for i, testFile := range ts.TestFiles {
+		payload, err := stressTestFile(ctx, ts, testFile)
+		result.files[i].URL = payload.URL
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.Duration * float64(time.Millisecond))
+		if err != nil {
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("stress test of %s failed: %s", testFile.Path, err)))
+		}
+	}
+	return result.aggregate()
+}
+
+// stressStream is the outcome of one of a stress test's transfers
+type stressStream struct {
+	uri     string
+	payload ESPayload
+	err     error
+}
+
+// stressTestFile downloads a test file with every client at once, checking
+// each copy against the size given for it, and reports the aggregate
+// payload.  The downloads are discarded as they arrive so the clients don't
+// need room for a copy each.
+func stressTestFile(ctx context.Context, ts TestSet, testFile TestFile) (StressPayload, error) {
+	clients := ts.Clients
+	if clients == 0 {
+		clients = defaultStressClients
+	}
+	payload := StressPayload{
+		TestType:        "stress",
+		Cache:           ts.DNSName,
+		Host:            ts.DNSName,
+		SiteName:        ts.SiteName,
+		FileName:        path.Base(testFile.Path),
+		Backend:         ts.Backend,
+		Clients:         clients,
+		XRDcpVersion:    clientVersion("stashcache-tester-stress"),
+		TesterCommit:    commit,
+		TesterBuildDate: buildDate,
+	}
+	fail := func(err error) (StressPayload, error) {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Stress test of %s failed: %s\n", testFile.Path, err)
+		reportPayload(ctx, payload, ts.Collector)
+		return payload, err
+	}
+
+	// every client has to hit the same cache, so the director is only
+	// asked once
+	remotePath := testFile.Path
+	if isStashURL(remotePath) && usesDirector(ts) {
+		cache, cachePath, err := resolveStashURL(ctx, ts, remotePath)
+		if err != nil {
+			return fail(classify(ErrorClassDirector, err))
+		}
+		ts.DNSName, ts.requestedPath, remotePath = cache, testFile.Path, cachePath
+		ts.XRootPort, ts.HTTPPort, ts.HTTPSPort, ts.address = 0, 0, 0, ""
+		payload.Cache, payload.Host = cache, cache
+	}
+	// only the aggregate is reported
+	streamTS := ts
+	streamTS.Collector = nil
+	streamTS.Discard = true
+	timeout := testFile.timeout(ts)
+
+	streams := make([]stressStream, clients)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for n := range streams {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			panicErr := catchPanic(func() {
+				uri, streamPayload, _, err := downloadTestPath(ctx, streamTS, remotePath, ts.localPath(testFile.Path), timeout)
+				if err == nil && testFile.Size > 0 && streamPayload.DownloadSize != int64(testFile.Size) {
+					err = sizeError(payload.FileName, streamPayload.DownloadSize, int64(testFile.Size))
+				}
+				streams[n] = stressStream{uri, streamPayload, err}
+			})
+			if panicErr != nil {
+				streams[n] = stressStream{err: panicErr}
+			}
+		}(n)
+	}
+	wg.Wait()
+	payload.Duration = time.Since(start).Seconds() * 1000
+
+	var throughputs []float64
+	var firstErr error
+	for n, stream := range streams {
+		payload.URL = stream.uri
+		if stream.err != nil {
+			payload.Failures++
+			if firstErr == nil {
+				firstErr = stream.err
+			}
+			continue
+		}
+		payload.DownloadSize += stream.payload.DownloadSize
+		throughputs = append(throughputs, throughput(stream.payload))
+		debugf("Stream %d: %s in %.0fms, %.2f MB/s\n", n+1, ByteSize(stream.payload.DownloadSize),
+			stream.payload.DownloadTime, throughput(stream.payload))
+	}
+	payload.StreamThroughput = benchStats(throughputs)
+	if payload.Duration > 0 {
+		payload.AggregateThroughput = float64(payload.DownloadSize) / bytesPerMB / (payload.Duration / 1000)
+	}
+	if firstErr != nil {
+		return fail(classify(errorClass(firstErr), fmt.Errorf("%d of %d streams failed, the first: %s", payload.Failures, clients, firstErr)))
+	}
+
+	payload.Status = "Success"
+	payload.TimeStamp = nowMillis()
+	debugf("Stress test of %s with %d clients: %.2f MB/s aggregate, %.2f MB/s median per stream\n",
+		payload.URL, clients, payload.AggregateThroughput, payload.StreamThroughput.Median)
+	reportPayload(ctx, payload, ts.Collector)
+	return payload, nil
+}
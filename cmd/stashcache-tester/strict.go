@@ -0,0 +1,268 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigErrors collects every structural problem found in a config file so
+// they can all be reported at once
+type ConfigErrors struct {
+	Name   string
+	Errors []string
+}
+
+func (e *ConfigErrors) Error() string {
+	return fmt.Sprintf("invalid config file %s:\n  %s", e.Name, strings.Join(e.Errors, "\n  "))
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkConfigStructure compares a generically decoded config against the
+// Config types, reporting unknown fields and values of the wrong type
+func checkConfigStructure(generic interface{}) []string {
+	var errs []string
+	if _, isList := generic.([]interface{}); isList {
+		checkStructure(generic, reflect.TypeOf([]TestSet{}), "", &errs)
+	} else {
+		checkStructure(generic, reflect.TypeOf(Config{}), "", &errs)
+	}
+	return errs
+}
+
+func checkStructure(value interface{}, t reflect.Type, path string, errs *[]string) {
+	where := path
+	if where == "" {
+		where = "top level"
+	}
+	addErr := func(format string, args ...interface{}) {
+		*errs = append(*errs, where+": "+fmt.Sprintf(format, args...))
+	}
+	if value == nil {
+		return
+	}
+
+	// objects given for struct types have their fields checked even if the
+	// type has its own decoding, such as test files that may be a path
+	if object, ok := value.(map[string]interface{}); ok && t.Kind() == reflect.Struct {
+		fields := jsonFields(t)
+		var keys []string
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field, ok := fields[key]
+			if !ok {
+				addErr("unknown field %q%s", key, suggestField(key, fields))
+				continue
+			}
+			checkStructure(object[key], field.Type, joinPath(path, key), errs)
+		}
+		return
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		raw, _ := json.Marshal(value)
+		if err := reflect.New(t).Interface().(json.Unmarshaler).UnmarshalJSON(raw); err != nil {
+			addErr("%s", err)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			addErr("expected an object, got %s", describeJSONValue(value))
+			return
+		}
+		for key, elem := range object {
+			checkStructure(elem, t.Elem(), joinPath(path, key), errs)
+		}
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			addErr("expected a list, got %s", describeJSONValue(value))
+			return
+		}
+		for i, elem := range list {
+			checkStructure(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			addErr("expected a string, got %s", describeJSONValue(value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			addErr("expected true or false, got %s", describeJSONValue(value))
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			addErr("expected a number, got %s", describeJSONValue(value))
+		}
+	}
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describeJSONValue(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	case string:
+		return fmt.Sprintf("the string %q", v)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// jsonFields maps the json names of a struct's fields to the fields
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// suggestField returns a hint naming the known field closest to a misspelt
+// one, or an empty string if nothing is close
+func suggestField(key string, fields map[string]reflect.StructField) string {
+	best := ""
+	bestDistance := 3
+	for name := range fields {
+		if d := editDistance(strings.ToLower(key), name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// configSchema returns a JSON Schema describing the config format, derived
+// from the same types used to decode it
+func configSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"title":   "stashcache-tester config",
+		"oneOf": []interface{}{
+			typeSchema(reflect.TypeOf(Config{})),
+			typeSchema(reflect.TypeOf([]TestSet{})),
+		},
+	}
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return map[string]interface{}{"type": []string{"number", "string"},
+			"description": "seconds or a duration such as \"10m\""}
+	case reflect.TypeOf(ByteSize(0)):
+		return map[string]interface{}{"type": []string{"number", "string"},
+			"description": "bytes or a size such as \"100M\""}
+	case reflect.TypeOf(Port(0)):
+		return map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 65535}
+	case reflect.TypeOf(CollectorList{}):
+		return map[string]interface{}{"oneOf": []interface{}{
+			map[string]interface{}{"type": "string", "format": "uri"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "format": "uri"}},
+		}}
+	case reflect.TypeOf(XRDEnv{}):
+		return map[string]interface{}{"type": "object",
+			"propertyNames":        map[string]interface{}{"pattern": "^XRD_"},
+			"additionalProperties": map[string]interface{}{"type": []string{"string", "number"}}}
+	case reflect.TypeOf(TestFile{}):
+		object := structSchema(t)
+		object["required"] = []string{"path"}
+		return map[string]interface{}{"oneOf": []interface{}{map[string]interface{}{"type": "string"}, object}}
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return structSchema(t)
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for name, field := range jsonFields(t) {
+		properties[name] = typeSchema(field.Type)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
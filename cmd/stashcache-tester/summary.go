@@ -0,0 +1,147 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes for the run command
+const (
+	ExitSuccess       = 0
+	ExitTestFailure   = 1 // at least one test set failed
+	ExitConfigError   = 2 // bad command line or config, nothing was tested
+	ExitReportFailure = 3 // every test passed but some results weren't reported
+)
+
+// StatusNotRun marks test sets and files that were never reached, such as
+// the files of a test set whose hash file couldn't be downloaded
+const StatusNotRun = "NotRun"
+
+// Error classes let scripts tell kinds of failures apart without parsing
+// error messages
+const (
+	ErrorClassTransfer    = "transfer"              // xrdcp failed
+	ErrorClassTimeout     = "timeout"               // xrdcp didn't finish in time
+	ErrorClassHashFile    = "hashfile"              // the hash file couldn't be downloaded
+	ErrorClassChecksum    = "checksum"              // downloaded files didn't match their hashes
+	ErrorClassDisagree    = "checksum-disagreement" // the server's checksum didn't match the download's
+	ErrorClassSize        = "size"                  // a downloaded file wasn't the expected size
+	ErrorClassTruncated   = "truncated"             // a downloaded file was shorter than expected
+	ErrorClassStalled     = "stalled"               // a transfer made no progress for stalltimeout
+	ErrorClassMissing     = "missing"               // a test file wasn't in its directory's listing
+	ErrorClassDirector    = "director"              // the director couldn't resolve a stash:// url
+	ErrorClassUpload      = "upload"                // a file couldn't be written to the origin
+	ErrorClassCertificate = "certificate"           // a cache's certificate isn't trusted or doesn't match its name
+	ErrorClassAuth        = "auth"                  // a protected file could be read without a token
+	ErrorClassNotFound    = "not-found"             // a missing file wasn't reported as missing, or took too long to be
+	ErrorClassStale       = "stale"                 // a cache kept serving an updated file's old version past freshnessttl
+	ErrorClassNoSpace     = "no-space"              // not enough scratch space to run
+	ErrorClassSetup       = "setup"                 // local problems such as creating directories
+	ErrorClassCancelled   = "cancelled"             // the run was interrupted before the test finished
+	ErrorClassInternal    = "internal"              // the tester panicked, a bug rather than a problem with the cache
+)
+
+// classifiedError attaches an error class to an error
+type classifiedError struct {
+	class string
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func classify(class string, err error) error {
+	return &classifiedError{class, err}
+}
+
+// errorClass returns the class of err, unclassified errors are treated as
+// transfer failures
+func errorClass(err error) string {
+	if ce, ok := err.(*classifiedError); ok {
+		return ce.class
+	}
+	return ErrorClassTransfer
+}
+
+// FileSummary is the outcome of downloading one test file
+type FileSummary struct {
+	Path            string
+	URL             string
+	Backend         string
+	Fallbacks       int
+	Status          string
+	Bytes           int64
+	Duration        time.Duration
+	WarmDuration    time.Duration
+	ErrorClass      string
+	FailureCategory string
+	Tier            string
+	Error           string
+}
+
+func (f *FileSummary) setError(err error) {
+	f.ErrorClass = errorClass(err)
+	f.Error = strings.TrimSpace(err.Error())
+}
+
+// TestSetSummary is the outcome of one test set, used for the summary table
+// and exit code
+type TestSetSummary struct {
+	SiteName    string
+	TestSetName string
+	Cache       string
+	Address     string
+	Status      string
+	Duration    time.Duration
+	ErrorClass  string
+	Error       string
+	Files       []FileSummary
+}
+
+func (s *TestSetSummary) setError(err error) {
+	s.ErrorClass = errorClass(err)
+	s.Error = strings.TrimSpace(err.Error())
+}
+
+func (s TestSetSummary) failed() bool {
+	return s.Status != "Success" && s.Status != StatusDegraded && s.Status != StatusSkippedNoSpace
+}
+
+// printSummary writes a table of test set outcomes followed by totals
+func printSummary(w io.Writer, summaries []TestSetSummary) {
+	counts := make(map[string]int)
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(table, "\nSITE\tTEST SET\tCACHE\tSTATUS\tDURATION\tERROR")
+	for _, s := range summaries {
+		counts[s.Status]++
+		cache := s.Cache
+		if s.Address != "" {
+			cache += " (" + s.Address + ")"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\t%s\n", s.SiteName, s.TestSetName, cache, s.Status,
+			s.Duration.Round(time.Millisecond), s.Error)
+	}
+	table.Flush()
+	fmt.Fprintf(w, "%d test sets: %d passed, %d degraded, %d failed, %d skipped, %d not run\n", len(summaries),
+		counts["Success"], counts[StatusDegraded], counts["Failure"]+counts[StatusInternalError], counts[StatusSkippedNoSpace], counts[StatusNotRun])
+}
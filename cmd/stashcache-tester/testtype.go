@@ -0,0 +1,50 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultTestType downloads the test files and checks them against the hash
+// file, other test types register a function to run instead
+const DefaultTestType = "download"
+
+// testTypes run a test set, stopping early if ctx is done and keeping any
+// files in ts.localPath, and return the result with a FileSummary for each
+// test file
+var testTypes = make(map[string]func(ctx context.Context, ts TestSet) TestResult)
+
+func registerTestType(name string, run func(ctx context.Context, ts TestSet) TestResult) {
+	testTypes[name] = run
+}
+
+// checkTestType returns an error if the test set's type isn't known
+func checkTestType(ts TestSet) error {
+	if _, ok := testTypes[ts.Type]; ok || ts.Type == DefaultTestType {
+		return nil
+	}
+	names := []string{DefaultTestType}
+	for name := range testTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown test type %q, available types are %s", ts.Type, strings.Join(names, ", "))
+}
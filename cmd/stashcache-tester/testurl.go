@@ -0,0 +1,96 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// testURLSchemes maps the schemes a test file can be given as a full url
+// with to the scheme it's downloaded with, dav and davs are plain http
+var testURLSchemes = map[string]string{
+	"root":  "root",
+	"roots": "roots",
+	"http":  "http",
+	"https": "https",
+	"dav":   "http",
+	"davs":  "https",
+}
+
+// schemeBackends lists the backends that can download urls with each
+// scheme, the first is used when the test set's backend can't
+var schemeBackends = map[string][]string{
+	"root":  {"xrdcp", "native"},
+	"roots": {"xrdcp"},
+	"http":  {"http", "https", "curl", "davix"},
+	"https": {"https", "http", "curl", "davix"},
+}
+
+// isTestURL reports whether a test path is a full url with its own host and
+// scheme rather than a path on dnsname
+func isTestURL(remotePath string) bool {
+	i := strings.Index(remotePath, "://")
+	if i < 0 {
+		return false
+	}
+	_, ok := testURLSchemes[remotePath[:i]]
+	return ok
+}
+
+// urlBackend returns the backend to download a url with the given
+// (download) scheme, the test set's own if it can
+func urlBackend(ts TestSet, scheme string) string {
+	for _, name := range schemeBackends[scheme] {
+		if name == ts.Backend {
+			return name
+		}
+	}
+	return schemeBackends[scheme][0]
+}
+
+// downloadTestPath downloads a test path, either a full url or a path on the
+// test set's cache, returning the uri and payload of the last attempt and how
+// many fallbacks were needed
+func downloadTestPath(ctx context.Context, ts TestSet, remotePath string, filename string, timeout time.Duration) (string, ESPayload, int, error) {
+	if !isTestURL(remotePath) {
+		return downloadWithFallback(ctx, ts, remotePath, filename, timeout)
+	}
+	u, err := url.Parse(remotePath)
+	if err != nil {
+		return remotePath, ESPayload{}, 0, classify(ErrorClassSetup, fmt.Errorf("can't parse %s: %s", remotePath, err))
+	}
+	u.Scheme = testURLSchemes[u.Scheme]
+	urlTS := ts
+	urlTS.Backend = urlBackend(ts, u.Scheme)
+	urlTS.DNSName, urlTS.requestedPath = u.Host, remotePath
+	// the ports, address and sources configured are for dnsname, the url
+	// names its own server
+	urlTS.XRootPort, urlTS.HTTPPort, urlTS.HTTPSPort, urlTS.address = 0, 0, 0, ""
+	urlTS.Sources = nil
+	backend, err := lookupBackend(urlTS)
+	if err != nil {
+		return remotePath, ESPayload{}, 0, classify(ErrorClassSetup, err)
+	}
+	uri := u.String()
+	debugf("Downloading %s with the %s backend\n", uri, urlTS.Backend)
+	payload, err := backend.Download(ctx, uri, filename, urlTS, timeout)
+	return uri, payload, 0, err
+}
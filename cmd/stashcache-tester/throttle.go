@@ -0,0 +1,121 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimitBackends can throttle transfers to the test set's ratelimit
+var rateLimitBackends = map[string]bool{"xrdcp": true, "native": true, "http": true, "https": true, "curl": true}
+
+// throttleChunk is the most read before waiting on the limits, so throttled
+// transfers arrive smoothly rather than in bursts
+const throttleChunk = 16 * 1024
+
+// totalRateLimit caps the combined speed of every transfer, set by
+// --total-rate-limit.  Transfers the tester reads itself share totalLimiter,
+// external clients can only be held to it one transfer at a time.
+var (
+	totalRateLimit ByteSize
+	totalLimiter   *rateLimiter
+)
+
+func setTotalRateLimit(limit ByteSize) {
+	totalRateLimit = limit
+	totalLimiter = nil
+	if limit > 0 {
+		totalLimiter = &rateLimiter{rate: float64(limit)}
+	}
+}
+
+// rateLimiter paces reads to rate bytes per second, or anything else to rate
+// a second, each is scheduled after the ones before it
+type rateLimiter struct {
+	rate float64
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more are allowed
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// throttledReader reads no faster than all of its limiters allow
+type throttledReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+// throttle returns r slowed to the test set's ratelimit and the run's
+// --total-rate-limit, for backends that read transfers themselves
+func throttle(r io.Reader, ts TestSet) io.Reader {
+	var limiters []*rateLimiter
+	if ts.RateLimit > 0 {
+		limiters = append(limiters, &rateLimiter{rate: float64(ts.RateLimit)})
+	}
+	if totalLimiter != nil {
+		limiters = append(limiters, totalLimiter)
+	}
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{r, limiters}
+}
+
+// clientRateLimit returns the limit to give an external client for one
+// transfer, the lower of ratelimit and --total-rate-limit, or 0 for none
+func clientRateLimit(ts TestSet) ByteSize {
+	limit := ts.RateLimit
+	if totalRateLimit > 0 && (limit == 0 || totalRateLimit < limit) {
+		limit = totalRateLimit
+	}
+	return limit
+}
+
+// recordRateLimits notes the limits a transfer ran under in its payload, so
+// its throughput isn't mistaken for what the cache can do.  Backends that
+// can't throttle never ran under them.
+func recordRateLimits(ts TestSet, payload *ESPayload) {
+	if !rateLimitBackends[ts.Backend] {
+		return
+	}
+	payload.RateLimit = int64(ts.RateLimit)
+	payload.TotalRateLimit = int64(totalRateLimit)
+}
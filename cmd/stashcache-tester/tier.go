@@ -0,0 +1,87 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// SizeTier groups a test set's files by size, e.g. a latency tier of small
+// files and a throughput tier of large ones, so their metrics are reported
+// apart.  Small-file latency and large-file throughput regressions have
+// different causes.
+type SizeTier struct {
+	Name          string   `json:"name"`
+	MaxSize       ByteSize `json:"maxsize"`
+	MinThroughput float64  `json:"minthroughput"`
+}
+
+// tier returns the tier of a file of the given size, the one the file names
+// or else the first whose maxsize holds it, where no maxsize holds any size.
+// It's nil if the test set has no tiers or the size isn't known.
+func (ts TestSet) tier(testFile TestFile, size int64) *SizeTier {
+	for i, tier := range ts.Tiers {
+		if testFile.Tier != "" {
+			if tier.Name == testFile.Tier {
+				return &ts.Tiers[i]
+			}
+			continue
+		}
+		if size > 0 && (tier.MaxSize == 0 || size <= int64(tier.MaxSize)) {
+			return &ts.Tiers[i]
+		}
+	}
+	return nil
+}
+
+// TierResults sums up the files of one tier of a test set
+type TierResults struct {
+	Name       string  `json:"name"`
+	Files      int     `json:"files"`
+	Failed     int     `json:"failed"`
+	Bytes      int64   `json:"bytes"`
+	Duration   float64 `json:"duration_seconds"`
+	Throughput float64 `json:"throughput,omitempty"`
+}
+
+// tierResults sums up a test set's files by tier, in the order the tiers are
+// first seen.  Throughput is in MB/s over the files that were downloaded.
+func tierResults(files []FileSummary) []TierResults {
+	var tiers []TierResults
+	index := make(map[string]int)
+	for _, f := range files {
+		if f.Tier == "" {
+			continue
+		}
+		i, ok := index[f.Tier]
+		if !ok {
+			i = len(tiers)
+			index[f.Tier] = i
+			tiers = append(tiers, TierResults{Name: f.Tier})
+		}
+		tier := &tiers[i]
+		tier.Files++
+		if f.Status != "Success" && f.Status != StatusDegraded {
+			tier.Failed++
+			continue
+		}
+		tier.Bytes += f.Bytes
+		tier.Duration += f.Duration.Seconds()
+	}
+	for i := range tiers {
+		if tiers[i].Duration > 0 {
+			tiers[i].Throughput = float64(tiers[i].Bytes) / bytesPerMB / tiers[i].Duration
+		}
+	}
+	return tiers
+}
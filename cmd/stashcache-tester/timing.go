@@ -0,0 +1,142 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// transferTimings records when each phase of a transfer finished, so slow
+// handshakes can be told apart from slow disks.  Phases that didn't happen,
+// such as DNS and connecting on a reused connection, are left zero.
+type transferTimings struct {
+	mu          sync.Mutex
+	start       time.Time
+	dnsDone     time.Time
+	connectDone time.Time
+	tlsDone     time.Time
+	opened      time.Time
+	firstByte   time.Time
+	reused      bool
+}
+
+func newTransferTimings(start time.Time) *transferTimings {
+	return &transferTimings{start: start}
+}
+
+// set records a phase finishing now if it hasn't already
+func (t *transferTimings) set(phase *time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if phase.IsZero() {
+		*phase = time.Now()
+	}
+}
+
+// trace returns ctx with an httptrace that records the timings of a
+// request
+func (t *transferTimings) trace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSDone:     func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone) },
+		ConnectDone: func(string, string, error) { t.set(&t.connectDone) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.set(&t.tlsDone)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { t.set(&t.firstByte) },
+	})
+}
+
+// firstByteWriter records when the first byte of a download is written
+type firstByteWriter struct {
+	timings *transferTimings
+}
+
+func (w firstByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.timings.set(&w.timings.firstByte)
+	}
+	return len(p), nil
+}
+
+// record fills in the payload's timing fields, in milliseconds since the
+// transfer started
+func (t *transferTimings) record(payload *ESPayload) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	since := func(phase time.Time) float64 {
+		if phase.IsZero() {
+			return 0
+		}
+		return phase.Sub(t.start).Seconds() * 1000
+	}
+	payload.DNSTime = since(t.dnsDone)
+	payload.ConnectTime = since(t.connectDone)
+	payload.TLSTime = since(t.tlsDone)
+	payload.OpenTime = since(t.opened)
+	payload.TTFB = since(t.firstByte)
+	payload.ConnectionReused = t.reused
+}
+
+// timingsEnv has xrdcp log the debug messages parseXRDTimings needs
+var timingsEnv = XRDEnv{"XRD_LOGLEVEL": "Debug"}
+
+// xrdLogTime is the layout of the timestamps xrdcp puts on log messages
+const xrdLogTime = "2006-01-02 15:04:05.000000 -0700"
+
+// xrdTimingMessages are the xrdcp debug messages marking the end of each
+// phase.  xrdcp doesn't log reads below the Dump level, so there's no time
+// to first byte, only when the open returned.
+var xrdTimingMessages = []struct {
+	message string
+	phase   func(t *transferTimings) *time.Time
+}{
+	{"Async connection call returned", func(t *transferTimings) *time.Time { return &t.connectDone }},
+	{"TLS handshake done", func(t *transferTimings) *time.Time { return &t.tlsDone }},
+	{"Open has returned with status [SUCCESS]", func(t *transferTimings) *time.Time { return &t.opened }},
+}
+
+// parseXRDTimings fills in timings from xrdcp's debug log
+func parseXRDTimings(log string, t *transferTimings) {
+	scanner := bufio.NewScanner(strings.NewReader(log))
+	for scanner.Scan() {
+		line := scanner.Text()
+		end := strings.Index(line, "]")
+		if !strings.HasPrefix(line, "[") || end < 0 {
+			continue
+		}
+		at, err := time.Parse(xrdLogTime, line[1:end])
+		if err != nil {
+			continue
+		}
+		for _, m := range xrdTimingMessages {
+			if phase := m.phase(t); strings.Contains(line, m.message) && phase.IsZero() {
+				*phase = at
+			}
+		}
+	}
+}
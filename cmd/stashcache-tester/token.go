@@ -0,0 +1,225 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the token test type checks a protected namespace over HTTPS: each test
+// file is downloaded with a bearer token and checked, then requested
+// without one, which the cache must refuse
+func init() {
+	registerTestType("token", runTokenTest)
+}
+
+// StatusDenied is reported for requests without a token that were refused,
+// as they should be
+const StatusDenied = "Denied"
+
+// auth values in payloads
+const (
+	AuthToken     = "token"
+	AuthAnonymous = "anonymous"
+)
+
+func runTokenTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	token, err := findToken(ts)
+	if err != nil {
+		result.success = false
+		result.result = classify(ErrorClassSetup, err)
+		return result
+	}
+	tokenTS := ts
+	tokenTS.bearerToken = token
+	backend := httpBackend{"https"}
+	digests := make(map[string]string)
+	for i, testFile := range ts.TestFiles {
+		uri := backend.URL(ts, testFile.Path)
+		filename := ts.localPath(testFile.Path)
+		result.files[i].URL = uri
+		fail := func(err error) {
+			result.files[i].Status = "Failure"
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("token test of %s failed: %s", uri, err)))
+		}
+
+		fileTS := tokenTS
+		if testFile.Fixture != "" {
+			fileTS.fixture = &testFile
+		}
+		payload, err := backend.Download(ctx, uri, filename, fileTS, testFile.timeout(ts))
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+		if err != nil {
+			fail(classify(errorClass(err), fmt.Errorf("download with a token failed: %s", err)))
+			continue
+		}
+		payload.TestType = ts.Type
+		if payload.digests == nil {
+			if payload.digests, err = fileDigests(ts, filename); err != nil {
+				err = classify(ErrorClassSetup, fmt.Errorf("can't hash %s: %s", filepath.Base(testFile.Path), err))
+			}
+		}
+		if err == nil {
+			digests[filepath.Base(testFile.Path)] = payload.digests[ts.HashAlgorithm]
+			err = verifyDigests(ts, testFile, payload)
+		}
+		if err == nil && testFile.Fixture != "" {
+			err = checkFixture(testFile, filename, payload)
+		}
+		os.Remove(filename)
+		if err != nil {
+			fmt.Printf("Can't verify %s: %s\n", uri, err)
+			payload.Status = failureStatus(err)
+			payload.Error = err.Error()
+			ReportTest(ctx, payload, ts.Collector)
+			fail(err)
+			continue
+		}
+		ReportTest(ctx, payload, ts.Collector)
+
+		if err := checkDenied(ctx, ts, uri, testFile.timeout(ts)); err != nil {
+			fmt.Printf("Access to %s without a token wasn't denied: %s\n", uri, err)
+			fail(err)
+			continue
+		}
+		result.files[i].Status = payload.Status
+	}
+	// the hash file is in the protected namespace too
+	if ts.HashFile == "" || len(digests) == 0 {
+		return result.aggregate()
+	}
+	hashFile := ts.localPath(ts.HashFile)
+	if _, err := backend.Download(ctx, backend.URL(ts, ts.HashFile), hashFile, tokenTS, time.Duration(ts.Timeout)); err != nil {
+		fmt.Printf("Can't download file hash: %s\n", err)
+		result.fail(classify(ErrorClassHashFile, fmt.Errorf("can't download file hash: %s", err)))
+		return result.aggregate()
+	}
+	if err := checkDigests(ts, result.files, digests); err != nil {
+		fmt.Printf("Can't verify file hashes: %s\n", err)
+		result.fail(err)
+	}
+	return result.aggregate()
+}
+
+// checkDenied requests uri without a token, reporting a payload with status
+// Denied if the cache refuses it with 401 or 403 and an error otherwise
+func checkDenied(ctx context.Context, ts TestSet, uri string, timeout time.Duration) error {
+	payload := newFilePayload("stashcache-tester-token", ts, uri)
+	payload.TestType = ts.Type
+	payload.Auth = AuthAnonymous
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+	status, err := anonymousStatus(ctx, ts, uri, timeout)
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.TimeStamp = nowMillis()
+	if status != 0 {
+		payload.XRDExit1 = strconv.Itoa(status)
+	}
+	switch {
+	case err != nil:
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		debugf("%s without a token was denied with %d\n", uri, status)
+		payload.Status = StatusDenied
+		ReportTest(ctx, payload, ts.Collector)
+		return nil
+	case status < 300:
+		err = classify(ErrorClassAuth, fmt.Errorf("request without a token returned %s", http.StatusText(status)))
+	default:
+		err = classify(ErrorClassAuth, fmt.Errorf("request without a token returned %d %s, expected 401 or 403", status, http.StatusText(status)))
+	}
+	payload.Status = "Failure"
+	payload.Error = err.Error()
+	ReportTest(ctx, payload, ts.Collector)
+	return err
+}
+
+// anonymousStatus returns the status of a GET of uri without credentials,
+// the body isn't read
+func anonymousStatus(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return 0, classify(ErrorClassSetup, err)
+	}
+	req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+	req.Close = true
+	debugf("Running GET %s without a token\n", uri)
+	resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, classify(ErrorClassTimeout, fmt.Errorf("GET %s timed out", uri))
+	}
+	if err != nil {
+		return 0, classify(ErrorClassTransfer, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// findToken reads the bearer token from the test set's tokenfile, or else
+// finds it as WLCG token discovery does: $BEARER_TOKEN, $BEARER_TOKEN_FILE,
+// $XDG_RUNTIME_DIR/bt_u<uid> then /tmp/bt_u<uid>
+func findToken(ts TestSet) (string, error) {
+	if ts.TokenFile == "" {
+		if token := strings.TrimSpace(os.Getenv("BEARER_TOKEN")); token != "" {
+			return token, nil
+		}
+	}
+	locations := []string{ts.TokenFile}
+	if ts.TokenFile == "" {
+		name := fmt.Sprintf("bt_u%d", os.Getuid())
+		locations = []string{os.Getenv("BEARER_TOKEN_FILE"), filepath.Join("/tmp", name)}
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			locations = []string{locations[0], filepath.Join(dir, name), locations[1]}
+		}
+	}
+	for _, location := range locations {
+		if location == "" {
+			continue
+		}
+		contents, err := ioutil.ReadFile(location)
+		if os.IsNotExist(err) && ts.TokenFile == "" {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("can't read token: %s", err)
+		}
+		if token := strings.TrimSpace(string(contents)); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("token file %s is empty", location)
+	}
+	return "", fmt.Errorf("no bearer token found, set tokenfile or $BEARER_TOKEN")
+}
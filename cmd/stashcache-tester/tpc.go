@@ -0,0 +1,143 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+// the tpc test type has the cache at tpcdestination pull each test file from
+// dnsname with a third-party copy, then compares the checksums both servers
+// report and removes the copy
+func init() {
+	registerTestType("tpc", runTPCTest)
+}
+
+func runTPCTest(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	for i, testFile := range ts.TestFiles {
+		destPath := path.Join(ts.TPCDir, fmt.Sprintf("%s.%d", path.Base(testFile.Path), time.Now().UnixNano()))
+		payload, err := thirdPartyCopy(ctx, ts, testFile, destPath)
+		result.files[i].URL = "root://" + ts.endpoint("root") + "/" + testFile.Path
+		result.files[i].Status = payload.Status
+		result.files[i].Bytes = payload.DownloadSize
+		result.files[i].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+		if err != nil {
+			result.files[i].Status = "Failure"
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("third-party copy of %s failed: %s", testFile.Path, err)))
+		}
+	}
+	return result.aggregate()
+}
+
+// thirdPartyCopy copies a test file to destPath on the destination,
+// reporting the payload for it
+func thirdPartyCopy(parent context.Context, ts TestSet, testFile TestFile, destPath string) (ESPayload, error) {
+	src := "root://" + ts.endpoint("root") + "/" + testFile.Path
+	dst := "root://" + ts.TPCDestination + "/" + destPath
+	payload := newFilePayload("stashcache-tester-tpc", ts, testFile.Path)
+	payload.TestType = ts.Type
+	payload.Destination = dst
+	timeout := testFile.timeout(ts)
+	start := time.Now()
+	payload.Start1 = unixMillis(start)
+	payload.Tries = 1
+
+	fail := func(err error) (ESPayload, error) {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		payload.TimeStamp = nowMillis()
+		fmt.Printf("Third-party copy of %s to %s failed: %s\n", src, dst, err)
+		ReportTest(parent, payload, ts.Collector)
+		return payload, err
+	}
+
+	release, err := runXrdcp(parent)
+	if err != nil {
+		return fail(err)
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	var out bytes.Buffer
+	args := []string{"--tpc", "only", "--force", src, dst}
+	cmd := exec.CommandContext(ctx, "xrdcp", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	// keep xrdcp's output for --keep-failed
+	if logFile, err := os.Create(ts.localPath(testFile.Path) + ".tpc.log"); err == nil {
+		defer logFile.Close()
+		cmd.Stdout = io.MultiWriter(&out, logFile)
+		cmd.Stderr = cmd.Stdout
+	}
+	cmd.Env = append(os.Environ(), xrdcpEnv(ts.XRDEnv)...)
+	debugf("Running %s\n", commandLine(xrdcpEnv(ts.XRDEnv), "xrdcp", args...))
+	err = cmd.Run()
+	end := time.Now()
+	payload.End1 = unixMillis(end)
+	payload.DownloadTime = end.Sub(start).Seconds() * 1000
+	payload.ClientOutput = clientOutputTail(out.String())
+	if cmd.ProcessState != nil {
+		payload.XRDExit1 = fmt.Sprint(cmd.ProcessState.ExitCode())
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fail(classify(ErrorClassTimeout, fmt.Errorf("timed out after %s", timeout)))
+	}
+	if err != nil {
+		return fail(fmt.Errorf("xrdcp --tpc failed: %s", err))
+	}
+	defer func() {
+		if _, err := xrdfs(parent, ts, ts.TPCDestination, timeout, "rm", destPath); err != nil {
+			fmt.Printf("Can't remove third-party copy %s: %s\n", dst, err)
+		}
+	}()
+
+	srcSum, err := queryChecksum(ctx, ts, ts.endpoint("root"), testFile.Path, timeout)
+	if err != nil {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("can't get source checksum: %s", err)))
+	}
+	dstSum, err := queryChecksum(ctx, ts, ts.TPCDestination, destPath, timeout)
+	if err != nil {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("can't get destination checksum: %s", err)))
+	}
+	payload.Checksum = dstSum
+	if srcSum != dstSum {
+		return fail(classify(ErrorClassChecksum, fmt.Errorf("source checksum %s doesn't match destination checksum %s", srcSum, dstSum)))
+	}
+
+	payload.Status = "Success"
+	payload.TimeStamp = nowMillis()
+	if size, err := xrdfs(parent, ts, ts.TPCDestination, timeout, "stat", destPath); err == nil {
+		payload.DownloadSize, _ = parseStatSize(size)
+		payload.FileSize = payload.DownloadSize
+	}
+	debugf("Copied %s to %s in %s\n", src, dst, end.Sub(start).Round(time.Millisecond))
+	ReportTest(parent, payload, ts.Collector)
+	return payload, nil
+}
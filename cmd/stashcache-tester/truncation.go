@@ -0,0 +1,84 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// StatusTruncated is reported for downloads shorter than the size expected
+// for them, which a client that doesn't notice a short read would count as
+// a success
+const StatusTruncated = "Truncated"
+
+// classStatuses gives the payload status for failures that have their own
+// rather than "Failure"
+var classStatuses = map[string]string{
+	ErrorClassDisagree:  StatusChecksumDisagreement,
+	ErrorClassTruncated: StatusTruncated,
+	ErrorClassStalled:   StatusStalled,
+	ErrorClassInternal:  StatusInternalError,
+}
+
+// failureStatus returns the payload status for a failed file
+func failureStatus(err error) string {
+	if status, ok := classStatuses[errorClass(err)]; ok {
+		return status
+	}
+	return "Failure"
+}
+
+// sizeError describes a download of the wrong size, classed as truncated if
+// it's short
+func sizeError(filename string, size int64, expected int64) error {
+	if size < expected {
+		return classify(ErrorClassTruncated, fmt.Errorf("%s is truncated, got %d of %d bytes", filename, size, expected))
+	}
+	return classify(ErrorClassSize, fmt.Errorf("%s is %d bytes, expected %d", filename, size, expected))
+}
+
+// statSize returns the size of remotePath from xrdfs stat on the test set's
+// origin, or the cache itself if it has none.  Full urls can only be stat'd
+// on an origin.
+func statSize(ctx context.Context, ts TestSet, remotePath string, timeout time.Duration) (int64, error) {
+	host := ts.Origin
+	if host == "" {
+		if isTestURL(remotePath) {
+			return 0, fmt.Errorf("full urls can only be stat'd on an origin")
+		}
+		host = ts.endpoint("root")
+	}
+	if isTestURL(remotePath) {
+		u, err := url.Parse(remotePath)
+		if err != nil {
+			return 0, err
+		}
+		remotePath = u.Path
+	}
+	out, err := xrdfs(ctx, ts, host, timeout, "stat", remotePath)
+	if err != nil {
+		return 0, err
+	}
+	size, ok := parseStatSize(out)
+	if !ok {
+		return 0, fmt.Errorf("no size in xrdfs stat output %q", out)
+	}
+	return size, nil
+}
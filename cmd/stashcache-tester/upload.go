@@ -0,0 +1,151 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+)
+
+// the upload test type writes a generated file to the origin, reads it back
+// through the cache at dnsname with the test set's backend, checks it and
+// removes it from the origin
+func init() {
+	registerTestType("upload", runUploadTest)
+}
+
+func runUploadTest(ctx context.Context, ts TestSet) TestResult {
+	name := fmt.Sprintf("stashcache-tester-upload.%d", time.Now().UnixNano())
+	remotePath := path.Join(ts.UploadDir, name)
+	result := TestResult{files: []FileSummary{{Path: remotePath, Status: StatusNotRun}}}
+	fail := func(err error) TestResult {
+		result.files[0].Status = "Failure"
+		result.files[0].setError(err)
+		result.result = classify(errorClass(err), fmt.Errorf("upload test of %s failed: %s", remotePath, err))
+		return result
+	}
+
+	local := ts.localPath(name)
+	sum, err := writeRandomFile(local, int64(ts.UploadSize))
+	if err != nil {
+		return fail(classify(ErrorClassSetup, err))
+	}
+	dst := "root://" + ts.Origin + "/" + remotePath
+	upload := newFilePayload("stashcache-tester-upload", ts, local)
+	upload.TestType = ts.Type
+	upload.Destination = dst
+	start := time.Now()
+	err = uploadFile(ctx, ts, local, dst)
+	end := time.Now()
+	upload.UploadStart = unixMillis(start)
+	upload.UploadEnd = unixMillis(end)
+	upload.UploadTime = end.Sub(start).Seconds() * 1000
+	if err != nil {
+		upload.Status = "Failure"
+		upload.Error = err.Error()
+		upload.TimeStamp = nowMillis()
+		fmt.Printf("Can't upload %s: %s\n", dst, err)
+		ReportTest(ctx, upload, ts.Collector)
+		return fail(classify(ErrorClassUpload, err))
+	}
+	defer func() {
+		if _, err := xrdfs(ctx, ts, ts.Origin, time.Duration(ts.Timeout), "rm", remotePath); err != nil {
+			fmt.Printf("Can't remove uploaded file %s: %s\n", dst, err)
+		}
+	}()
+	// make sure what's checked is what was read back
+	os.Remove(local)
+
+	backend, err := lookupBackend(ts)
+	if err != nil {
+		return fail(classify(ErrorClassSetup, err))
+	}
+	uri := backend.URL(ts, remotePath)
+	result.files[0].URL = uri
+	payload, err := backend.Download(ctx, uri, local, ts, time.Duration(ts.Timeout))
+	result.files[0].Bytes = payload.DownloadSize
+	result.files[0].Duration = time.Duration(payload.DownloadTime * float64(time.Millisecond))
+	if err != nil {
+		return fail(err)
+	}
+	payload.TestType = ts.Type
+	payload.Destination = dst
+	payload.UploadStart = upload.UploadStart
+	payload.UploadEnd = upload.UploadEnd
+	payload.UploadTime = upload.UploadTime
+	if err := verifyDownload(ts, TestFile{Path: remotePath, Size: ts.UploadSize, SHA256: sum}, local, payload); err != nil {
+		payload.Status = "Failure"
+		payload.Error = err.Error()
+		fmt.Printf("Can't verify %s: %s\n", uri, err)
+		ReportTest(ctx, payload, ts.Collector)
+		return fail(err)
+	}
+	ReportTest(ctx, payload, ts.Collector)
+	result.files[0].Status = payload.Status
+	result.success = true
+	result.result = nil
+	return result
+}
+
+// writeRandomFile writes size random bytes to name, returning their sha256
+func writeRandomFile(name string, size int64) (string, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return "", fmt.Errorf("can't create %s: %s", name, err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(f, hash), rand.Reader, size); err != nil {
+		return "", fmt.Errorf("can't write %s: %s", name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), f.Close()
+}
+
+// uploadFile copies a local file to dst with xrdcp
+func uploadFile(ctx context.Context, ts TestSet, name string, dst string) error {
+	release, err := runXrdcp(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(ts.Timeout))
+	defer cancel()
+	var out bytes.Buffer
+	args := []string{"--force", name, dst}
+	cmd := exec.CommandContext(ctx, "xrdcp", args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.Env = append(os.Environ(), xrdcpEnv(ts.XRDEnv)...)
+	debugf("Running %s\n", commandLine(xrdcpEnv(ts.XRDEnv), "xrdcp", args...))
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return classify(ErrorClassTimeout, fmt.Errorf("timed out after %s", time.Duration(ts.Timeout)))
+	}
+	if err != nil {
+		return fmt.Errorf("xrdcp failed: %s: %s", err, clientOutputTail(out.String()))
+	}
+	return nil
+}
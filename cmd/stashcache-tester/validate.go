@@ -0,0 +1,556 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValidationError describes a single problem found in a config
+type ValidationError struct {
+	Index       int    `json:"index"`
+	SiteName    string `json:"sitename,omitempty"`
+	TestSetName string `json:"testsetname,omitempty"`
+	Field       string `json:"field"`
+	Message     string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Index < 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("entry %d (%s/%s): %s: %s", e.Index, e.SiteName, e.TestSetName, e.Field, e.Message)
+}
+
+// checkConfig validates a config that's about to be run, returning every
+// problem found as one error
+func checkConfig(name string, config *Config) error {
+	errs := validateTestSets(config.TestSets, false)
+	errs = append(errs, append(validateProfiles(config), validateReporters(config)...)...)
+	if len(errs) == 0 {
+		return nil
+	}
+	configErrs := &ConfigErrors{Name: name}
+	for _, e := range errs {
+		configErrs.Errors = append(configErrs.Errors, e.Error())
+	}
+	return configErrs
+}
+
+// validateTestSets checks a config for missing fields, duplicate test sets
+// and malformed paths, optionally resolving every DNSName as well
+func validateTestSets(testSets []TestSet, probeDNS bool) []ValidationError {
+	var errs []ValidationError
+	seen := make(map[string]int)
+	resolved := make(map[string]error)
+	for i, ts := range testSets {
+		addErr := func(field string, format string, args ...interface{}) {
+			errs = append(errs, ValidationError{i, ts.SiteName, ts.TestSetName, field, fmt.Sprintf(format, args...)})
+		}
+		if ts.DNSName == "" {
+			addErr("dnsname", "missing required field")
+		} else if msg := checkEndpoint(ts.DNSName); msg != "" {
+			addErr("dnsname", "%s: %s", ts.DNSName, msg)
+		} else if _, port := splitHostPort(ts.DNSName); port != "" && ts.XRootPort != 0 {
+			addErr("xrootport", "the xrootd port is already given in dnsname")
+		}
+		// 0 is a port that isn't set, one given as 0 is refused when the
+		// config is decoded
+		ports := []Port{ts.XRootPort, ts.HTTPPort, ts.HTTPSPort}
+		for j, field := range []string{"xrootport", "httpport", "httpsport"} {
+			if ports[j] != 0 && (ports[j] < 1 || ports[j] > 65535) {
+				addErr(field, "port must be between 1 and 65535")
+			}
+		}
+		if ts.SiteName == "" {
+			addErr("sitename", "missing required field")
+		}
+		if ts.TestSetName == "" {
+			addErr("testsetname", "missing required field")
+		}
+		if ts.HashFile == "" && !ts.hasChecksums() && (ts.Type == DefaultTestType || ts.Type == "stream" || ts.Type == "range" || ts.Type == "readv" || ts.Type == "token") {
+			addErr("hashfile", "missing required field unless every test file has a sha256, checksum or fixture")
+		} else if msg := checkTestPath(ts, ts.HashFile); ts.HashFile != "" && msg != "" {
+			addErr("hashfile", "%s: %s", ts.HashFile, msg)
+		}
+		if _, ok := hashFunctions[ts.HashAlgorithm]; !ok {
+			addErr("hashalgorithm", "unsupported hash algorithm %q", ts.HashAlgorithm)
+		}
+		if _, ok := hashFormats[ts.HashFormat]; !ok {
+			addErr("hashformat", "unsupported hash file format %q, use coreutils or bsd", ts.HashFormat)
+		}
+		if _, err := lookupBackend(ts); err != nil {
+			addErr("backend", "%s", err)
+		}
+		if len(ts.Fallback) > 0 && (!fallbackBackends[ts.Backend] || ts.Type != DefaultTestType) {
+			addErr("fallback", "fallback is only supported by download test sets using a backend that downloads from dnsname")
+		}
+		for j, name := range ts.Fallback {
+			if _, err := lookupBackend(TestSet{Backend: name}); err != nil {
+				addErr(fmt.Sprintf("fallback[%d]", j), "%s", err)
+			} else if !fallbackBackends[name] {
+				addErr(fmt.Sprintf("fallback[%d]", j), "%s doesn't download from dnsname so can't be a fallback", name)
+			}
+		}
+		if u, err := url.Parse(ts.Director); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addErr("director", "invalid director url %q", ts.Director)
+		}
+		if err := checkTestType(ts); err != nil {
+			addErr("type", "%s", err)
+		} else if msg := cantStat(ts); ts.Type == "stat" && msg != "" {
+			addErr("testfiles", "%s", msg)
+		}
+		if ts.Type == "tpc" {
+			if ts.TPCDestination == "" {
+				addErr("tpcdestination", "missing required field for tpc test sets")
+			} else if msg := checkEndpoint(ts.TPCDestination); msg != "" {
+				addErr("tpcdestination", "%s: %s", ts.TPCDestination, msg)
+			}
+			if ts.TPCDir == "" {
+				addErr("tpcdir", "missing required field for tpc test sets")
+			} else if msg := checkRemotePath(ts.TPCDir); msg != "" {
+				addErr("tpcdir", "%s: %s", ts.TPCDir, msg)
+			}
+		}
+		switch ts.Addresses {
+		case "", AddressesDualStack, AddressesAll:
+			if ts.Addresses != "" && (!addressBackends[ts.Backend] || ts.Type != DefaultTestType) {
+				addErr("addresses", "addresses is only supported by download test sets using the xrdcp, native, http, https or curl backends")
+			}
+		default:
+			addErr("addresses", "unknown addresses setting %q, use %s or %s", ts.Addresses, AddressesDualStack, AddressesAll)
+		}
+		if msg := checkProxy(ts.Proxy); ts.Proxy != "" && msg != "" {
+			addErr("proxy", "%s: %s", ts.Proxy, msg)
+		} else if ts.Proxy != "" && !proxyBackends[ts.Backend] && ts.Type != "range" && ts.Type != "webdav" {
+			addErr("proxy", "proxy is only supported by the http, https and curl backends and range and webdav test sets")
+		}
+		if ts.Discard && (!discardBackends[ts.Backend] || ts.Type != DefaultTestType) {
+			addErr("discard", "discard is only supported by download test sets using the native, http or https backends")
+		}
+		for j, name := range ts.Fallback {
+			if ts.Discard && !discardBackends[name] {
+				addErr(fmt.Sprintf("fallback[%d]", j), "%s can't discard downloads", name)
+			}
+		}
+		for j, testFile := range ts.TestFiles {
+			// full urls are downloaded with a backend for their scheme
+			if !ts.Discard || !isTestURL(testFile.Path) {
+				continue
+			}
+			scheme := testURLSchemes[testFile.Path[:strings.Index(testFile.Path, "://")]]
+			if name := urlBackend(ts, scheme); !discardBackends[name] {
+				addErr(fmt.Sprintf("testfiles[%d]", j), "%s is downloaded with %s, which can't discard downloads", testFile.Path, name)
+			}
+		}
+		if ts.ServerChecksum && (!usesDirector(ts) || ts.Type != DefaultTestType) {
+			addErr("serverchecksum", "serverchecksum is only supported by download test sets using backends other than stashcp and pelican")
+		}
+		if len(ts.Sources) > 0 && (ts.Backend != "xrdcp" || ts.Type != DefaultTestType) {
+			addErr("sources", "sources is only supported by download test sets using the xrdcp backend")
+		}
+		for j, source := range ts.Sources {
+			if msg := checkEndpoint(source); msg != "" {
+				addErr(fmt.Sprintf("sources[%d]", j), "%s: %s", source, msg)
+			}
+		}
+		if ts.Streams < 0 || ts.Streams > maxXRDStreams {
+			addErr("streams", "streams must be between 0 and %d", maxXRDStreams)
+		} else if ts.Streams > 0 && ts.Backend != "xrdcp" {
+			addErr("streams", "streams is only supported by the xrdcp backend")
+		}
+		if ts.Attempts < 0 || ts.Attempts > maxAttempts {
+			addErr("attempts", "attempts must be between 0 and %d", maxAttempts)
+		} else if ts.Attempts > 1 && ts.Type != DefaultTestType {
+			addErr("attempts", "attempts is only supported by download test sets")
+		}
+		if ts.Backoff < 0 {
+			addErr("backoff", "backoff can't be negative")
+		}
+		if ts.Timeout <= 0 {
+			addErr("timeout", "timeout must be positive")
+		}
+		for _, collector := range ts.Collector {
+			if err := checkCollector(collector); err != nil {
+				addErr("collector", "%s", err)
+			}
+		}
+		if info, err := os.Stat(ts.ScratchDir); err != nil {
+			addErr("scratchdir", "%s", err)
+		} else if !info.IsDir() {
+			addErr("scratchdir", "%s isn't a directory", ts.ScratchDir)
+		}
+		if msg := checkEndpoint(ts.Origin); ts.Origin != "" && msg != "" {
+			addErr("origin", "%s: %s", ts.Origin, msg)
+		}
+		if ts.StatSize && ts.Type != DefaultTestType {
+			addErr("statsize", "statsize is only supported by download test sets")
+		}
+		if ts.Timings && ts.Backend != "xrdcp" {
+			addErr("timings", "timings only applies to the xrdcp backend, the http, https and native backends always record them")
+		}
+		if ts.MinThroughput < 0 {
+			addErr("minthroughput", "minthroughput can't be negative")
+		} else if ts.MinThroughput > 0 && ts.Type != DefaultTestType {
+			addErr("minthroughput", "minthroughput is only supported by download test sets")
+		}
+		if ts.Redirector && !(ts.Type == DefaultTestType && redirectorBackends[ts.Backend] || ts.Type == "readv") {
+			addErr("redirector", "redirector is only supported by readv test sets and download test sets using the xrdcp, http or https backends")
+		}
+		if ts.MinBandwidth < 0 {
+			addErr("minbandwidth", "minbandwidth can't be negative")
+		}
+		if ts.TimeoutOverhead < 0 {
+			addErr("timeoutoverhead", "timeoutoverhead can't be negative")
+		}
+		if ts.StallTimeout < 0 {
+			addErr("stalltimeout", "stalltimeout can't be negative")
+		} else if ts.StallTimeout > 0 && len(ts.TestFiles) == 0 && ts.StallTimeout >= ts.Timeout {
+			// test files are checked against their own timeouts below
+			addErr("stalltimeout", "stalltimeout should be shorter than timeout")
+		}
+		if ts.HungAfter < 0 {
+			addErr("hungafter", "hungafter can't be negative")
+		} else if ts.HungAfter > 0 && ts.HungAfter >= ts.Timeout {
+			addErr("hungafter", "hungafter should be shorter than timeout")
+		}
+		if ts.CertWarnDays < 0 {
+			addErr("certwarndays", "certwarndays can't be negative")
+		}
+		if ts.ParallelFiles < 0 {
+			addErr("parallelfiles", "parallelfiles can't be negative")
+		} else if ts.ParallelFiles > 1 && ts.Type != DefaultTestType {
+			addErr("parallelfiles", "parallelfiles is only supported by download test sets")
+		} else if ts.ParallelFiles > 1 {
+			// files are saved under their base names, two downloads at once
+			// would write the same file
+			seen := make(map[string]string)
+			for _, testFile := range ts.TestFiles {
+				base := path.Base(testFile.Path)
+				if other, ok := seen[base]; ok && other != testFile.Path {
+					addErr("parallelfiles", "%s and %s have the same name, they can't be downloaded in parallel", other, testFile.Path)
+				}
+				seen[base] = testFile.Path
+			}
+		}
+		if ts.RateLimit < 0 {
+			addErr("ratelimit", "ratelimit can't be negative")
+		} else if ts.RateLimit > 0 && !rateLimitBackends[ts.Backend] {
+			addErr("ratelimit", "ratelimit is only supported by the xrdcp, native, http, https and curl backends")
+		} else if ts.RateLimit > 0 {
+			for j, name := range ts.Fallback {
+				if !rateLimitBackends[name] {
+					addErr(fmt.Sprintf("fallback[%d]", j), "%s can't apply ratelimit", name)
+				}
+			}
+		}
+		if ts.Clients < 0 {
+			addErr("clients", "clients can't be negative")
+		} else if ts.Clients > 0 && ts.Type != "stress" {
+			addErr("clients", "clients is only supported by stress test sets")
+		}
+		if ts.MaxErrorLatency < 0 {
+			addErr("maxerrorlatency", "maxerrorlatency can't be negative")
+		} else if ts.MaxErrorLatency > 0 && ts.Type != "notfound" {
+			addErr("maxerrorlatency", "maxerrorlatency is only supported by notfound test sets")
+		}
+		if ts.FreshnessTTL < 0 {
+			addErr("freshnessttl", "freshnessttl can't be negative")
+		} else if ts.FreshnessTTL > 0 && ts.Type != "freshness" {
+			addErr("freshnessttl", "freshnessttl is only supported by freshness test sets")
+		}
+		if ts.Type == "stress" && !discardBackends[ts.Backend] {
+			addErr("backend", "stress test sets need the native, http or https backend")
+		}
+		if ts.CompareOrigin && ts.Origin == "" {
+			addErr("compareorigin", "compareorigin needs an origin")
+		} else if ts.CompareOrigin && ts.Type != DefaultTestType {
+			addErr("compareorigin", "compareorigin is only supported by download test sets")
+		} else if ts.CompareOrigin && !usesDirector(ts) {
+			addErr("compareorigin", "the %s backend goes through the director so can't download from the origin", ts.Backend)
+		}
+		if ts.Warm && ts.Type != DefaultTestType {
+			addErr("warm", "warm is only supported by download test sets")
+		}
+		if ts.ListDir == "" && (ts.Type == "list" || ts.Discover != "") {
+			addErr("listdir", "missing required field for list test sets and discover")
+		} else if msg := checkRemotePath(strings.TrimSuffix(ts.ListDir, "/")); ts.ListDir != "" && msg != "" {
+			addErr("listdir", "%s: %s", ts.ListDir, msg)
+		}
+		if _, err := path.Match(ts.Discover, ""); err != nil {
+			addErr("discover", "%q isn't a valid pattern: %s", ts.Discover, err)
+		} else if ts.Discover != "" && (ts.Type != DefaultTestType || !usesDirector(ts)) {
+			addErr("discover", "discover is only supported by download test sets using backends other than stashcp and pelican")
+		}
+		if ts.Type == "upload" || ts.Type == "freshness" {
+			if ts.Origin == "" {
+				addErr("origin", "missing required field for upload and freshness test sets")
+			}
+			if ts.UploadDir == "" {
+				addErr("uploaddir", "missing required field for upload and freshness test sets")
+			} else if msg := checkRemotePath(ts.UploadDir); msg != "" {
+				addErr("uploaddir", "%s: %s", ts.UploadDir, msg)
+			}
+			if ts.UploadSize <= 0 {
+				addErr("uploadsize", "uploadsize must be positive")
+			}
+		} else if len(ts.TestFiles) == 0 && ts.Discover == "" && ts.Type != "list" && ts.Type != "certificate" {
+			addErr("testfiles", "at least one test file is required")
+		}
+		for j, testFile := range ts.TestFiles {
+			if msg := checkTestPath(ts, testFile.Path); msg != "" {
+				addErr(fmt.Sprintf("testfiles[%d]", j), "%s: %s", testFile.Path, msg)
+			}
+			if testFile.Timeout < 0 {
+				addErr(fmt.Sprintf("testfiles[%d].timeout", j), "timeout can't be negative")
+			} else if timeout := testFile.timeout(ts); ts.StallTimeout > 0 && ts.StallTimeout >= Duration(timeout) {
+				addErr("stalltimeout", "stalltimeout should be shorter than the %s timeout of %s", timeout, testFile.Path)
+			}
+			if testFile.SHA256 != "" && !isSHA256(testFile.SHA256) {
+				addErr(fmt.Sprintf("testfiles[%d].sha256", j), "%q isn't a hex encoded sha256", testFile.SHA256)
+			}
+			if _, ok := hashFunctions[ts.HashAlgorithm]; ok && testFile.Checksum != "" && !isDigest(ts.HashAlgorithm, testFile.Checksum) {
+				addErr(fmt.Sprintf("testfiles[%d].checksum", j), "%q isn't a hex encoded %s", testFile.Checksum, ts.HashAlgorithm)
+			}
+			if testFile.Fixture != "" && testFile.Size <= 0 {
+				addErr(fmt.Sprintf("testfiles[%d].size", j), "fixtures need a size")
+			}
+			if testFile.Tier != "" && ts.tier(testFile, 0) == nil {
+				addErr(fmt.Sprintf("testfiles[%d].tier", j), "no tier named %q in tiers", testFile.Tier)
+			}
+		}
+		if len(ts.Tiers) > 0 && ts.Type != DefaultTestType {
+			addErr("tiers", "tiers are only supported by download test sets")
+		}
+		tierNames := make(map[string]bool)
+		for j, tier := range ts.Tiers {
+			switch {
+			case tier.Name == "":
+				addErr(fmt.Sprintf("tiers[%d].name", j), "missing required field")
+			case tierNames[tier.Name]:
+				addErr(fmt.Sprintf("tiers[%d].name", j), "duplicate tier %q", tier.Name)
+			}
+			tierNames[tier.Name] = true
+			if tier.MaxSize < 0 {
+				addErr(fmt.Sprintf("tiers[%d].maxsize", j), "maxsize can't be negative")
+			}
+			if tier.MinThroughput < 0 {
+				addErr(fmt.Sprintf("tiers[%d].minthroughput", j), "minthroughput can't be negative")
+			}
+		}
+		if ts.SiteName != "" && ts.TestSetName != "" {
+			key := ts.SiteName + "/" + ts.TestSetName
+			if first, ok := seen[key]; ok {
+				addErr("testsetname", "duplicate test set, first defined in entry %d", first)
+			} else {
+				seen[key] = i
+			}
+		}
+		if probeDNS && ts.DNSName != "" {
+			err, ok := resolved[ts.DNSName]
+			if !ok {
+				_, err = net.LookupHost(ts.hostname())
+				resolved[ts.DNSName] = err
+			}
+			if err != nil {
+				addErr("dnsname", "can't resolve %s: %s", ts.DNSName, err)
+			}
+		}
+	}
+	return errs
+}
+
+// hostnameLabel matches a single RFC 1123 hostname label
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// checkHostname returns a description of what's wrong with a hostname or
+// an empty string if it's a valid hostname or IP address
+func checkHostname(hostname string) string {
+	if strings.Contains(hostname, "://") {
+		return "dnsname should be a hostname, not a url"
+	}
+	if net.ParseIP(hostname) != nil {
+		return ""
+	}
+	if len(hostname) > 253 {
+		return "hostname is too long"
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(hostname, "."), ".") {
+		if !hostnameLabel.MatchString(label) {
+			return fmt.Sprintf("invalid hostname label %q", label)
+		}
+	}
+	return ""
+}
+
+// checkEndpoint is checkHostname for a hostname with an optional :port
+func checkEndpoint(hostport string) string {
+	host, port := splitHostPort(hostport)
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Sprintf("invalid port %q", port)
+		}
+	}
+	return checkHostname(host)
+}
+
+// maxXRDStreams is the most additional streams xrdcp --streams accepts
+const maxXRDStreams = 15
+
+// checkTestPath checks a test set's remote path, which may be a full url, a
+// stash:// url or an osdf:// or pelican:// url for the pelican backend
+func checkTestPath(ts TestSet, remotePath string) string {
+	if isTestURL(remotePath) {
+		return checkTestURL(ts, remotePath)
+	}
+	if !isFederationURL(remotePath) && !isStashURL(remotePath) {
+		return checkRemotePath(remotePath)
+	}
+	if isFederationURL(remotePath) && ts.Backend != "pelican" {
+		return "osdf:// and pelican:// urls need the pelican backend"
+	}
+	u, err := url.Parse(remotePath)
+	if err != nil {
+		return err.Error()
+	}
+	return checkRemotePath(u.Path)
+}
+
+// checkTestURL checks a test file given as a full url, which only the
+// download and stat test types with a backend other than stashcp or pelican
+// can fetch
+func checkTestURL(ts TestSet, remotePath string) string {
+	if ts.Type != "" && ts.Type != DefaultTestType && ts.Type != "stat" {
+		return fmt.Sprintf("the %s test type can't use full urls", ts.Type)
+	}
+	if !usesDirector(ts) {
+		return fmt.Sprintf("the %s backend can't use full urls", ts.Backend)
+	}
+	u, err := url.Parse(remotePath)
+	if err != nil {
+		return err.Error()
+	}
+	if u.Host == "" {
+		return "url has no host"
+	}
+	if msg := checkEndpoint(u.Host); msg != "" {
+		return msg
+	}
+	return checkRemotePath("/" + strings.TrimLeft(u.Path, "/"))
+}
+
+// checkRemotePath returns a description of what's wrong with a remote
+// path or an empty string if it looks usable
+func checkRemotePath(remotePath string) string {
+	switch {
+	case !strings.HasPrefix(remotePath, "/"):
+		return "path must be absolute"
+	case strings.HasSuffix(remotePath, "/"):
+		return "path must be a file, not a directory"
+	case strings.ContainsAny(remotePath, " \t\n"):
+		return "path can't contain whitespace"
+	case path.Clean(remotePath) != remotePath:
+		return "path isn't in canonical form (remove //, . or ..)"
+	}
+	return ""
+}
+
+// validateCommand implements the validate subcommand, returning 1 if the
+// config has problems and 2 if it can't be read at all
+func validateCommand(args []string) int {
+	flags := newFlagSet("validate", "[options] [config]")
+	configFlag := addConfigFlag(flags)
+	probeDNS := flags.Bool("probe-dns", false, "check that every dnsname resolves")
+	jsonOutput := flags.Bool("json", false, "print errors as json")
+	printSchema := flags.Bool("schema", false, "print the JSON Schema for configs and exit")
+	statOnly := flags.Bool("stat-only", false, "check the config as run --stat-only runs it")
+	flags.Parse(args)
+
+	if *printSchema {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(configSchema())
+		return 0
+	}
+
+	if flags.NArg() > 0 {
+		*configFlag = flags.Arg(0)
+	}
+	configLocation, err := findConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	}
+	var errs []ValidationError
+	var testSets []TestSet
+	config, err := loadConfigFile(configLocation)
+	if configErrs, ok := err.(*ConfigErrors); ok {
+		// structural problems stop the config being decoded, so they're
+		// all that can be reported
+		for _, msg := range configErrs.Errors {
+			errs = append(errs, ValidationError{Index: -1, Field: "structure", Message: msg})
+		}
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitConfigError
+	} else {
+		testSets = config.TestSets
+		if *statOnly {
+			for i, ts := range testSets {
+				if ts.Type != DefaultTestType {
+					continue
+				}
+				if msg := cantStat(ts); msg != "" {
+					errs = append(errs, ValidationError{i, ts.SiteName, ts.TestSetName, "backend", msg})
+				} else {
+					testSets[i] = asStatTestSet(ts)
+				}
+			}
+		}
+		errs = append(errs, validateTestSets(testSets, *probeDNS)...)
+		errs = append(errs, append(validateProfiles(config), validateReporters(config)...)...)
+	}
+	if *jsonOutput {
+		if errs == nil {
+			errs = []ValidationError{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(errs)
+	} else {
+		for _, e := range errs {
+			fmt.Println(e)
+		}
+		if len(errs) == 0 {
+			fmt.Printf("%s: %d test sets OK\n", configLocation, len(testSets))
+		} else {
+			fmt.Printf("%s: %d problems found\n", configLocation, len(errs))
+		}
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
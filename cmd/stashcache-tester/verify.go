@@ -0,0 +1,304 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// hashFunctions are the supported hash algorithms for hash files
+var hashFunctions = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha1":    sha1.New,
+	"sha256":  sha256.New,
+	"sha512":  sha512.New,
+	"adler32": func() hash.Hash { return adler32.New() },
+	"crc32c":  func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+}
+
+// hashFormats parse the supported hash file formats, returning the hashes
+// of the given algorithm they list by file name
+var hashFormats = map[string]func(algorithm string, contents string) map[string]string{
+	"coreutils": parseCoreutilsHashFile,
+	"bsd":       parseBSDHashFile,
+}
+
+// parseHashFile returns the hashes listed in the test set's hash file by
+// file name
+func parseHashFile(ts TestSet, contents string) (map[string]string, error) {
+	parse, ok := hashFormats[ts.HashFormat]
+	if !ok {
+		return nil, classify(ErrorClassSetup, fmt.Errorf("unsupported hash file format %q", ts.HashFormat))
+	}
+	return parse(ts.HashAlgorithm, contents), nil
+}
+
+// parseCoreutilsHashFile parses "<hash>  <name>" or "<hash> *<name>" lines
+// as written by sha256sum and friends, which only hold one algorithm
+func parseCoreutilsHashFile(algorithm string, contents string) map[string]string {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			hashes[path.Base(strings.TrimPrefix(fields[1], "*"))] = strings.ToLower(fields[0])
+		}
+	}
+	return hashes
+}
+
+// parseBSDHashFile parses "<ALGORITHM> (<name>) = <hash>" lines as written
+// by BSD's md5 and sha256 or "sha256sum --tag", skipping other algorithms
+func parseBSDHashFile(algorithm string, contents string) map[string]string {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := scanner.Text()
+		nameStart, nameEnd := strings.Index(line, " ("), strings.LastIndex(line, ") = ")
+		if nameStart < 0 || nameEnd < nameStart {
+			continue
+		}
+		label := strings.ToLower(strings.Replace(strings.TrimSpace(line[:nameStart]), "-", "", -1))
+		if label != algorithm {
+			continue
+		}
+		hashes[path.Base(line[nameStart+2:nameEnd])] = strings.ToLower(strings.TrimSpace(line[nameEnd+4:]))
+	}
+	return hashes
+}
+
+// hasChecksums reports whether every test file has a sha256, checksum or
+// fixture in the config, in which case the test set doesn't need a hash file
+func (ts TestSet) hasChecksums() bool {
+	for _, testFile := range ts.TestFiles {
+		if testFile.SHA256 == "" && testFile.Checksum == "" && testFile.Fixture == "" {
+			return false
+		}
+	}
+	return len(ts.TestFiles) > 0
+}
+
+func isSHA256(value string) bool {
+	return isDigest("sha256", value)
+}
+
+// isDigest reports whether value is a hex encoded hash of the algorithm,
+// never for an algorithm that isn't in hashFunctions
+func isDigest(algorithm string, value string) bool {
+	newHash, ok := hashFunctions[algorithm]
+	if !ok {
+		return false
+	}
+	decoded, err := hex.DecodeString(value)
+	return err == nil && len(decoded) == newHash().Size()
+}
+
+// verifyTestFile checks a downloaded file against the size, sha256,
+// checksum and fixture given for it in the config, if any
+func verifyTestFile(ts TestSet, testFile TestFile, filename string) error {
+	if testFile.Fixture != "" {
+		if err := verifyFixture(testFile, filename); err != nil {
+			return err
+		}
+	}
+	if testFile.Size == 0 && testFile.SHA256 == "" && testFile.Checksum == "" {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return classify(ErrorClassSetup, fmt.Errorf("can't stat %s: %s", filename, err))
+	}
+	payload := ESPayload{FileName: filepath.Base(filename), DownloadSize: info.Size()}
+	if testFile.SHA256 != "" || testFile.Checksum != "" {
+		if payload.digests, err = fileDigests(ts, filename); err != nil {
+			return classify(ErrorClassSetup, fmt.Errorf("can't read %s: %s", filename, err))
+		}
+	}
+	return verifyDigests(ts, testFile, payload)
+}
+
+// verifyDownload checks a download against the size, sha256, checksum and
+// fixture given for it in the config, using what the backend worked out as
+// it arrived and only reading the file back if it couldn't
+func verifyDownload(ts TestSet, testFile TestFile, filename string, payload ESPayload) error {
+	if payload.digests == nil {
+		return verifyTestFile(ts, testFile, filename)
+	}
+	if testFile.Fixture != "" {
+		if err := checkFixture(testFile, filename, payload); err != nil {
+			return err
+		}
+	}
+	return verifyDigests(ts, testFile, payload)
+}
+
+// discardBackends hash downloads with a digestWriter as they arrive, so can
+// stream them without saving them for the discard setting
+var discardBackends = map[string]bool{"native": true, "http": true, "https": true}
+
+// digestWriter hashes downloads with sha256 and the test set's hash
+// algorithm as they're written, or stands in for the file in discard mode.
+// With serverchecksum every algorithm is used as the server could report
+// any of them.  Fixtures are compared as they're written too, so the file
+// is never read back.
+type digestWriter struct {
+	hashes  map[string]hash.Hash
+	fixture *fixtureWriter
+}
+
+func newDigestWriter(ts TestSet) (digestWriter, error) {
+	newHash, ok := hashFunctions[ts.HashAlgorithm]
+	if !ok {
+		return digestWriter{}, classify(ErrorClassSetup, fmt.Errorf("unsupported hash algorithm %q", ts.HashAlgorithm))
+	}
+	w := digestWriter{hashes: map[string]hash.Hash{"sha256": sha256.New(), ts.HashAlgorithm: newHash()}}
+	if ts.ServerChecksum {
+		for algorithm, newHash := range hashFunctions {
+			w.hashes[algorithm] = newHash()
+		}
+	}
+	if ts.fixture != nil {
+		w.fixture = newFixtureWriter(*ts.fixture)
+	}
+	return w, nil
+}
+
+func (w digestWriter) Write(p []byte) (int, error) {
+	for _, h := range w.hashes {
+		h.Write(p)
+	}
+	if w.fixture != nil {
+		w.fixture.Write(p)
+	}
+	return len(p), nil
+}
+
+// digests returns the hex encoded hashes of what was written
+func (w digestWriter) digests() map[string]string {
+	digests := make(map[string]string, len(w.hashes))
+	for algorithm, h := range w.hashes {
+		digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+// record saves what was worked out about the download in its payload
+func (w digestWriter) record(payload *ESPayload) {
+	payload.digests = w.digests()
+	payload.fixture = w.fixture
+}
+
+// fileDigests hashes a file saved by a backend that can't hash downloads as
+// they arrive, returning the same digests a digestWriter would
+func fileDigests(ts TestSet, filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	digester, err := newDigestWriter(ts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(digester, f); err != nil {
+		return nil, err
+	}
+	return digester.digests(), nil
+}
+
+// verifyDigests checks a download against the size, sha256 and checksum
+// given for it in the config, if any, using the size and hashes worked out
+// while it was downloaded
+func verifyDigests(ts TestSet, testFile TestFile, payload ESPayload) error {
+	if testFile.Size > 0 && payload.DownloadSize != int64(testFile.Size) {
+		return sizeError(payload.FileName, payload.DownloadSize, int64(testFile.Size))
+	}
+	if sum := payload.digests["sha256"]; testFile.SHA256 != "" && sum != strings.ToLower(testFile.SHA256) {
+		return classify(ErrorClassChecksum, fmt.Errorf("%s has sha256 %s, expected %s", payload.FileName, sum, testFile.SHA256))
+	}
+	if sum := payload.digests[ts.HashAlgorithm]; testFile.Checksum != "" && sum != strings.ToLower(testFile.Checksum) {
+		return classify(ErrorClassChecksum, fmt.Errorf("%s has %s %s, expected %s", payload.FileName, ts.HashAlgorithm, sum, testFile.Checksum))
+	}
+	return nil
+}
+
+// checkDigests checks the hashes of the downloads against the hash file in
+// the working directory, marking the files that don't match or aren't
+// listed.  A hash file without any entries for the test set's algorithm
+// fails the whole test set.
+func checkDigests(ts TestSet, files []FileSummary, digests map[string]string) error {
+	contents, err := ioutil.ReadFile(ts.localPath(ts.HashFile))
+	if err != nil {
+		return classify(ErrorClassHashFile, fmt.Errorf("can't read file hash: %s", err))
+	}
+	hashes, err := parseHashFile(ts, string(contents))
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return classify(ErrorClassHashFile, fmt.Errorf("%s has no %s hashes in %s format", ts.HashFile, ts.HashAlgorithm, ts.HashFormat))
+	}
+	var mismatched, missing []string
+	for i := range files {
+		name := filepath.Base(files[i].Path)
+		// files that failed already aren't checked again
+		digest, downloaded := digests[name]
+		if !downloaded || files[i].ErrorClass != "" {
+			continue
+		}
+		expected, listed := hashes[name]
+		switch {
+		case !listed:
+			files[i].Error = fmt.Sprintf("%s: not in %s", name, ts.HashFile)
+			missing = append(missing, name)
+		case digest != expected:
+			files[i].Error = fmt.Sprintf("%s: FAILED", name)
+			mismatched = append(mismatched, name)
+		default:
+			continue
+		}
+		files[i].Status = "Failure"
+		files[i].ErrorClass = ErrorClassChecksum
+		files[i].FailureCategory = FailureChecksum
+	}
+	var problems []string
+	if len(mismatched) > 0 {
+		problems = append(problems, fmt.Sprintf("%s don't match %s", strings.Join(mismatched, ", "), ts.HashFile))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf("%s aren't listed in %s", strings.Join(missing, ", "), ts.HashFile))
+	}
+	if len(problems) > 0 {
+		return classify(ErrorClassChecksum, fmt.Errorf("can't verify file hashes: %s", strings.Join(problems, ", ")))
+	}
+	return nil
+}
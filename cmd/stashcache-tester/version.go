@@ -0,0 +1,72 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build metadata, set at build time with
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+func init() {
+	// fall back to the vcs information go embeds when built from a checkout
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if commit == "" && len(setting.Value) >= 7 {
+				commit = setting.Value[:7]
+			}
+		case "vcs.time":
+			if buildDate == "" {
+				buildDate = setting.Value
+			}
+		}
+	}
+}
+
+// versionString describes the build for --version and the version command
+func versionString() string {
+	s := "stashcache-tester " + version
+	if commit != "" {
+		s += fmt.Sprintf(" (commit %s", commit)
+		if buildDate != "" {
+			s += ", built " + buildDate
+		}
+		s += ")"
+	} else if buildDate != "" {
+		s += fmt.Sprintf(" (built %s)", buildDate)
+	}
+	return s
+}
+
+// clientVersion identifies the tester in payloads, prefix distinguishes file
+// transfer payloads from test set results
+func clientVersion(prefix string) string {
+	return prefix + "/" + version
+}
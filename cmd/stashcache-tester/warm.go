@@ -0,0 +1,58 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// warmDownload downloads a test file a second time straight after the
+// first, when the cache should serve it from its disk rather than the
+// origin, recording the warm download time and the speedup over the cold
+// one in the payload.  A cache that never caches shows no speedup.  The
+// warm copy is checked like the cold one and must hash the same, a cache
+// can corrupt what it stored.
+func warmDownload(ctx context.Context, ts TestSet, testFile TestFile, remotePath string, filename string, payload *ESPayload) error {
+	// xrdcp won't overwrite the cold download
+	os.Remove(filename)
+	uri, warm, _, err := downloadTestPath(ctx, ts, remotePath, filename, testFile.timeout(ts))
+	if err != nil {
+		return classify(errorClass(err), fmt.Errorf("warm download of %s failed: %s", uri, err))
+	}
+	if warm.DownloadSize != payload.DownloadSize {
+		return classify(ErrorClassSize, fmt.Errorf("warm download of %s is %d bytes, the cold one was %d", uri, warm.DownloadSize, payload.DownloadSize))
+	}
+	if warm.digests == nil {
+		if warm.digests, err = fileDigests(ts, filename); err != nil {
+			return classify(ErrorClassSetup, fmt.Errorf("can't hash the warm download of %s: %s", uri, err))
+		}
+	}
+	if err := verifyDigests(ts, testFile, warm); err != nil {
+		return classify(errorClass(err), fmt.Errorf("warm download of %s: %s", uri, err))
+	}
+	if cold, sum := payload.digests[ts.HashAlgorithm], warm.digests[ts.HashAlgorithm]; sum != cold {
+		return classify(ErrorClassChecksum, fmt.Errorf("warm download of %s has %s %s, the cold one had %s", uri, ts.HashAlgorithm, sum, cold))
+	}
+	payload.WarmDownloadTime = warm.DownloadTime
+	if warm.DownloadTime > 0 {
+		payload.CacheSpeedup = payload.DownloadTime / warm.DownloadTime
+	}
+	debugf("Downloaded %s cold in %.0fms and warm in %.0fms, a %.1fx speedup\n", uri, payload.DownloadTime, warm.DownloadTime, payload.CacheSpeedup)
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// webdavBackend runs curl or davix-get against a cache's HTTPS/WebDAV
+// interface, for caches that don't expose xrootd
+type webdavBackend struct {
+	command string
+}
+
+func init() {
+	registerBackend("curl", webdavBackend{"curl"})
+	registerBackend("davix", webdavBackend{"davix-get"})
+}
+
+func (b webdavBackend) URL(ts TestSet, remotePath string) string {
+	return httpBackend{"https"}.URL(ts, remotePath)
+}
+
+func (b webdavBackend) Download(ctx context.Context, uri string, filename string, ts TestSet, timeout time.Duration) (ESPayload, error) {
+	var args []string
+	certDir := os.Getenv("X509_CERT_DIR")
+	if certDir == "" {
+		certDir = gridCertDir
+	}
+	if info, err := os.Stat(certDir); err != nil || !info.IsDir() {
+		certDir = ""
+	}
+	switch b.command {
+	case "curl":
+		args = []string{"--fail", "--silent", "--show-error", "--location", "--output", filename}
+		if ts.address != "" {
+			args = append(args, "--resolve", fmt.Sprintf("%s:%d:%s", ts.hostname(), ts.port("https"), ts.host()))
+		}
+		if ts.Proxy == ProxyDirect {
+			args = append(args, "--noproxy", "*")
+		} else if ts.Proxy != "" {
+			args = append(args, "--proxy", ts.Proxy)
+		}
+		if certDir != "" {
+			args = append(args, "--capath", certDir)
+		}
+		if limit := clientRateLimit(ts); limit > 0 {
+			args = append(args, "--limit-rate", strconv.FormatInt(int64(limit), 10))
+		}
+		args = append(args, uri)
+	default:
+		if certDir != "" {
+			args = append(args, "--capath", certDir)
+		}
+		args = append(args, uri, filename)
+	}
+	var inspect func(output string, payload *ESPayload)
+	if b.command == "curl" {
+		inspect = func(output string, payload *ESPayload) {
+			payload.Proxy = proxyFor(ts, uri)
+			recordRateLimits(ts, payload)
+		}
+	}
+	return execDownload(ctx, b.command, args, nil, inspect, uri, filename, ts, timeout)
+}
@@ -0,0 +1,147 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the webdav test type checks a cache's HTTPS frontend without transferring
+// data, sending OPTIONS, HEAD and PROPFIND for each test file
+func init() {
+	registerTestType("webdav", runWebDAVProbe)
+}
+
+// WebDAVProbe records how a cache answered the probe requests
+type WebDAVProbe struct {
+	OptionsStatus  int      `json:"options_status"`
+	HeadStatus     int      `json:"head_status"`
+	PropfindStatus int      `json:"propfind_status"`
+	Allow          []string `json:"allow,omitempty"`
+	DAV            string   `json:"dav,omitempty"`
+	Server         string   `json:"server,omitempty"`
+}
+
+// propfindBody asks for the properties clients such as davix use
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:"><D:prop><D:resourcetype/><D:getcontentlength/><D:getlastmodified/></D:prop></D:propfind>`
+
+func runWebDAVProbe(ctx context.Context, ts TestSet) TestResult {
+	result := TestResult{success: true}
+	result.files = make([]FileSummary, len(ts.TestFiles))
+	for i, testFile := range ts.TestFiles {
+		result.files[i] = FileSummary{Path: testFile.Path, Status: StatusNotRun}
+	}
+	for i, testFile := range ts.TestFiles {
+		uri := httpBackend{"https"}.URL(ts, testFile.Path)
+		result.files[i].URL = uri
+		payload := newFilePayload("stashcache-tester-webdav", ts, testFile.Path)
+		payload.TestType = ts.Type
+		start := time.Now()
+		payload.Start1 = unixMillis(start)
+		payload.Tries = 1
+		payload.Proxy = proxyFor(ts, uri)
+		probe, err := probeWebDAV(ctx, ts, uri, testFile.timeout(ts))
+		end := time.Now()
+		payload.End1 = unixMillis(end)
+		payload.DownloadTime = end.Sub(start).Seconds() * 1000
+		payload.TimeStamp = nowMillis()
+		payload.WebDAV = &probe
+		result.files[i].Duration = end.Sub(start)
+		if err != nil {
+			fmt.Printf("WebDAV probe of %s failed: %s\n", uri, err)
+			payload.Status = "Failure"
+			payload.Error = err.Error()
+			ReportTest(ctx, payload, ts.Collector)
+			result.files[i].Status = payload.Status
+			result.files[i].setError(err)
+			result.fail(classify(errorClass(err), fmt.Errorf("webdav probe of %s failed: %s", uri, err)))
+			continue
+		}
+		debugf("%s: OPTIONS %d, HEAD %d, PROPFIND %d, DAV %q, Allow %s\n", uri, probe.OptionsStatus,
+			probe.HeadStatus, probe.PropfindStatus, probe.DAV, strings.Join(probe.Allow, ","))
+		payload.Status = "Success"
+		result.files[i].Status = payload.Status
+		ReportTest(ctx, payload, ts.Collector)
+	}
+	return result.aggregate()
+}
+
+// probeWebDAV sends the probe requests for uri, returning an error if the
+// frontend doesn't behave like a WebDAV server serving the file
+func probeWebDAV(ctx context.Context, ts TestSet, uri string, timeout time.Duration) (WebDAVProbe, error) {
+	var probe WebDAVProbe
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	send := func(method string, body string, header http.Header) (*http.Response, error) {
+		req, err := http.NewRequest(method, uri, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		req.Header.Set("User-Agent", clientVersion("stashcache-tester"))
+		resp, err := sharedHTTPClient().Do(req.WithContext(requestContext(ctx, ts)))
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, classify(ErrorClassTimeout, fmt.Errorf("%s %s timed out", method, uri))
+		}
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return resp, nil
+	}
+
+	resp, err := send("OPTIONS", "", nil)
+	if err != nil {
+		return probe, err
+	}
+	probe.OptionsStatus = resp.StatusCode
+	probe.DAV = resp.Header.Get("DAV")
+	probe.Server = resp.Header.Get("Server")
+	for _, allow := range resp.Header["Allow"] {
+		for _, method := range strings.Split(allow, ",") {
+			if method = strings.TrimSpace(method); method != "" {
+				probe.Allow = append(probe.Allow, method)
+			}
+		}
+	}
+
+	if resp, err = send("HEAD", "", nil); err != nil {
+		return probe, err
+	}
+	probe.HeadStatus = resp.StatusCode
+
+	header := http.Header{"Depth": {"0"}, "Content-Type": {"application/xml"}}
+	if resp, err = send("PROPFIND", propfindBody, header); err != nil {
+		return probe, err
+	}
+	probe.PropfindStatus = resp.StatusCode
+
+	switch {
+	case probe.HeadStatus != http.StatusOK:
+		return probe, fmt.Errorf("HEAD returned %d", probe.HeadStatus)
+	case probe.PropfindStatus != http.StatusMultiStatus:
+		return probe, fmt.Errorf("PROPFIND returned %d, expected 207", probe.PropfindStatus)
+	}
+	return probe, nil
+}
@@ -0,0 +1,64 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xrdfs runs an xrdfs command against host, returning its trimmed output
+func xrdfs(ctx context.Context, ts TestSet, host string, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	args = append([]string{host}, args...)
+	cmd := exec.CommandContext(ctx, "xrdfs", args...)
+	cmd.Env = append(os.Environ(), xrdcpEnv(ts.XRDEnv)...)
+	debugf("Running %s\n", commandLine(xrdcpEnv(ts.XRDEnv), "xrdfs", args...))
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", classify(ErrorClassTimeout, fmt.Errorf("xrdfs %s timed out", strings.Join(args, " ")))
+	}
+	if err != nil {
+		return "", fmt.Errorf("xrdfs %s failed: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// queryChecksum asks host for its checksum of remotePath, returned as the
+// "<algorithm> <value>" xrdfs prints
+func queryChecksum(ctx context.Context, ts TestSet, host string, remotePath string, timeout time.Duration) (string, error) {
+	return xrdfs(ctx, ts, host, timeout, "query", "checksum", remotePath)
+}
+
+// parseStatSize returns the size from "xrdfs stat" output and whether there
+// was one, an empty file's size is 0 too
+func parseStatSize(output string) (int64, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "Size:" {
+			size, err := strconv.ParseInt(fields[1], 10, 64)
+			return size, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,329 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// A minimal xrootd protocol client, enough to open a file anonymously and
+// send requests the xrdcp and xrdfs commands can't, such as kXR_readv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// request and response codes from the xrootd protocol
+const (
+	kXRClose    = 3003
+	kXRLogin    = 3007
+	kXRProtocol = 3006
+	kXROpen     = 3010
+	kXRReadV    = 3025
+	kXROK       = 0
+	kXROKSoFar  = 4000
+	kXRError    = 4003
+	kXRRedirect = 4004
+	kXRWait     = 4005
+
+	kXROpenRead = 0x0010
+
+	// flags in the kXR_protocol response
+	kXRIsServer  = 0x00000001
+	kXRIsManager = 0x00000002
+	kXRAttrMeta  = 0x00000100
+	kXRAttrProxy = 0x00000200
+	kXRAttrSuper = 0x00000400
+	kXRHaveTLS   = 0x80000000
+	kXRGotoTLS   = 0x40000000
+)
+
+// xrdClientProtocol is the protocol version the client claims, 5.2.0
+const xrdClientProtocol = 0x00000520
+
+// formatXRDVersion formats a protocol version such as 0x520 as 5.2.0
+func formatXRDVersion(version uint32) string {
+	return fmt.Sprintf("%x.%x.%x", version>>8, version>>4&0xf, version&0xf)
+}
+
+// xrdConn is a logged in connection to an xrootd server
+type xrdConn struct {
+	conn net.Conn
+	// from the handshake, serverType is 0 for a redirector and 1 for a
+	// data server
+	protocolVersion uint32
+	serverType      uint32
+}
+
+// dialXRootD connects to addr, does the handshake and logs in without
+// credentials, failing if the server wants authentication
+func dialXRootD(addr string, deadline time.Time) (*xrdConn, error) {
+	c, err := connectXRootD(addr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	conn := c.conn
+
+	var params [16]byte
+	binary.BigEndian.PutUint32(params[0:], uint32(os.Getpid()))
+	copy(params[4:12], "stashtst")
+	params[14] = 5 // capver, after ability2 and ability: protocol version 5
+	body, err := c.request(kXRLogin, params, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("login to %s failed: %s", addr, err)
+	}
+	if len(body) > 16 {
+		conn.Close()
+		return nil, fmt.Errorf("%s requires authentication, which isn't supported", addr)
+	}
+	return c, nil
+}
+
+// connectXRootD connects to addr and does the initial handshake, recording
+// the protocol version and server type the server gives in reply
+func connectXRootD(addr string, deadline time.Time) (*xrdConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Until(deadline))
+	if err != nil {
+		return nil, err
+	}
+	c := &xrdConn{conn: conn}
+	conn.SetDeadline(deadline)
+	handshake := make([]byte, 20)
+	binary.BigEndian.PutUint32(handshake[12:], 4)
+	binary.BigEndian.PutUint32(handshake[16:], 2012)
+	if _, err := conn.Write(handshake); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s failed: %s", addr, err)
+	}
+	body, err := c.readResponse()
+	if err == nil && len(body) < 8 {
+		err = fmt.Errorf("short response")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("handshake with %s failed: %s", addr, err)
+	}
+	c.protocolVersion = binary.BigEndian.Uint32(body)
+	c.serverType = binary.BigEndian.Uint32(body[4:])
+	return c, nil
+}
+
+// protocol sends kXR_protocol, returning the server's protocol version and
+// the kXR_is* and kXR_attr* flags describing it
+func (c *xrdConn) protocol() (uint32, uint32, error) {
+	var params [16]byte
+	binary.BigEndian.PutUint32(params[0:], xrdClientProtocol)
+	body, err := c.request(kXRProtocol, params, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(body) < 8 {
+		return 0, 0, fmt.Errorf("short kXR_protocol response")
+	}
+	return binary.BigEndian.Uint32(body), binary.BigEndian.Uint32(body[4:]), nil
+}
+
+func (c *xrdConn) Close() error {
+	return c.conn.Close()
+}
+
+// request sends a request and returns the response body, collecting
+// partial (kXR_oksofar) responses
+func (c *xrdConn) request(id uint16, params [16]byte, data []byte) ([]byte, error) {
+	header := make([]byte, 24, 24+len(data))
+	binary.BigEndian.PutUint16(header[0:], 1) // stream id
+	binary.BigEndian.PutUint16(header[2:], id)
+	copy(header[4:20], params[:])
+	binary.BigEndian.PutUint32(header[20:], uint32(len(data)))
+	if _, err := c.conn.Write(append(header, data...)); err != nil {
+		return nil, err
+	}
+	var body []byte
+	for {
+		status, part, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, part...)
+		if status != kXROKSoFar {
+			return body, statusError(status, part)
+		}
+	}
+}
+
+// readResponse reads a single response, e.g. to the handshake
+func (c *xrdConn) readResponse() ([]byte, error) {
+	status, body, err := c.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	return body, statusError(status, body)
+}
+
+func (c *xrdConn) readFrame() (uint16, []byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, header); err != nil {
+		return 0, nil, err
+	}
+	status := binary.BigEndian.Uint16(header[2:])
+	body := make([]byte, binary.BigEndian.Uint32(header[4:]))
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		return 0, nil, err
+	}
+	return status, body, nil
+}
+
+// statusError turns a response status into an error, nil for kXR_ok
+func statusError(status uint16, body []byte) error {
+	switch status {
+	case kXROK, kXROKSoFar:
+		return nil
+	case kXRError:
+		if len(body) >= 4 {
+			return fmt.Errorf("server error %d: %s", binary.BigEndian.Uint32(body),
+				string(bytes.TrimRight(body[4:], "\x00")))
+		}
+	case kXRRedirect:
+		if len(body) >= 4 {
+			return redirectError(body)
+		}
+	case kXRWait:
+		return fmt.Errorf("server asked the client to wait")
+	}
+	return fmt.Errorf("unexpected response status %d", status)
+}
+
+// xrdRedirect is the error for a kXR_redirect response, giving the server
+// to send the request to instead
+type xrdRedirect struct {
+	addr string
+}
+
+func (r xrdRedirect) Error() string {
+	return "server redirected to " + r.addr
+}
+
+// redirectError parses a kXR_redirect response body, a port followed by the
+// host and any opaque data
+func redirectError(body []byte) error {
+	port := int32(binary.BigEndian.Uint32(body))
+	host := string(bytes.TrimRight(body[4:], "\x00"))
+	if i := strings.IndexByte(host, '?'); i >= 0 {
+		host = host[:i]
+	}
+	if port <= 0 {
+		return fmt.Errorf("server redirected to %s without a port", host)
+	}
+	return xrdRedirect{net.JoinHostPort(host, strconv.Itoa(int(port)))}
+}
+
+// maxRedirects is how many redirects openRedirected follows
+const maxRedirects = 5
+
+// openRedirected connects to addr and opens remotePath, following a
+// redirector to the data server that has the file.  It returns the
+// connection and file handle on that server and its address.
+func openRedirected(addr string, remotePath string, deadline time.Time) (*xrdConn, [4]byte, string, error) {
+	for i := 0; ; i++ {
+		conn, err := dialXRootD(addr, deadline)
+		if err != nil {
+			return nil, [4]byte{}, addr, err
+		}
+		handle, err := conn.open(remotePath)
+		if err == nil {
+			return conn, handle, addr, nil
+		}
+		conn.Close()
+		redirect, ok := err.(xrdRedirect)
+		if !ok {
+			return nil, handle, addr, err
+		}
+		if i == maxRedirects {
+			return nil, handle, addr, fmt.Errorf("can't open %s: more than %d redirects", remotePath, maxRedirects)
+		}
+		debugf("%s redirected the open of %s to %s\n", addr, remotePath, redirect.addr)
+		addr = redirect.addr
+	}
+}
+
+// open opens remotePath for reading, returning its file handle
+func (c *xrdConn) open(remotePath string) ([4]byte, error) {
+	var handle [4]byte
+	var params [16]byte
+	binary.BigEndian.PutUint16(params[2:], kXROpenRead)
+	body, err := c.request(kXROpen, params, []byte(remotePath))
+	if _, ok := err.(xrdRedirect); ok {
+		return handle, err
+	}
+	if err != nil {
+		return handle, fmt.Errorf("can't open %s: %s", remotePath, err)
+	}
+	if len(body) < 4 {
+		return handle, fmt.Errorf("can't open %s: short response", remotePath)
+	}
+	copy(handle[:], body)
+	return handle, nil
+}
+
+func (c *xrdConn) close(handle [4]byte) error {
+	var params [16]byte
+	copy(params[:], handle[:])
+	_, err := c.request(kXRClose, params, nil)
+	return err
+}
+
+// readChunk is one segment of a vector read
+type readChunk struct {
+	offset int64
+	data   []byte
+}
+
+// readv reads the chunks of an open file given by chunks' offsets and
+// lengths in one kXR_readv request, returning the chunks in the order the
+// server sent them
+func (c *xrdConn) readv(handle [4]byte, chunks []RangeRead) ([]readChunk, error) {
+	list := make([]byte, 16*len(chunks))
+	for i, chunk := range chunks {
+		copy(list[16*i:], handle[:])
+		binary.BigEndian.PutUint32(list[16*i+4:], uint32(chunk.Length))
+		binary.BigEndian.PutUint64(list[16*i+8:], uint64(chunk.Offset))
+	}
+	body, err := c.request(kXRReadV, [16]byte{}, list)
+	if err != nil {
+		return nil, err
+	}
+	var read []readChunk
+	for len(body) > 0 {
+		if len(body) < 16 {
+			return read, fmt.Errorf("truncated readv response")
+		}
+		length := int64(binary.BigEndian.Uint32(body[4:]))
+		offset := int64(binary.BigEndian.Uint64(body[8:]))
+		body = body[16:]
+		if length > int64(len(body)) {
+			return read, fmt.Errorf("readv response chunk of %d bytes at %d is longer than the response", length, offset)
+		}
+		read = append(read, readChunk{offset, body[:length]})
+		body = body[length:]
+	}
+	return read, nil
+}
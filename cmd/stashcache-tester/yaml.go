@@ -0,0 +1,424 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+// A small YAML reader covering the subset used by config files: block
+// mappings and sequences, single line flow collections, quoted and plain
+// scalars and comments.  Anchors, tags, multi-document streams and block
+// scalars (| and >) aren't supported and are reported as errors.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type YAMLError struct {
+	Line int
+	Msg  string
+}
+
+func (e *YAMLError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML decodes YAML into the same generic values encoding/json
+// produces (map[string]interface{}, []interface{}, string, float64, bool
+// and nil) so the result can be re-encoded as JSON
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, &YAMLError{i + 1, "tabs can't be used for indentation"}
+		}
+		text, err := stripYAMLComment(trimmed)
+		if err != nil {
+			return nil, &YAMLError{i + 1, err.Error()}
+		}
+		if text == "" {
+			continue
+		}
+		if text == "---" && len(p.lines) == 0 {
+			continue
+		}
+		if text == "---" || text == "..." {
+			return nil, &YAMLError{i + 1, "multiple documents aren't supported"}
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, len(raw) - len(trimmed), text})
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	value, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, &YAMLError{p.lines[p.pos].number, "unexpected content, check indentation"}
+	}
+	return value, nil
+}
+
+// stripYAMLComment removes a trailing # comment that isn't inside quotes
+func stripYAMLComment(text string) (string, error) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " "), nil
+		}
+	}
+	if quote != 0 {
+		return "", fmt.Errorf("unterminated quoted string")
+	}
+	return text, nil
+}
+
+func isYAMLSeqItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" returning ok=false if text isn't a
+// mapping entry
+func splitYAMLKey(text string) (key string, value string, ok bool, err error) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 {
+			return "", "", false, fmt.Errorf("unterminated quoted string")
+		}
+		rest := text[end+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false, nil
+		}
+		key, err = unquoteYAML(text[:end+1])
+		return key, strings.TrimSpace(rest[1:]), true, err
+	}
+	if text[0] == '[' || text[0] == '{' {
+		return "", "", false, nil
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true, nil
+	}
+	if idx := strings.Index(text, ": "); idx > 0 {
+		return strings.TrimSpace(text[:idx]), strings.TrimSpace(text[idx+2:]), true, nil
+	}
+	return "", "", false, nil
+}
+
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		if quote == '"' && text[i] == '\\' {
+			i++
+			continue
+		}
+		if text[i] == quote {
+			if quote == '\'' && i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func unquoteYAML(text string) (string, error) {
+	if text[0] == '\'' {
+		return strings.Replace(text[1:len(text)-1], "''", "'", -1), nil
+	}
+	return strconv.Unquote(text)
+}
+
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	line := p.lines[p.pos]
+	if isYAMLSeqItem(line.text) {
+		return p.parseSeq(indent)
+	}
+	_, _, isKey, err := splitYAMLKey(line.text)
+	if err != nil {
+		return nil, &YAMLError{line.number, err.Error()}
+	}
+	if isKey {
+		return p.parseMap(indent)
+	}
+	p.pos++
+	value, err := parseYAMLScalar(line.text)
+	if err != nil {
+		return nil, &YAMLError{line.number, err.Error()}
+	}
+	return value, nil
+}
+
+func (p *yamlParser) parseSeq(indent int) (interface{}, error) {
+	seq := []interface{}{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, &YAMLError{line.number, "unexpected indentation"}
+		}
+		if !isYAMLSeqItem(line.text) {
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.parseChild(indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, item)
+			continue
+		}
+		// treat the text after "- " as a line of its own so that
+		// mappings can start on the same line as the dash
+		itemIndent := indent + len(line.text) - len(rest)
+		p.lines[p.pos] = yamlLine{line.number, itemIndent, rest}
+		item, err := p.parseNode(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, item)
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMap(indent int) (interface{}, error) {
+	mapping := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, &YAMLError{line.number, "unexpected indentation"}
+		}
+		key, value, isKey, err := splitYAMLKey(line.text)
+		if err != nil {
+			return nil, &YAMLError{line.number, err.Error()}
+		}
+		if !isKey {
+			if isYAMLSeqItem(line.text) {
+				break
+			}
+			return nil, &YAMLError{line.number, fmt.Sprintf("expected \"key: value\", got %q", line.text)}
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, &YAMLError{line.number, fmt.Sprintf("duplicate key %q", key)}
+		}
+		p.pos++
+		if value != "" {
+			mapping[key], err = parseYAMLScalar(value)
+			if err != nil {
+				return nil, &YAMLError{line.number, err.Error()}
+			}
+			continue
+		}
+		// a sequence may sit at the same indentation as its key
+		if p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLSeqItem(p.lines[p.pos].text) {
+			mapping[key], err = p.parseSeq(indent)
+		} else {
+			mapping[key], err = p.parseChild(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mapping, nil
+}
+
+// parseChild parses the block nested under a line at indent, returning nil
+// if there isn't one
+func (p *yamlParser) parseChild(indent int) (interface{}, error) {
+	if p.pos >= len(p.lines) || p.lines[p.pos].indent <= indent {
+		return nil, nil
+	}
+	return p.parseNode(p.lines[p.pos].indent)
+}
+
+func parseYAMLScalar(text string) (interface{}, error) {
+	switch text[0] {
+	case '"', '\'':
+		end := closingQuote(text)
+		if end != len(text)-1 {
+			return nil, fmt.Errorf("unexpected text after quoted string: %q", text)
+		}
+		return unquoteYAML(text)
+	case '[', '{':
+		f := &yamlFlow{text: text}
+		value, err := f.parse()
+		if err != nil {
+			return nil, err
+		}
+		f.skipSpace()
+		if f.pos != len(f.text) {
+			return nil, fmt.Errorf("unexpected text after flow collection: %q", text[f.pos:])
+		}
+		return value, nil
+	case '|', '>':
+		return nil, fmt.Errorf("block scalars aren't supported")
+	case '&', '*', '!':
+		return nil, fmt.Errorf("anchors, aliases and tags aren't supported")
+	}
+	return plainYAMLScalar(text), nil
+}
+
+func plainYAMLScalar(text string) interface{} {
+	switch text {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return float64(i)
+	}
+	if f, err := strconv.ParseFloat(text, 64); err == nil {
+		return f
+	}
+	return text
+}
+
+// yamlFlow parses single line flow collections such as [a, b] or {a: 1}
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) parse() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, fmt.Errorf("unterminated flow collection")
+	}
+	switch f.text[f.pos] {
+	case '[':
+		f.pos++
+		seq := []interface{}{}
+		for {
+			f.skipSpace()
+			if f.pos < len(f.text) && f.text[f.pos] == ']' {
+				f.pos++
+				return seq, nil
+			}
+			item, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, item)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		mapping := make(map[string]interface{})
+		for {
+			f.skipSpace()
+			if f.pos < len(f.text) && f.text[f.pos] == '}' {
+				f.pos++
+				return mapping, nil
+			}
+			key, err := f.scalar(":")
+			if err != nil {
+				return nil, err
+			}
+			if f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return nil, fmt.Errorf("expected ':' after key %v", key)
+			}
+			f.pos++
+			value, err := f.parse()
+			if err != nil {
+				return nil, err
+			}
+			mapping[fmt.Sprint(key)] = value
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.scalar("")
+}
+
+// separator consumes a ',' or leaves the closing bracket for the caller
+func (f *yamlFlow) separator(closing byte) error {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return fmt.Errorf("unterminated flow collection")
+	}
+	if f.text[f.pos] == ',' {
+		f.pos++
+		return nil
+	}
+	if f.text[f.pos] != closing {
+		return fmt.Errorf("expected ',' or '%c' in flow collection", closing)
+	}
+	return nil
+}
+
+func (f *yamlFlow) scalar(extraStops string) (interface{}, error) {
+	f.skipSpace()
+	start := f.pos
+	if f.pos < len(f.text) && (f.text[f.pos] == '"' || f.text[f.pos] == '\'') {
+		end := closingQuote(f.text[start:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated quoted string")
+		}
+		f.pos = start + end + 1
+		return unquoteYAML(f.text[start:f.pos])
+	}
+	for f.pos < len(f.text) && !strings.ContainsRune(",]}"+extraStops, rune(f.text[f.pos])) {
+		f.pos++
+	}
+	text := strings.TrimSpace(f.text[start:f.pos])
+	if text == "" {
+		return nil, fmt.Errorf("empty value in flow collection")
+	}
+	return plainYAMLScalar(text), nil
+}